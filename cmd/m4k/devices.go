@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/abbit/m4k/internal/device"
+	"github.com/abbit/m4k/internal/log"
+)
+
+// devicesCommand prints known device profiles
+func devicesCommand(args []string) {
+	fs := flag.NewFlagSet("devices", flag.ExitOnError)
+	devices := fs.String("devices", "", "Path to JSON file with user-defined device profiles (Default: m4k/devices.json in user config directory)")
+	fs.Parse(args)
+
+	registry, err := device.LoadRegistry(*devices)
+	if err != nil {
+		log.Error.Fatalf("%v\n", err)
+	}
+	for _, p := range registry.Profiles() {
+		color := "grayscale"
+		if p.Color {
+			color = "color"
+		}
+		log.Info.Printf("%-20s %4dx%-4d %-9s %-4s %s\n", p.Name, p.Width, p.Height, color, p.PreferredFormat(), p.Title)
+	}
+}
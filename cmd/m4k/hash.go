@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+
+	"github.com/abbit/m4k/internal/filter"
+	"github.com/abbit/m4k/internal/log"
+)
+
+// hashCommand prints perceptual hashes of image files in blocklist format
+func hashCommand(args []string) {
+	if len(args) == 0 {
+		log.Error.Fatalf("usage: m4k hash <image>...\n")
+	}
+
+	for _, path := range args {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Error.Fatalf("%v\n", err)
+		}
+		hash, err := filter.DataHash(data)
+		if err != nil {
+			log.Error.Fatalf("while hashing %s: %v\n", path, err)
+		}
+		log.Info.Printf("%s %s\n", hash, path)
+	}
+}
@@ -1,29 +1,33 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"image"
 	"io"
 	"net"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/abbit/m4k/internal/cache"
 	"github.com/abbit/m4k/internal/comicbook"
+	"github.com/abbit/m4k/internal/device"
+	"github.com/abbit/m4k/internal/filter"
 	"github.com/abbit/m4k/internal/log"
+	"github.com/abbit/m4k/internal/naming"
 	"github.com/abbit/m4k/internal/protocol"
 	"github.com/abbit/m4k/internal/transform"
 	"github.com/abbit/m4k/internal/util"
 	"github.com/schollz/progressbar/v3"
 )
 
-const (
-	KindlePW5Width  = 1236 // px
-	KindlePW5Height = 1648 // px
-)
-
 // TODO: add a way to only send file without processing
 
 func saveComicBookToFile(path string, cb *comicbook.ComicBook) error {
@@ -73,7 +77,9 @@ func sendComicBookToKindle(addr string, cb *comicbook.ComicBook) error {
 		"uploading...",
 	)
 
-	return p.SendManga(cb.Name, io.TeeReader(cbReader, progress))
+	fileName := cb.FileName()
+	ext := filepath.Ext(fileName)
+	return p.SendManga(strings.TrimSuffix(fileName, ext), ext, io.TeeReader(cbReader, progress))
 }
 
 type Flags struct {
@@ -85,10 +91,47 @@ type Flags struct {
 	save       bool
 	upload     bool
 	cleanup    bool
+	duplicates string
+	split      comicbook.SplitOptions
+	cover      bool
+	coverImage string
+	dividers   bool
+	nameTmpl   string
+	pageTmpl   string
+	volumeTmpl string
+	tolerant   bool
+	onError    string
+	filter     filter.Options
+	blocklist  string
+	format     string
+	fit        string
+	pad        bool
+	padColor   string
+	maxUpscale float64
+	crop       transform.CropOptions
+	spread     string
+	keepSpread bool
+	rtl        bool
+	strip      string
+	tone       transform.ToneOptions
+	encoding   string
+	levels     int
+	dither     string
+	color      transform.ColorOptions
+	quality    int
+	budget     transform.BudgetOptions
+	pipeline   string
+	cacheDir   string
+	cacheMB    int
+	memoryMB   int
+	device     string
+	devices    string
+	// names of flags set on command line, they take precedence over device profile
+	set map[string]bool
 }
 
 func parseFlags() *Flags {
-	flags := &Flags{}
+	flags := &Flags{set: make(map[string]bool)}
 	flag.StringVar(&flags.srcdir, "src", "", "Path to directory with .cbz files")
 	flag.StringVar(&flags.name, "name", "", "Name for combined .cbz file without extension")
 	flag.StringVar(&flags.dstdir, "dst", "", "Path to directory to where save merged file (Default: same as srcdir)")
@@ -97,7 +140,61 @@ func parseFlags() *Flags {
 	flag.BoolVar(&flags.upload, "upload", false, "Upload combined file to Kindle")
 	flag.StringVar(&flags.addr, "addr", "", "Address (host or host:port) of Kindle's receiver server. If port is not specified, default 49494 will be used")
 	flag.BoolVar(&flags.cleanup, "cleanup", false, "Remove merged .cbz files")
+	flag.StringVar(&flags.duplicates, "duplicates", string(comicbook.DuplicatesKeepFirst), "Which of duplicate chapters to keep: first, largest or newest")
+	flag.IntVar(&flags.split.MaxPages, "split-pages", 0, "Split combined file into volumes of at most this many pages")
+	splitMB := flag.Int("split-mb", 0, "Split combined file into volumes of at most this many megabytes")
+	flag.IntVar(&flags.split.Chapters, "split-chapters", 0, "Split combined file into volumes of this many chapters")
+	flag.BoolVar(&flags.split.ByVolume, "split-volumes", false, "Split combined file on source volume boundaries")
+	flag.BoolVar(&flags.cover, "cover", false, "Add generated cover page with title and chapters range")
+	flag.StringVar(&flags.coverImage, "cover-image", "", "Path to image for generated cover page (Default: first page)")
+	flag.BoolVar(&flags.dividers, "dividers", false, "Add generated page before each chapter")
+	flag.StringVar(&flags.nameTmpl, "name-template", "{{.Series}}", "Template for combined file name, -name is available as {{.Series}}")
+	flag.StringVar(&flags.pageTmpl, "page-template", naming.DefaultPagePath, "Template for page paths inside of combined file")
+	flag.StringVar(&flags.volumeTmpl, "volume-template", naming.DefaultVolumeName, "Template for names of volumes combined file is split into, volume number is available as {{.Part}}")
+	flag.BoolVar(&flags.tolerant, "tolerant", false, "Recover what can be read from broken files and replace broken pages with placeholders")
+	flag.StringVar(&flags.onError, "on-error", string(transform.ErrorFail), "What to do with pages failed to transform: fail, skip or placeholder (Default: placeholder with -tolerant)")
+	flag.BoolVar(&flags.filter.Repeated, "filter-repeated", false, "Remove pages repeating across chapters, like scanlator credits and ads")
+	flag.IntVar(&flags.filter.MinChapters, "filter-chapters", filter.DefaultMinChapters, "Minimum number of chapters page should repeat in to be removed")
+	flag.IntVar(&flags.filter.MaxDistance, "filter-distance", filter.DefaultMaxDistance, "Maximum perceptual hash distance for pages to be considered the same")
+	flag.StringVar(&flags.blocklist, "blocklist", "", "Path to file with perceptual hashes of pages to remove, one per line (see 'm4k hash')")
+	flag.StringVar(&flags.device, "device", device.DefaultProfile, "Device profile to prepare pages for, see 'm4k devices'")
+	flag.StringVar(&flags.devices, "devices", "", "Path to JSON file with user-defined device profiles (Default: m4k/devices.json in user config directory)")
+	flag.StringVar(&flags.format, "format", string(comicbook.FormatCBZ), "Output format: cbz, epub, mobi, azw3 or pdf (Default: preferred by device)")
+	flag.StringVar(&flags.fit, "fit", string(transform.FitInside), "How pages are fitted into screen: inside, width, fill or stretch (Default: set by device)")
+	flag.BoolVar(&flags.pad, "pad", false, "Pad fitted pages to exact screen size (Default: set by device)")
+	flag.StringVar(&flags.padColor, "pad-color", "white", "Color of padding, e.g. white, black or #rrggbb")
+	flag.Float64Var(&flags.maxUpscale, "max-upscale", transform.DefaultMaxUpscale, "Maximum factor pages can be enlarged by (Default: set by device)")
+	flag.BoolVar(&flags.crop.Enabled, "crop", false, "Crop uniform white or black margins of pages (Default: set by device)")
+	flag.IntVar(&flags.crop.Tolerance, "crop-tolerance", transform.DefaultCropTolerance, "Maximum brightness difference (0-255) of margin pixels from margin color")
+	flag.Float64Var(&flags.crop.MinContentArea, "crop-min-area", transform.DefaultCropMinContentArea, "Content at page edges smaller than this part of page area, like page numbers, is cropped")
+	flag.Float64Var(&flags.crop.MaxTrim, "crop-max", transform.DefaultCropMaxTrim, "Maximum part of page width or height cropped from each side")
+	flag.StringVar(&flags.spread, "spread", "", "How landscape pages are laid out: double, rotate or split (Default: set by device, or rotate with -rotatepage)")
+	flag.BoolVar(&flags.keepSpread, "keep-spread", false, "Keep rotated spread before its halves with -spread split")
+	flag.BoolVar(&flags.rtl, "rtl", false, "Pages are read from right to left, as usual for manga (Default: taken from ComicInfo.xml)")
+	flag.BoolVar(&flags.tone.AutoLevels, "auto-levels", false, "Stretch levels of pages so that the darkest pixels become black and the brightest white (Default: off, unless set by device)")
+	flag.Float64Var(&flags.tone.Gamma, "gamma", 1, "Gamma correction of pages, below 1 darkens and above 1 lightens midtones (Default: off, unless set by device)")
+	flag.Float64Var(&flags.tone.Contrast, "contrast", 0, "Strength of contrast stretch of pages, e.g. 3 to 10, 0 disables it (Default: off, unless set by device)")
+	flag.StringVar(&flags.encoding, "encoding", "jpg", "Encoding of pages: jpg or png (Default: set by device)")
+	flag.IntVar(&flags.levels, "levels", 16, "Number of gray levels pages encoded as png are quantized to, 0 disables quantization (Default: gray levels of device with png encoding)")
+	flag.StringVar(&flags.dither, "dither", string(transform.DitherNone), "Dithering of quantized pages: none, floyd-steinberg or ordered (Default: set by device)")
+	flag.BoolVar(&flags.color.Enabled, "color", false, "Keep color pages in color, grayscaling the rest (Default: set if device has color screen)")
+	flag.Float64Var(&flags.color.Saturation, "saturation", 0, "Saturation change of color pages in percents, from -100 to 100 (Default: set by device)")
+	flag.IntVar(&flags.quality, "quality", 0, "JPEG quality of pages, 1-100 (Default: set by device, or 75)")
+	budgetPageKB := flag.Int("budget-page-kb", 0, "Maximum size of page in kilobytes, JPEG quality is lowered to fit it (Default: set by device)")
+	budgetBookMB := flag.Int("budget-book-mb", 0, "Maximum size of combined file in megabytes, shared equally between pages")
+	flag.IntVar(&flags.budget.MinQuality, "min-quality", transform.DefaultMinJpegQuality, "Minimum JPEG quality used to fit size budget")
+	flag.StringVar(&flags.pipeline, "pipeline", transform.DefaultPreset, fmt.Sprintf("Comma-separated stages and presets pages are passed through, stages: %s, presets: %s",
+		strings.Join(transform.StageNames(), ", "), strings.Join(transform.PresetNames(), ", ")))
+	flag.StringVar(&flags.cacheDir, "cache-dir", "", "Path to directory with cache of transformed pages (Default: m4k/pages in user cache directory)")
+	flag.IntVar(&flags.cacheMB, "cache-mb", cache.DefaultMaxBytes>>20, "Maximum size of cache of transformed pages in megabytes, 0 disables cache")
+	flag.IntVar(&flags.memoryMB, "memory-mb", transform.DefaultMemoryBytes>>20, "Maximum estimated memory of images being transformed at once in megabytes, 0 disables the limit")
+	flag.StringVar(&flags.strip, "strip", string(transform.StripAuto), "Stitch long strips (webtoons) of chapters and slice them into pages: auto, on or off")
 	flag.Parse()
+	flag.Visit(func(f *flag.Flag) { flags.set[f.Name] = true })
+
+	flags.split.MaxBytes = int64(*splitMB) << 20
+	flags.budget.PageBytes = int64(*budgetPageKB) << 10
+	flags.budget.BookBytes = int64(*budgetBookMB) << 20
 
 	// check if required options are specified
 	if flags.srcdir == "" {
@@ -130,13 +227,95 @@ func parseFlags() *Flags {
 	return flags
 }
 
+// commands other than default merging, selected by first argument
+var commands = map[string]func(args []string){
+	"split":   splitCommand,
+	"hash":    hashCommand,
+	"devices": devicesCommand,
+	"verify":  verifyCommand,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
+
+	mergeCommand()
+}
+
+func mergeCommand() {
 	flags := parseFlags()
 
 	if err := validateName(flags.name); err != nil {
 		log.Error.Fatalf("failed validating name: %v\n", err)
 	}
 
+	registry, err := device.LoadRegistry(flags.devices)
+	if err != nil {
+		log.Error.Fatalf("%v\n", err)
+	}
+	profile, err := registry.Get(flags.device)
+	if err != nil {
+		log.Error.Fatalf("%v\n", err)
+	}
+
+	format := profile.PreferredFormat()
+	if flags.set["format"] {
+		format, err = comicbook.ParseFormat(flags.format)
+		if err != nil {
+			log.Error.Fatalf("%v\n", err)
+		}
+	}
+	fitMode, err := transform.ParseFitMode(flags.fit)
+	if err != nil {
+		log.Error.Fatalf("%v\n", err)
+	}
+	padColor, err := transform.ParseColor(flags.padColor)
+	if err != nil {
+		log.Error.Fatalf("%v\n", err)
+	}
+	var spreadMode transform.SpreadMode
+	if flags.spread != "" {
+		spreadMode, err = transform.ParseSpreadMode(flags.spread)
+		if err != nil {
+			log.Error.Fatalf("%v\n", err)
+		}
+	}
+	stripMode, err := transform.ParseStripMode(flags.strip)
+	if err != nil {
+		log.Error.Fatalf("%v\n", err)
+	}
+	dither, err := transform.ParseDither(flags.dither)
+	if err != nil {
+		log.Error.Fatalf("%v\n", err)
+	}
+	pipeline, err := transform.ParsePipeline(flags.pipeline)
+	if err != nil {
+		log.Error.Fatalf("%v\n", err)
+	}
+	onError, err := transform.ParseErrorPolicy(flags.onError)
+	if err != nil {
+		log.Error.Fatalf("%v\n", err)
+	}
+	if flags.tolerant && !flags.set["on-error"] {
+		onError = transform.ErrorPlaceholder
+	}
+	nameTmpl, err := naming.Parse("name", flags.nameTmpl)
+	if err != nil {
+		log.Error.Fatalf("%v\n", err)
+	}
+	pagePathTmpl, err := naming.Parse("page", flags.pageTmpl)
+	if err != nil {
+		log.Error.Fatalf("%v\n", err)
+	}
+	volumeNameTmpl, err := naming.Parse("volume", flags.volumeTmpl)
+	if err != nil {
+		log.Error.Fatalf("%v\n", err)
+	}
+
 	log.Info.Println("Searching cbz files...")
 	cbzFiles, err := util.FilterDirFilePaths(flags.srcdir, func(p string) bool { return path.Ext(p) == ".cbz" })
 	if err != nil {
@@ -146,40 +325,193 @@ func main() {
 	log.Info.Println("Reading cbz files...")
 	var comicbooks []*comicbook.ComicBook
 	for _, path := range cbzFiles {
-		cb, err := comicbook.ReadComicBook(path)
+		cb, err := comicbook.ReadComicBookWithOptions(path, &comicbook.ReadOptions{Tolerant: flags.tolerant})
 		if err != nil {
 			log.Error.Fatalf("failed reading comicbook from path %s: %v\n", path, err)
 		}
 		comicbooks = append(comicbooks, cb)
 	}
 
+	duplicatePolicy, err := comicbook.ParseDuplicatePolicy(flags.duplicates)
+	if err != nil {
+		log.Error.Fatalf("%v\n", err)
+	}
+
 	log.Info.Println("Merging cbz files...")
-	combined := comicbook.MergeComicBooks(comicbooks, flags.name)
+	combined, mergeReport := comicbook.MergeComicBooks(comicbooks, flags.name, &comicbook.MergeOptions{
+		Duplicates: duplicatePolicy,
+	})
+	log.Info.Println(mergeReport)
 
-	log.Info.Println("Transforming combined file for Kindle...")
+	if flags.blocklist != "" {
+		flags.filter.Blocklist, err = filter.ReadBlocklist(flags.blocklist)
+		if err != nil {
+			log.Error.Fatalf("%v\n", err)
+		}
+	}
+	if flags.filter.Enabled() {
+		log.Info.Println("Filtering pages...")
+		filterReport, err := filter.FilterComicBook(combined, &flags.filter)
+		if err != nil {
+			log.Error.Fatalf("while filtering pages: %v\n", err)
+		}
+		log.Info.Printf("Removed %d pages\n", len(filterReport.Removed))
+		if len(filterReport.Removed) > 0 {
+			log.Info.Println(filterReport)
+		}
+	}
+
+	combined.Series = flags.name
+	combined.PagePath = pagePathTmpl
+	combined.VolumeName = volumeNameTmpl
+	combined.Format = format
+	if flags.rtl {
+		combined.Metadata.RightToLeft = true
+	}
+	combined.Viewport = image.Pt(profile.Width, profile.Height)
+	combined.Name, err = nameTmpl.Execute(combined.Fields())
+	if err != nil {
+		log.Error.Fatalf("while formatting combined file name: %v\n", err)
+	}
+
+	synthOpts := profile.SynthOptions()
+	if flags.dividers {
+		log.Info.Println("Adding divider pages...")
+		if err := comicbook.AddDividerPages(combined, synthOpts); err != nil {
+			log.Error.Fatalf("while adding divider pages: %v\n", err)
+		}
+	}
+	if flags.cover {
+		if flags.coverImage != "" {
+			synthOpts.CoverImage, err = os.ReadFile(flags.coverImage)
+			if err != nil {
+				log.Error.Fatalf("while reading cover image: %v\n", err)
+			}
+		}
+
+		log.Info.Println("Adding cover page...")
+		if err := comicbook.AddCoverPage(combined, synthOpts); err != nil {
+			log.Error.Fatalf("while adding cover page: %v\n", err)
+		}
+	}
+
+	log.Info.Printf("Transforming combined file for %s...\n", profile.Title)
 	progress := progressbar.Default(int64(len(combined.Pages)), "Transforming pages...")
-	transformOpts := &transform.Options{
-		Rotate:   flags.rotatepage,
-		Width:    KindlePW5Width,
-		Height:   KindlePW5Height,
-		Encoding: "jpg",
-		Callback: func() { progress.Add(1) },
-	}
-	if err := transform.TransformComicBook(combined, transformOpts); err != nil {
+	transformOpts := profile.TransformOptions()
+	transformOpts.Rotate = flags.rotatepage
+	transformOpts.Tolerant = flags.tolerant
+	transformOpts.PadColor = padColor
+	transformOpts.KeepSpread = flags.keepSpread
+	transformOpts.Strip = stripMode
+	transformOpts.OnError = onError
+	var stats encodingStats
+	transformOpts.Callback = func(e transform.Event) {
+		switch e.Kind {
+		case transform.EventPageFinished, transform.EventError:
+			stats.add(e)
+			progress.Describe(stats.progress())
+			progress.Add(1)
+		}
+	}
+	// page size limit of reading app is kept, unless set by flag
+	maxPageBytes := transformOpts.Budget.PageBytes
+	transformOpts.Budget = flags.budget
+	if !flags.set["budget-page-kb"] {
+		transformOpts.Budget.PageBytes = maxPageBytes
+	}
+	transformOpts.Pipeline = pipeline
+	transformOpts.Cache = openCache(flags)
+	if flags.memoryMB > 0 {
+		transformOpts.Scheduler = transform.NewScheduler(int64(flags.memoryMB) << 20)
+	}
+	if flags.set["quality"] {
+		transformOpts.JpegQuality = flags.quality
+	}
+	cropEnabled := transformOpts.Crop.Enabled
+	transformOpts.Crop = flags.crop
+	if !flags.set["crop"] {
+		transformOpts.Crop.Enabled = cropEnabled
+	}
+	if flags.set["fit"] || len(transformOpts.Fit) == 0 {
+		transformOpts.Fit = fitMode
+	}
+	if flags.set["pad"] {
+		transformOpts.Pad = flags.pad
+	}
+	if flags.set["max-upscale"] {
+		transformOpts.MaxUpscale = flags.maxUpscale
+	}
+	if flags.set["auto-levels"] {
+		transformOpts.Tone.AutoLevels = flags.tone.AutoLevels
+	}
+	if flags.set["gamma"] {
+		transformOpts.Tone.Gamma = flags.tone.Gamma
+	}
+	if flags.set["contrast"] {
+		transformOpts.Tone.Contrast = flags.tone.Contrast
+	}
+	if flags.set["encoding"] {
+		transformOpts.Encoding = flags.encoding
+		if transformOpts.Encoding == "png" {
+			transformOpts.Levels = profile.Levels()
+			if !flags.set["budget-page-kb"] {
+				transformOpts.Budget.PageBytes = 0
+			}
+		} else {
+			transformOpts.Levels = 0
+		}
+	}
+	if flags.set["levels"] {
+		transformOpts.Levels = flags.levels
+	}
+	if flags.set["dither"] {
+		transformOpts.Dither = dither
+	}
+	if flags.set["color"] {
+		transformOpts.Color.Enabled = flags.color.Enabled
+	}
+	if flags.set["saturation"] {
+		transformOpts.Color.Saturation = flags.color.Saturation
+	}
+	if flags.set["spread"] || flags.rotatepage {
+		transformOpts.Spread = spreadMode
+	}
+	// stop transforming on Ctrl+C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err = transform.TransformComicBook(ctx, combined, transformOpts)
+	stop()
+	if errors.Is(err, context.Canceled) {
+		log.Error.Fatalf("Transforming canceled\n")
+	}
+	if err != nil {
 		log.Error.Fatalf("while transforming pages: %v\n", err)
 	}
+	if transformOpts.Color.Enabled {
+		log.Info.Printf("Kept %d of %d pages in color\n", combined.ColorPages(), len(combined.Pages))
+	}
+	log.Info.Println(&stats)
+	if combined.Repairs.HasIssues() {
+		log.Info.Printf("Repaired problems:\n%s\n", combined.Repairs)
+	}
 
-	if flags.save {
-		log.Info.Println("Saving combined file...")
-		if err := saveComicBookToFile(flags.dstdir, combined); err != nil {
-			log.Error.Fatalf("while saving combined file: %v\n", err)
-		}
+	volumes := comicbook.SplitComicBook(combined, &flags.split)
+	if len(volumes) > 1 {
+		log.Info.Printf("Split combined file into %d volumes\n", len(volumes))
 	}
 
-	if flags.upload {
-		log.Info.Println("Uploading combined file to Kindle...")
-		if err := sendComicBookToKindle(flags.addr, combined); err != nil {
-			log.Error.Fatalf("while sending to Kindle: %v\n", err)
+	for _, volume := range volumes {
+		if flags.save {
+			log.Info.Printf("Saving %s...\n", volume.FileName())
+			if err := saveComicBookToFile(flags.dstdir, volume); err != nil {
+				log.Error.Fatalf("while saving combined file: %v\n", err)
+			}
+		}
+
+		if flags.upload {
+			log.Info.Printf("Uploading %s to Kindle...\n", volume.FileName())
+			if err := sendComicBookToKindle(flags.addr, volume); err != nil {
+				log.Error.Fatalf("while sending to Kindle: %v\n", err)
+			}
 		}
 	}
 
@@ -193,6 +525,29 @@ func main() {
 	log.Info.Println("Done!")
 }
 
+// openCache opens cache of transformed pages, transforming without it if it can't be opened
+func openCache(flags *Flags) *cache.Cache {
+	if flags.cacheMB <= 0 {
+		return nil
+	}
+
+	dir := flags.cacheDir
+	if dir == "" {
+		var err error
+		dir, err = cache.DefaultDir()
+		if err != nil {
+			log.Error.Printf("can't use cache of transformed pages: %v\n", err)
+			return nil
+		}
+	}
+	c, err := cache.Open(dir, int64(flags.cacheMB)<<20)
+	if err != nil {
+		log.Error.Printf("can't use cache of transformed pages: %v\n", err)
+		return nil
+	}
+	return c
+}
+
 var disallowedSymbols = []rune("/:")
 
 func validateName(name string) error {
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+
+	"github.com/abbit/m4k/internal/comicbook"
+	"github.com/abbit/m4k/internal/log"
+)
+
+type splitFlags struct {
+	src    string
+	dstdir string
+	by     string
+}
+
+func parseSplitFlags(args []string) *splitFlags {
+	flags := &splitFlags{}
+	fs := flag.NewFlagSet("split", flag.ExitOnError)
+	fs.StringVar(&flags.src, "src", "", "Path to combined .cbz file")
+	fs.StringVar(&flags.dstdir, "dst", "", "Path to directory to where save split files (Default: same as src directory)")
+	fs.StringVar(&flags.by, "by", "chapter", "Split into chapters or volumes: chapter or volume")
+	fs.Parse(args)
+
+	if flags.src == "" {
+		log.Error.Fatalf("-src option is required.\n")
+	}
+	if flags.by != "chapter" && flags.by != "volume" {
+		log.Error.Fatalf("-by option must be chapter or volume.\n")
+	}
+
+	if flags.dstdir == "" {
+		flags.dstdir = filepath.Dir(flags.src)
+	}
+
+	return flags
+}
+
+// splitCommand splits combined file back into chapters or volumes
+// using directory structure or ComicInfo.xml bookmarks
+func splitCommand(args []string) {
+	flags := parseSplitFlags(args)
+
+	log.Info.Println("Reading combined file...")
+	cb, err := comicbook.ReadComicBook(flags.src)
+	if err != nil {
+		log.Error.Fatalf("failed reading comicbook from path %s: %v\n", flags.src, err)
+	}
+
+	var books []*comicbook.ComicBook
+	if flags.by == "volume" {
+		books, err = comicbook.SplitIntoVolumes(cb)
+	} else {
+		books, err = comicbook.SplitIntoChapters(cb)
+	}
+	if err != nil {
+		log.Error.Fatalf("while splitting %s by %s: %v\n", flags.src, flags.by, err)
+	}
+	log.Info.Printf("Split combined file into %d files\n", len(books))
+
+	// split files must never overwrite the combined file
+	src, err := filepath.Abs(flags.src)
+	if err != nil {
+		log.Error.Fatalf("%v\n", err)
+	}
+	for _, book := range books {
+		dst, err := filepath.Abs(filepath.Join(flags.dstdir, book.FileName()))
+		if err != nil {
+			log.Error.Fatalf("%v\n", err)
+		}
+		if dst == src {
+			log.Error.Fatalf("split file %s would overwrite combined file, use -dst to save it elsewhere\n", book.FileName())
+		}
+	}
+
+	for _, book := range books {
+		log.Info.Printf("Saving %s...\n", book.FileName())
+		if err := saveComicBookToFile(flags.dstdir, book); err != nil {
+			log.Error.Fatalf("while saving split file: %v\n", err)
+		}
+	}
+
+	log.Info.Println("Done!")
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/abbit/m4k/internal/transform"
+)
+
+// encodingStats collects encodings of transformed pages, reported with progress
+type encodingStats struct {
+	mu         sync.Mutex
+	jpegPages  int
+	pngPages   int
+	minQuality int
+	maxQuality int
+	sumQuality int
+	size       int64
+	// size of source pages
+	sourceSize int64
+	// source pages taken from cache
+	cached int
+	// source pages failed to transform
+	failed int
+	// estimated memory of pages being transformed
+	memory int64
+}
+
+// add counts finished or failed page
+func (s *encodingStats) add(e transform.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.memory = e.MemoryBytes
+	if e.Kind == transform.EventError {
+		s.failed++
+		return
+	}
+	s.sourceSize += int64(e.BytesIn)
+	if e.Cached {
+		s.cached++
+	}
+	for _, result := range e.Results {
+		s.size += int64(result.Size)
+		if result.Quality == 0 {
+			s.pngPages++
+			continue
+		}
+		if s.jpegPages == 0 || result.Quality < s.minQuality {
+			s.minQuality = result.Quality
+		}
+		s.maxQuality = max(s.maxQuality, result.Quality)
+		s.sumQuality += result.Quality
+		s.jpegPages++
+	}
+}
+
+func (s *encodingStats) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	str := fmt.Sprintf("Encoded pages: %.1f MB", float64(s.size)/(1<<20))
+	if s.jpegPages > 0 {
+		str += fmt.Sprintf(", %d JPEG with quality %d-%d (average %d)",
+			s.jpegPages, s.minQuality, s.maxQuality, s.sumQuality/s.jpegPages)
+	}
+	if s.pngPages > 0 {
+		str += fmt.Sprintf(", %d PNG", s.pngPages)
+	}
+	if s.cached > 0 {
+		str += fmt.Sprintf(", %d source pages from cache", s.cached)
+	}
+	if s.failed > 0 {
+		str += fmt.Sprintf(", %d failed", s.failed)
+	}
+	return str
+}
+
+// progress describes sizes of pages transformed so far
+func (s *encodingStats) progress() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	str := fmt.Sprintf("%.1f MB -> %.1f MB", float64(s.sourceSize)/(1<<20), float64(s.size)/(1<<20))
+	if s.memory > 0 {
+		str += fmt.Sprintf(", %.0f MB in use", float64(s.memory)/(1<<20))
+	}
+	return fmt.Sprintf("Transforming pages (%s)...", str)
+}
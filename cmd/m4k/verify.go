@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+
+	"github.com/abbit/m4k/internal/comicbook"
+	"github.com/abbit/m4k/internal/log"
+	"github.com/abbit/m4k/internal/transform"
+	"github.com/abbit/m4k/internal/util"
+)
+
+type verifyFlags struct {
+	src string
+}
+
+func parseVerifyFlags(args []string) *verifyFlags {
+	flags := &verifyFlags{}
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.StringVar(&flags.src, "src", "", "Path to .cbz file or directory with .cbz files")
+	fs.Parse(args)
+
+	if flags.src == "" {
+		log.Error.Fatalf("-src option is required.\n")
+	}
+
+	return flags
+}
+
+// verifyCommand checks that cbz files can be read and all their pages decoded
+func verifyCommand(args []string) {
+	flags := parseVerifyFlags(args)
+
+	cbzFiles := []string{flags.src}
+	if info, err := os.Stat(flags.src); err != nil {
+		log.Error.Fatalf("%v\n", err)
+	} else if info.IsDir() {
+		cbzFiles, err = util.FilterDirFilePaths(flags.src, func(p string) bool { return filepath.Ext(p) == ".cbz" })
+		if err != nil {
+			log.Error.Fatalf("%v\n", err)
+		}
+	}
+
+	broken := 0
+	for _, path := range cbzFiles {
+		cb, err := comicbook.ReadComicBookWithOptions(path, &comicbook.ReadOptions{Tolerant: true})
+		if err != nil {
+			broken++
+			log.Info.Printf("%s: unreadable: %v\n", path, err)
+			continue
+		}
+
+		transform.VerifyComicBook(cb)
+		if cb.Repairs.HasIssues() {
+			broken++
+			log.Info.Printf("%s: %d problems\n%s\n", path, len(cb.Repairs.Issues()), cb.Repairs)
+		} else {
+			log.Info.Printf("%s: OK\n", path)
+		}
+	}
+
+	log.Info.Printf("Verified %d files, %d with problems\n", len(cbzFiles), broken)
+	if broken > 0 {
+		os.Exit(1)
+	}
+}
@@ -16,7 +16,7 @@ import (
 
 // TODO: wait for clients to close their connections when shutting down gracefully
 // TODO: measure performance with HTTP
-// TODO: handle multiple connections?
+// TODO: handle multiple connections concurrently?
 // TODO: resumable upload?
 
 type server struct {
@@ -43,30 +43,39 @@ func (srv *server) ListenAndServe() error {
 func (srv *server) Serve(l net.Listener) error {
 	defer l.Close()
 
-	connChan := make(chan net.Conn, 1)
+	// accept connections until exit signal,
+	// so that several files (e.g. volumes of one manga) can be sent one after another
+	connChan := make(chan net.Conn)
 	go func() {
-		conn, err := l.Accept()
-		if err != nil {
-			// TODO: dont print error if listener is closed when exiting
-			log.Printf("Error when accepting connection: %v\n", err)
-			return
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				// TODO: dont print error if listener is closed when exiting
+				log.Printf("Error when accepting connection: %v\n", err)
+				close(connChan)
+				return
+			}
+			conn.SetDeadline(time.Now().Add(2 * time.Hour))
+			connChan <- conn
 		}
-		conn.SetDeadline(time.Now().Add(2 * time.Hour))
-		connChan <- conn
 	}()
 
 	// TODO: handle signals outside of server
 	exitsig := make(chan os.Signal, 1)
 	signal.Notify(exitsig, syscall.SIGINT, syscall.SIGTERM)
 
-	select {
-	case conn := <-connChan:
-		srv.handleConnection(conn)
-	case <-exitsig:
-		log.Println("Received exit signal, exiting...")
+	for {
+		select {
+		case conn, ok := <-connChan:
+			if !ok {
+				return nil
+			}
+			srv.handleConnection(conn)
+		case <-exitsig:
+			log.Println("Received exit signal, exiting...")
+			return nil
+		}
 	}
-
-	return nil
 }
 
 func (srv *server) handleConnection(conn net.Conn) {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log/slog"
 	"net"
@@ -11,7 +12,12 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/abbit/m4k/internal/cache"
+	"github.com/abbit/m4k/internal/device"
+	"github.com/abbit/m4k/internal/mangal/client"
+	"github.com/abbit/m4k/internal/naming"
 	"github.com/abbit/m4k/internal/opds/server"
+	"github.com/abbit/m4k/internal/transform"
 )
 
 const port = "6333"
@@ -24,7 +30,41 @@ var providers = []string{
 	"mango-mangaplus",
 }
 
+type Flags struct {
+	nameTemplate    string
+	pageTemplate    string
+	chapterTemplate string
+	device          string
+	devices         string
+	pipeline        string
+	cacheDir        string
+	cacheMB         int
+	memoryMB        int
+	cover           bool
+	dividers        bool
+}
+
+func parseFlags() *Flags {
+	flags := &Flags{}
+	flag.StringVar(&flags.nameTemplate, "name-template", naming.DefaultBookName, "Template for names of served files")
+	flag.StringVar(&flags.pageTemplate, "page-template", naming.DefaultPagePath, "Template for page paths inside of served files")
+	flag.StringVar(&flags.chapterTemplate, "chapter-template", naming.DefaultChapterFile, "Template for names of downloaded chapter files")
+	flag.StringVar(&flags.device, "device", device.DefaultProfile, "Device profile used in download links")
+	flag.StringVar(&flags.devices, "devices", "", "Path to JSON file with user-defined device profiles (Default: m4k/devices.json in user config directory)")
+	flag.StringVar(&flags.pipeline, "pipeline", transform.DefaultPreset, "Comma-separated stages and presets pages are passed through")
+	flag.StringVar(&flags.cacheDir, "cache-dir", "", "Path to directory with cache of transformed pages (Default: m4k/pages in user cache directory)")
+	flag.IntVar(&flags.cacheMB, "cache-mb", cache.DefaultMaxBytes>>20, "Maximum size of cache of transformed pages in megabytes, 0 disables cache")
+	flag.IntVar(&flags.memoryMB, "memory-mb", transform.DefaultMemoryBytes>>20, "Maximum estimated memory of images being transformed at once in megabytes, 0 disables the limit")
+	flag.BoolVar(&flags.cover, "cover", false, "Add generated cover page with manga cover, title and chapters range")
+	flag.BoolVar(&flags.dividers, "dividers", false, "Add generated page before each chapter")
+	flag.Parse()
+
+	return flags
+}
+
 func main() {
+	flags := parseFlags()
+
 	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
 		Level: slog.LevelDebug,
 	})))
@@ -34,15 +74,83 @@ func main() {
 	ctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	if err := run(ctx); err != nil {
+	opts, err := serverOptions(flags)
+	if err != nil {
+		slog.Error("while parsing options", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	if err := run(ctx, opts); err != nil {
 		slog.Error("while running", slog.Any("error", err))
 	}
 }
 
-func run(ctx context.Context) error {
+func serverOptions(flags *Flags) (*server.Options, error) {
+	bookName, err := naming.Parse("name", flags.nameTemplate)
+	if err != nil {
+		return nil, err
+	}
+	pagePath, err := naming.Parse("page", flags.pageTemplate)
+	if err != nil {
+		return nil, err
+	}
+	chapterName, err := naming.Parse("chapter", flags.chapterTemplate)
+	if err != nil {
+		return nil, err
+	}
+	client.SetChapterNameTemplate(chapterName)
+
+	devices, err := device.LoadRegistry(flags.devices)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := devices.Get(flags.device)
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline, err := transform.ParsePipeline(flags.pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var pageCache *cache.Cache
+	if flags.cacheMB > 0 {
+		dir := flags.cacheDir
+		if dir == "" {
+			dir, err = cache.DefaultDir()
+			if err != nil {
+				return nil, err
+			}
+		}
+		pageCache, err = cache.Open(dir, int64(flags.cacheMB)<<20)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var scheduler *transform.Scheduler
+	if flags.memoryMB > 0 {
+		scheduler = transform.NewScheduler(int64(flags.memoryMB) << 20)
+	}
+
+	return &server.Options{
+		BookName:  bookName,
+		PagePath:  pagePath,
+		Devices:   devices,
+		Device:    profile.Name,
+		Pipeline:  pipeline,
+		Cache:     pageCache,
+		Scheduler: scheduler,
+		Cover:     flags.cover,
+		Dividers:  flags.dividers,
+	}, nil
+}
+
+func run(ctx context.Context, opts *server.Options) error {
 	server := &http.Server{
 		Addr:    net.JoinHostPort("", port),
-		Handler: server.New(ctx, providers),
+		Handler: server.New(ctx, providers, opts),
 	}
 
 	go func() {
@@ -7,6 +7,7 @@ require (
 	github.com/luevano/libmangal v0.20.1
 	github.com/luevano/mangoprovider v0.16.5
 	github.com/schollz/progressbar/v3 v3.13.1
+	golang.org/x/image v0.19.0
 	golang.org/x/sync v0.10.0
 )
 
@@ -55,7 +56,6 @@ require (
 	github.com/ysmood/gson v0.7.3 // indirect
 	github.com/ysmood/leakless v0.9.0 // indirect
 	golang.org/x/crypto v0.32.0 // indirect
-	golang.org/x/image v0.19.0 // indirect
 	golang.org/x/mod v0.20.0 // indirect
 	golang.org/x/net v0.33.0 // indirect
 	golang.org/x/sys v0.29.0 // indirect
@@ -0,0 +1,237 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxBytes is default size cap of cache
+	DefaultMaxBytes = 1 << 30
+	// length of keys returned by Key
+	keyLength = 2 * sha256.Size
+	// temporary files older than this are left by interrupted writes,
+	// younger ones may be written by another process sharing the cache
+	tmpGracePeriod = time.Hour
+)
+
+// Cache is a content-addressed store on disk, limited in size.
+// Least recently used entries are evicted when size cap is exceeded.
+// Access times are kept in file modification times, so order survives restarts.
+type Cache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	entries map[string]*list.Element
+	// least recently used entries at front
+	lru *list.List
+}
+
+type entry struct {
+	name string
+	size int64
+	// last access known to this process
+	accessed time.Time
+}
+
+// Open opens cache in dir, creating it if needed
+func Open(dir string, maxBytes int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("while creating cache dir: %w", err)
+	}
+
+	c := &Cache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+
+	var files []entry
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if !strings.HasPrefix(d.Name(), ".") && len(d.Name()) != keyLength {
+			return nil
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// renamed or removed by another process
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			if time.Since(info.ModTime()) > tmpGracePeriod {
+				// temporary file left by interrupted write
+				os.Remove(path)
+			}
+			return nil
+		}
+		files = append(files, entry{d.Name(), info.Size(), info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("while reading cache dir: %w", err)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].accessed.Before(files[j].accessed) })
+	for i := range files {
+		c.entries[files[i].name] = c.lru.PushBack(&files[i])
+		c.size += files[i].size
+	}
+
+	c.evict()
+
+	return c, nil
+}
+
+// DefaultDir returns cache dir in user cache directory
+func DefaultDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "m4k", "pages"), nil
+}
+
+// Key returns cache key for parts, e.g. content hash and options
+func Key(parts ...[]byte) string {
+	h := sha256.New()
+	for _, part := range parts {
+		// length prefix keeps parts from running into each other
+		fmt.Fprintf(h, "%d:", len(part))
+		h.Write(part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *Cache) path(name string) string {
+	return filepath.Join(c.dir, name[:2], name)
+}
+
+// Get returns data stored by key
+func (c *Cache) Get(key string) ([]byte, bool) {
+	data, err := os.ReadFile(c.path(key))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if err != nil {
+		if ok {
+			// removed by someone else
+			c.remove(elem)
+		}
+		return nil, false
+	}
+	now := time.Now()
+	if ok {
+		e := elem.Value.(*entry)
+		// may be replaced by another process sharing the cache
+		c.size += int64(len(data)) - e.size
+		e.size, e.accessed = int64(len(data)), now
+		c.lru.MoveToBack(elem)
+	} else {
+		// added by another process sharing the cache
+		c.entries[key] = c.lru.PushBack(&entry{key, int64(len(data)), now})
+		c.size += int64(len(data))
+	}
+
+	os.Chtimes(c.path(key), now, now)
+	return data, true
+}
+
+// Put stores data by key, evicting least recently used entries if cache is full
+func (c *Cache) Put(key string, data []byte) error {
+	if int64(len(data)) > c.maxBytes {
+		return nil
+	}
+
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fmt.Errorf("while creating cache dir: %w", err)
+	}
+	// write to temporary file first, so readers never see partial data
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("while writing cache entry: %w", err)
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("while writing cache entry: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	// new entry is the most recently used, so it's added after making room for it
+	c.size += int64(len(data))
+	c.evict()
+	c.entries[key] = c.lru.PushBack(&entry{key, int64(len(data)), time.Now()})
+
+	return nil
+}
+
+// Size returns total size of cached entries
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+func (c *Cache) remove(elem *list.Element) {
+	e := c.lru.Remove(elem).(*entry)
+	delete(c.entries, e.name)
+	c.size -= e.size
+}
+
+// evict removes least recently used entries until cache fits its size cap.
+// Entries are checked on disk first, as other processes sharing the cache
+// may have used, replaced or removed them.
+func (c *Cache) evict() {
+	for c.size > c.maxBytes && c.lru.Len() > 0 {
+		elem := c.lru.Front()
+		e := elem.Value.(*entry)
+		info, err := os.Stat(c.path(e.name))
+		if err != nil {
+			// removed by someone else
+			c.remove(elem)
+			continue
+		}
+		c.size += info.Size() - e.size
+		e.size = info.Size()
+		if info.ModTime().After(e.accessed) {
+			// used by another process since
+			e.accessed = info.ModTime()
+			c.lru.MoveToBack(elem)
+			continue
+		}
+		c.remove(elem)
+		os.Remove(c.path(e.name))
+	}
+}
@@ -0,0 +1,208 @@
+package cache
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// data returns n bytes of b
+func data(b byte, n int) []byte {
+	return bytes.Repeat([]byte{b}, n)
+}
+
+// exists reports if entry is stored on disk
+func exists(c *Cache, key string) bool {
+	_, err := os.Stat(c.path(key))
+	return err == nil
+}
+
+func TestKey(t *testing.T) {
+	key := Key([]byte("page"), []byte("options"))
+	if len(key) != keyLength {
+		t.Errorf("key has length %d, want %d", len(key), keyLength)
+	}
+	if key != Key([]byte("page"), []byte("options")) {
+		t.Error("key isn't deterministic")
+	}
+	if Key([]byte("ab"), []byte("c")) == Key([]byte("a"), []byte("bc")) {
+		t.Error("parts run into each other")
+	}
+}
+
+func TestCacheGetPut(t *testing.T) {
+	c, err := Open(t.TempDir(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := Key([]byte("a"))
+	if _, ok := c.Get(key); ok {
+		t.Error("missing entry is found")
+	}
+	if err := c.Put(key, data('a', 10)); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := c.Get(key); !ok || !bytes.Equal(got, data('a', 10)) {
+		t.Errorf("Get = %q, %v", got, ok)
+	}
+	// replaced entry is counted once
+	if err := c.Put(key, data('b', 20)); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := c.Get(key); !ok || !bytes.Equal(got, data('b', 20)) {
+		t.Errorf("Get = %q, %v", got, ok)
+	}
+	if c.Size() != 20 {
+		t.Errorf("size is %d, want 20", c.Size())
+	}
+
+	// entries larger than cache aren't stored
+	large := Key([]byte("large"))
+	if err := c.Put(large, data('l', 101)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get(large); ok {
+		t.Error("entry larger than cache is stored")
+	}
+
+	// removed by someone else
+	os.Remove(c.path(key))
+	if _, ok := c.Get(key); ok {
+		t.Error("removed entry is found")
+	}
+	if c.Size() != 0 {
+		t.Errorf("size is %d after entry is removed, want 0", c.Size())
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c, err := Open(t.TempDir(), 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b, d, e := Key([]byte("a")), Key([]byte("b")), Key([]byte("d")), Key([]byte("e"))
+	for _, key := range []string{a, b, d} {
+		if err := c.Put(key, data('x', 10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := c.Get(a); !ok {
+		t.Fatal("entry isn't found")
+	}
+	if err := c.Put(e, data('x', 10)); err != nil {
+		t.Fatal(err)
+	}
+
+	if exists(c, b) {
+		t.Error("least recently used entry isn't evicted")
+	}
+	for _, key := range []string{a, d, e} {
+		if !exists(c, key) {
+			t.Errorf("entry %s is evicted", key[:8])
+		}
+	}
+	if c.Size() != 30 {
+		t.Errorf("size is %d, want 30", c.Size())
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	c, err := Open(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b, d := Key([]byte("a")), Key([]byte("b")), Key([]byte("d"))
+	for _, key := range []string{a, b, d} {
+		if err := c.Put(key, data('x', 10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// access times survive restarts, b is the least recently used
+	now := time.Now()
+	for i, key := range []string{b, a, d} {
+		accessed := now.Add(time.Duration(i-3) * time.Minute)
+		if err := os.Chtimes(c.path(key), accessed, accessed); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// temporary files of interrupted writes are removed, but not of writes in progress
+	stale := filepath.Join(dir, a[:2], ".tmp-stale")
+	fresh := filepath.Join(dir, a[:2], ".tmp-fresh")
+	for _, path := range []string{stale, fresh} {
+		if err := os.WriteFile(path, data('t', 5), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := now.Add(-2 * tmpGracePeriod)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = Open(dir, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists(c, b) || !exists(c, a) || !exists(c, d) {
+		t.Errorf("entries a, b, d exist: %v, %v, %v, want only b evicted", exists(c, a), exists(c, b), exists(c, d))
+	}
+	if c.Size() != 20 {
+		t.Errorf("size is %d, want 20", c.Size())
+	}
+	if _, err := os.Stat(stale); err == nil {
+		t.Error("stale temporary file isn't removed")
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Errorf("temporary file of write in progress is removed: %v", err)
+	}
+}
+
+func TestCacheShared(t *testing.T) {
+	dir := t.TempDir()
+	first, err := Open(dir, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b, d, e := Key([]byte("a")), Key([]byte("b")), Key([]byte("d")), Key([]byte("e"))
+	for _, key := range []string{d, a, b} {
+		if err := first.Put(key, data('x', 10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// another process replaces d with larger entry and uses a
+	second, err := Open(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Put(d, data('y', 15)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := second.Get(a); !ok {
+		t.Fatal("shared entry isn't found")
+	}
+	// file times may be coarser than clock, so they're set explicitly
+	used := time.Now()
+	for _, key := range []string{d, a} {
+		if err := os.Chtimes(second.path(key), used, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// d and a are moved back, b and then d with its size on disk are evicted
+	if err := first.Put(e, data('x', 8)); err != nil {
+		t.Fatal(err)
+	}
+	if exists(first, b) || exists(first, d) {
+		t.Error("least recently used entries aren't evicted")
+	}
+	if !exists(first, a) || !exists(first, e) {
+		t.Error("recently used entries are evicted")
+	}
+	if first.Size() != 18 {
+		t.Errorf("size is %d, want 18", first.Size())
+	}
+}
@@ -0,0 +1,131 @@
+package comicbook
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+)
+
+var (
+	localFileHeaderSignature = []byte("PK\x03\x04")
+	// signatures which can follow file data
+	nextRecordSignatures = [][]byte{
+		localFileHeaderSignature,
+		[]byte("PK\x01\x02"), // central directory file header
+		[]byte("PK\x07\x08"), // data descriptor
+	}
+)
+
+const (
+	localFileHeaderLen = 30
+	flagDataDescriptor = 0x8
+	unknownSize        = 0xffffffff
+)
+
+// archiveEntry is a file in archive, either from zip central directory
+// or recovered from local file headers of a broken archive
+type archiveEntry struct {
+	name string
+	open func() (io.ReadCloser, error)
+}
+
+func zipEntries(r *zip.Reader) []archiveEntry {
+	entries := make([]archiveEntry, 0, len(r.File))
+	for _, f := range r.File {
+		entries = append(entries, archiveEntry{name: f.Name, open: f.Open})
+	}
+	return entries
+}
+
+// readAll reads entry data, returning data read so far together with error
+func (e archiveEntry) readAll() ([]byte, error) {
+	file, err := e.open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(file)
+	return buf.Bytes(), err
+}
+
+// scanZipEntries recovers entries of zip archive with broken or missing central directory
+// by scanning for local file headers
+func scanZipEntries(data []byte) []archiveEntry {
+	var entries []archiveEntry
+	offset := 0
+	for {
+		i := bytes.Index(data[offset:], localFileHeaderSignature)
+		if i < 0 {
+			return entries
+		}
+		offset += i
+
+		entry, next, ok := parseLocalFileHeader(data, offset)
+		if ok {
+			entries = append(entries, entry)
+			offset = next
+		} else {
+			offset += len(localFileHeaderSignature)
+		}
+	}
+}
+
+// parseLocalFileHeader parses local file header at offset,
+// returns entry and offset where search for the next header should continue
+func parseLocalFileHeader(data []byte, offset int) (archiveEntry, int, bool) {
+	if len(data)-offset < localFileHeaderLen {
+		return archiveEntry{}, 0, false
+	}
+	header := data[offset : offset+localFileHeaderLen]
+	flags := binary.LittleEndian.Uint16(header[6:])
+	method := binary.LittleEndian.Uint16(header[8:])
+	compressedSize := binary.LittleEndian.Uint32(header[18:])
+	nameLen := int(binary.LittleEndian.Uint16(header[26:]))
+	extraLen := int(binary.LittleEndian.Uint16(header[28:]))
+
+	start := offset + localFileHeaderLen + nameLen + extraLen
+	if start > len(data) || (method != zip.Store && method != zip.Deflate) {
+		return archiveEntry{}, 0, false
+	}
+	name := string(data[offset+localFileHeaderLen : offset+localFileHeaderLen+nameLen])
+
+	end := len(data)
+	sizeKnown := flags&flagDataDescriptor == 0 && compressedSize != unknownSize
+	if sizeKnown {
+		end = min(end, start+int(compressedSize))
+	} else if method == zip.Store {
+		// stored data without size ends where the next record starts
+		end = start + nextRecordIndex(data[start:])
+	}
+
+	fileData := data[start:end]
+	entry := archiveEntry{
+		name: name,
+		open: func() (io.ReadCloser, error) {
+			if method == zip.Deflate {
+				return flate.NewReader(bytes.NewReader(fileData)), nil
+			}
+			return io.NopCloser(bytes.NewReader(fileData)), nil
+		},
+	}
+
+	if !sizeKnown {
+		// deflate stream size is unknown, continue right after the header
+		end = start
+	}
+	return entry, end, true
+}
+
+func nextRecordIndex(data []byte) int {
+	next := len(data)
+	for _, signature := range nextRecordSignatures {
+		if i := bytes.Index(data, signature); i >= 0 {
+			next = min(next, i)
+		}
+	}
+	return next
+}
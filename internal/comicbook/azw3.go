@@ -0,0 +1,540 @@
+package comicbook
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+	"time"
+)
+
+// AZW3 is written as KF8 book inside of PalmDB container, which stock Kindle
+// reader shows as fixed-layout comic with panel view. Every page is an XHTML file
+// showing one image. KF8 stores such file as a skeleton followed by a fragment
+// to be inserted into it, both are located through SKEL and FRAG indexes.
+// Table of contents is kept in NCX index.
+// https://wiki.mobileread.com/wiki/KF8
+
+const (
+	kf8HeaderLength  = 264
+	kf8Record0Header = 16 + kf8HeaderLength
+	kf8FileVersion   = 8
+	kf8ExthFlag      = 0x50
+	// text records end with size of multibyte character overlap
+	kf8ExtraDataFlags = 1
+	// index entries of one index record are addressed with 16 bit offsets
+	indxMaxRecordSize = 0x10000
+	indxHeaderLength  = 192
+	// base32 digits of KF8 resource and element ids
+	kf8Base32Digits = "0123456789ABCDEFGHIJKLMNOPQRSTUV"
+)
+
+// indxTag is a tag of index entries, described in TAGX section of index header
+type indxTag struct {
+	number         byte
+	valuesPerEntry byte
+	mask           byte
+	endFlag        byte
+}
+
+// indxEndTag ends control byte of index entries
+var indxEndTag = indxTag{endFlag: 1}
+
+var (
+	kf8SkelTags = []indxTag{
+		{number: 1, valuesPerEntry: 1, mask: 3},  // fragment count
+		{number: 6, valuesPerEntry: 2, mask: 12}, // skeleton position and length
+		indxEndTag,
+	}
+	kf8FragTags = []indxTag{
+		{number: 2, valuesPerEntry: 1, mask: 1}, // CNCX offset of insertion point selector
+		{number: 3, valuesPerEntry: 1, mask: 2}, // file number
+		{number: 4, valuesPerEntry: 1, mask: 4}, // sequence number
+		{number: 6, valuesPerEntry: 2, mask: 8}, // fragment position in file and length
+		indxEndTag,
+	}
+	kf8NCXTags = []indxTag{
+		{number: 1, valuesPerEntry: 1, mask: 1},   // text position
+		{number: 2, valuesPerEntry: 1, mask: 2},   // text length
+		{number: 3, valuesPerEntry: 1, mask: 4},   // CNCX offset of label
+		{number: 4, valuesPerEntry: 1, mask: 8},   // depth
+		{number: 6, valuesPerEntry: 2, mask: 128}, // fragment number and position in fragment
+		indxEndTag,
+	}
+)
+
+// indxEntry is an index entry with values of tags by tag number
+type indxEntry struct {
+	label  string
+	values map[byte][]uint32
+}
+
+// kf8Page is an XHTML file of KF8 text
+type kf8Page struct {
+	skeleton []byte
+	// position of fragment in skeleton
+	insert   int
+	fragment []byte
+	// element to insert fragment into
+	parentID string
+}
+
+// writeAZW3 writes fixed-layout KF8 book with one image per page
+func (cb *ComicBook) writeAZW3(wr io.Writer) error {
+	if len(cb.Pages) == 0 {
+		return fmt.Errorf("book has no pages")
+	}
+
+	var images [][]byte
+	var sizes []image.Point
+	var formats []string
+	coverIndex := 0
+	for i, page := range cb.Pages {
+		if _, format, err := image.DecodeConfig(bytes.NewReader(page.Data)); err != nil {
+			return fmt.Errorf("page %s: reading image: %w", page.Filepath(), err)
+		} else if format != "jpeg" && format != "png" && format != "gif" {
+			return fmt.Errorf("page %s: unsupported image format %s", page.Filepath(), format)
+		}
+		if page.Kind == PageKindCover && coverIndex == 0 {
+			coverIndex = i
+		}
+		data, err := mobiImage(page.Data)
+		if err != nil {
+			return fmt.Errorf("page %s: fitting image into record: %w", page.Filepath(), err)
+		}
+		// image may be re-encoded and downscaled to fit into record
+		config, format, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("page %s: reading image: %w", page.Filepath(), err)
+		}
+		images = append(images, data)
+		sizes = append(sizes, image.Pt(config.Width, config.Height))
+		formats = append(formats, format)
+	}
+
+	thumbnail, err := mobiThumbnail(cb.Pages[coverIndex].Data)
+	if err != nil {
+		return fmt.Errorf("creating cover thumbnail: %w", err)
+	}
+	thumbIndex := len(images)
+	images = append(images, thumbnail)
+
+	viewport := cb.Viewport
+	if viewport.X <= 0 || viewport.Y <= 0 {
+		viewport = sizes[0]
+	}
+
+	// text is ascii only, so it can be split into records at any byte
+	var text bytes.Buffer
+	var skeletons, fragments []indxEntry
+	var selectors []string
+	fragmentStarts := make([]int, 0, len(cb.Pages))
+	for i := range cb.Pages {
+		page := kf8PageXHTML(cb.Name, i, viewport, sizes[i], formats[i])
+
+		start := text.Len()
+		text.Write(page.skeleton)
+		text.Write(page.fragment)
+		insert := start + page.insert
+		fragmentStarts = append(fragmentStarts, insert)
+
+		skeletons = append(skeletons, indxEntry{
+			label: fmt.Sprintf("SKEL%010d", i),
+			values: map[byte][]uint32{
+				// values are repeated, like kindlegen does
+				1: {1, 1},
+				6: {uint32(start), uint32(len(page.skeleton)), uint32(start), uint32(len(page.skeleton))},
+			},
+		})
+		selectors = append(selectors, fmt.Sprintf("P-//*[@aid='%s']", page.parentID))
+		fragments = append(fragments, indxEntry{
+			label: fmt.Sprintf("%010d", insert),
+			values: map[byte][]uint32{
+				3: {uint32(i)},
+				4: {uint32(i)},
+				6: {0, uint32(len(page.fragment))},
+			},
+		})
+	}
+	fragCNCX, selectorOffsets := kf8CNCX(selectors)
+	for i := range fragments {
+		fragments[i].values[2] = []uint32{selectorOffsets[i]}
+	}
+
+	toc := cb.TableOfContents()
+	// table of contents must not be empty
+	if len(toc) == 0 {
+		toc = append(toc, TOCEntry{Title: cb.Name})
+	}
+	labels := make([]string, 0, len(toc))
+	for _, entry := range toc {
+		labels = append(labels, entry.Title)
+	}
+	ncxCNCX, labelOffsets := kf8CNCX(labels)
+	var ncx []indxEntry
+	for i, entry := range toc {
+		start := fragmentStarts[entry.Page]
+		end := text.Len()
+		if i+1 < len(toc) {
+			end = fragmentStarts[toc[i+1].Page]
+		}
+		ncx = append(ncx, indxEntry{
+			label: fmt.Sprintf("%02x", i),
+			values: map[byte][]uint32{
+				1: {uint32(start)},
+				2: {uint32(end - start)},
+				3: {labelOffsets[i]},
+				4: {0},
+				6: {uint32(entry.Page), 0},
+			},
+		})
+	}
+
+	var records [][]byte
+	recordsSize := 0
+	for data := text.Bytes(); len(data) > 0; {
+		n := min(len(data), mobiTextRecordSize)
+		// ascii text has no multibyte characters overlapping next record
+		record := append(data[:n:n], 0)
+		records = append(records, record)
+		recordsSize += len(record)
+		data = data[n:]
+	}
+	textRecords := len(records)
+	// records following text start at 4 byte boundary
+	if padding := (4 - recordsSize%4) % 4; padding > 0 {
+		records = append(records, make([]byte, padding))
+	}
+	// record 0 is prepended later
+	firstNonText := 1 + len(records)
+
+	fragIndex := 1 + len(records)
+	frag, err := indxRecords(kf8FragTags, fragments, fragCNCX)
+	if err != nil {
+		return fmt.Errorf("writing fragment index: %w", err)
+	}
+	records = append(records, frag...)
+
+	skelIndex := 1 + len(records)
+	skel, err := indxRecords(kf8SkelTags, skeletons, nil)
+	if err != nil {
+		return fmt.Errorf("writing skeleton index: %w", err)
+	}
+	records = append(records, skel...)
+
+	ncxIndex := 1 + len(records)
+	ncxRecords, err := indxRecords(kf8NCXTags, ncx, ncxCNCX)
+	if err != nil {
+		return fmt.Errorf("writing table of contents: %w", err)
+	}
+	records = append(records, ncxRecords...)
+
+	firstResource := 1 + len(records)
+	records = append(records, images...)
+
+	fdstIndex := 1 + len(records)
+	records = append(records, kf8FDST(text.Len()))
+	flisIndex := 1 + len(records)
+	fcisIndex := flisIndex + 1
+	records = append(records, mobiFLIS, mobiFCIS(text.Len()), mobiEOF)
+
+	uniqueID := mobiUniqueID(cb.Fields().Series, cb.Name)
+	exth := cb.mobiEXTH(uniqueID, coverIndex, thumbIndex)
+	exth = append(exth, cb.kf8EXTH(viewport, coverIndex, len(images))...)
+	record0 := kf8Record0(kf8Record0Params{
+		name:          cb.Name,
+		textLength:    text.Len(),
+		textRecords:   textRecords,
+		firstNonText:  firstNonText,
+		firstResource: firstResource,
+		fdst:          fdstIndex,
+		flis:          flisIndex,
+		fcis:          fcisIndex,
+		frag:          fragIndex,
+		skel:          skelIndex,
+		ncx:           ncxIndex,
+		uniqueID:      uniqueID,
+		locale:        mobiLocales[strings.ToLower(cb.Metadata.LanguageISO)],
+		exth:          exth,
+	})
+	records = append([][]byte{record0}, records...)
+
+	modified := cb.ModTime
+	if modified.IsZero() {
+		modified = time.Now()
+	}
+
+	return writePalmDB(wr, mobiPalmName(cb.Name), modified, records)
+}
+
+// kf8EXTH returns EXTH records of fixed-layout comic
+func (cb *ComicBook) kf8EXTH(viewport image.Point, coverIndex, resources int) []exthRecord {
+	writingMode, pageProgression := "horizontal-lr", "ltr"
+	if cb.Metadata.RightToLeft {
+		writingMode, pageProgression = "horizontal-rl", "rtl"
+	}
+	return []exthRecord{
+		exthString(exthFixedLayout, "true"),
+		// comics get panel view
+		exthString(exthBookType, "comic"),
+		exthString(exthOriginalResolution, fmt.Sprintf("%dx%d", viewport.X, viewport.Y)),
+		exthString(exthZeroGutter, "true"),
+		exthString(exthZeroMargin, "true"),
+		exthString(exthRegionMagnification, "true"),
+		exthUint32(exthResourceCount, uint32(resources)),
+		exthString(exthCoverURI, "kindle:embed:"+kf8Base32(coverIndex+1, 4)),
+		exthString(exthWritingMode, writingMode),
+		exthString(exthPageProgression, pageProgression),
+	}
+}
+
+// kf8PageXHTML returns XHTML file showing image of page scaled to fit into viewport
+func kf8PageXHTML(title string, index int, viewport, size image.Point, format string) kf8Page {
+	width, height := viewport.X, viewport.X*size.Y/size.X
+	if height > viewport.Y {
+		width, height = viewport.Y*size.X/size.Y, viewport.Y
+	}
+	bodyID := kf8Base32(2*index, 1)
+
+	head := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><title>%s</title>`+
+		`<meta name="viewport" content="width=%d, height=%d"/>`+
+		`<style type="text/css">body {margin: 0; padding: 0;} div {position: absolute; margin: 0; padding: 0;} img {display: block;}</style>`+
+		`</head><body aid="%s">`,
+		mobiText(title), viewport.X, viewport.Y, bodyID)
+	fragment := fmt.Sprintf(`<div aid="%s" style="left: %dpx; top: %dpx;"><img src="kindle:embed:%s?mime=image/%s" width="%d" height="%d" alt=""/></div>`,
+		kf8Base32(2*index+1, 1), (viewport.X-width)/2, (viewport.Y-height)/2, kf8Base32(index+1, 4), format, width, height)
+
+	return kf8Page{
+		skeleton: []byte(head + `</body></html>`),
+		insert:   len(head),
+		fragment: []byte(fragment),
+		parentID: bodyID,
+	}
+}
+
+// kf8Base32 formats v with KF8 base32 digits, padded with zeros to width
+func kf8Base32(v, width int) string {
+	var digits []byte
+	for ; v > 0; v /= 32 {
+		digits = append([]byte{kf8Base32Digits[v%32]}, digits...)
+	}
+	if pad := width - len(digits); pad > 0 {
+		digits = append(bytes.Repeat([]byte{'0'}, pad), digits...)
+	}
+	return string(digits)
+}
+
+// kf8FDST returns FDST record, which has the whole text as a single flow
+func kf8FDST(textLength int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("FDST")
+	binary.Write(&buf, binary.BigEndian, []uint32{12, 1, 0, uint32(textLength)})
+	return buf.Bytes()
+}
+
+type kf8Record0Params struct {
+	name          string
+	textLength    int
+	textRecords   int
+	firstNonText  int
+	firstResource int
+	fdst          int
+	flis, fcis    int
+	frag, skel    int
+	ncx           int
+	uniqueID      uint32
+	locale        uint32
+	exth          []exthRecord
+}
+
+// kf8Record0 builds PalmDOC header, KF8 MOBI header, EXTH header and full name
+func kf8Record0(p kf8Record0Params) []byte {
+	exth := mobiEXTHHeader(p.exth)
+	fullNameOffset := kf8Record0Header + len(exth)
+
+	u32 := func(values ...uint32) []uint32 { return values }
+	var buf bytes.Buffer
+	be := func(v any) { binary.Write(&buf, binary.BigEndian, v) }
+
+	// PalmDOC header
+	be(uint16(mobiCompressionNone))
+	be(uint16(0))
+	be(uint32(p.textLength))
+	be(uint16(p.textRecords))
+	be(uint16(mobiTextRecordSize))
+	be(uint32(0)) // no encryption
+
+	// MOBI header
+	buf.WriteString("MOBI")
+	be(u32(kf8HeaderLength, mobiTypeBook, mobiEncodingUTF8, p.uniqueID, kf8FileVersion))
+	// orthographic, inflection, names, keys and extra indexes
+	for i := 0; i < 10; i++ {
+		be(uint32(mobiNoIndex))
+	}
+	be(u32(uint32(p.firstNonText), uint32(fullNameOffset), uint32(len(p.name))))
+	be(u32(p.locale, 0, 0, kf8FileVersion))
+	be(uint32(p.firstResource))
+	be(u32(0, 0, 0, 0)) // no huffman compression
+	be(uint32(kf8ExthFlag))
+	buf.Write(make([]byte, 32))
+	be(uint32(mobiNoIndex))
+	be(u32(mobiNoIndex, 0, 0, 0)) // no DRM
+	buf.Write(make([]byte, 8))
+	be(u32(uint32(p.fdst), 1))
+	be(u32(uint32(p.fcis), 1, uint32(p.flis), 1))
+	buf.Write(make([]byte, 8))
+	be(u32(mobiNoIndex, 0, mobiNoIndex, mobiNoIndex))
+	be(uint32(kf8ExtraDataFlags))
+	be(u32(uint32(p.ncx), uint32(p.frag), uint32(p.skel)))
+	be(u32(mobiNoIndex, mobiNoIndex)) // no DATP and guide indexes
+	be(u32(mobiNoIndex, 0, mobiNoIndex, 0))
+
+	buf.Write(exth)
+	buf.Write(mobiFullName(p.name))
+
+	return buf.Bytes()
+}
+
+// kf8CNCX returns CNCX records with strings and offsets of strings in them
+func kf8CNCX(values []string) ([][]byte, []uint32) {
+	// kindlegen leaves some space in records
+	const recordLimit = indxMaxRecordSize - 1024
+
+	var records [][]byte
+	var buf []byte
+	offsets := make([]uint32, 0, len(values))
+	for _, s := range values {
+		data := append(encodeIndxInt(nil, uint32(len(s))), s...)
+		if len(buf)+len(data) > recordLimit {
+			records = append(records, alignBlock(buf))
+			buf = nil
+		}
+		offsets = append(offsets, uint32(len(records)*indxMaxRecordSize+len(buf)))
+		buf = append(buf, data...)
+	}
+	if len(buf) > 0 {
+		records = append(records, alignBlock(buf))
+	}
+	return records, offsets
+}
+
+// indxRecords returns index header record, single index record with entries and CNCX records
+func indxRecords(tags []indxTag, entries []indxEntry, cncx [][]byte) ([][]byte, error) {
+	controlBytes := 0
+	for _, tag := range tags {
+		controlBytes += int(tag.endFlag)
+	}
+
+	var data []byte
+	var idxt []byte
+	for _, entry := range entries {
+		offset := indxHeaderLength + len(data)
+		if offset >= indxMaxRecordSize {
+			return nil, fmt.Errorf("too many index entries")
+		}
+		idxt = binary.BigEndian.AppendUint16(idxt, uint16(offset))
+
+		data = append(data, byte(len(entry.label)))
+		data = append(data, entry.label...)
+		control := byte(0)
+		for _, tag := range tags {
+			if tag.endFlag == 1 {
+				data = append(data, control)
+				control = 0
+				continue
+			}
+			count := byte(len(entry.values[tag.number]) / int(tag.valuesPerEntry))
+			control |= tag.mask & (count << indxMaskShift(tag.mask))
+		}
+		for _, tag := range tags {
+			for _, v := range entry.values[tag.number] {
+				data = encodeIndxInt(data, v)
+			}
+		}
+	}
+	data = alignBlock(data)
+	idxt = alignBlock(append([]byte("IDXT"), idxt...))
+	if indxHeaderLength+len(data)+len(idxt) > indxMaxRecordSize {
+		return nil, fmt.Errorf("too many index entries")
+	}
+
+	u32 := func(values ...uint32) []uint32 { return values }
+	var record bytes.Buffer
+	be := func(v any) { binary.Write(&record, binary.BigEndian, v) }
+	record.WriteString("INDX")
+	be(u32(indxHeaderLength, 0, 1, 0))
+	be(u32(uint32(indxHeaderLength+len(data)), uint32(len(entries))))
+	be(u32(mobiNoIndex, mobiNoIndex))
+	record.Write(make([]byte, 156))
+	record.Write(data)
+	record.Write(idxt)
+
+	var tagx bytes.Buffer
+	tagx.WriteString("TAGX")
+	binary.Write(&tagx, binary.BigEndian, u32(uint32(12+4*len(tags)), uint32(controlBytes)))
+	for _, tag := range tags {
+		tagx.Write([]byte{tag.number, tag.valuesPerEntry, tag.mask, tag.endFlag})
+	}
+	tagxBlock := alignBlock(tagx.Bytes())
+
+	// label and entry count of the only index record
+	var last []byte
+	if len(entries) > 0 {
+		label := entries[len(entries)-1].label
+		last = append([]byte{byte(len(label))}, label...)
+	}
+	last = alignBlock(binary.BigEndian.AppendUint16(last, uint16(len(entries))))
+
+	var header bytes.Buffer
+	be = func(v any) { binary.Write(&header, binary.BigEndian, v) }
+	header.WriteString("INDX")
+	be(u32(indxHeaderLength, 0, 0))
+	be(uint32(2)) // index type
+	be(uint32(indxHeaderLength + len(tagxBlock) + len(last)))
+	be(u32(1, mobiEncodingUTF8, mobiNoIndex, uint32(len(entries))))
+	be(u32(0, 0, 0)) // no ORDT and LIGT
+	be(uint32(len(cncx)))
+	header.Write(make([]byte, 124))
+	be(u32(indxHeaderLength, 0, 0))
+	header.Write(tagxBlock)
+	header.Write(last)
+	header.WriteString("IDXT")
+	be(uint16(indxHeaderLength + len(tagxBlock)))
+
+	records := [][]byte{alignBlock(header.Bytes()), record.Bytes()}
+	return append(records, cncx...), nil
+}
+
+// indxMaskShift returns position of the lowest bit of mask
+func indxMaskShift(mask byte) byte {
+	shift := byte(0)
+	for mask&1 == 0 {
+		mask >>= 1
+		shift++
+	}
+	return shift
+}
+
+// encodeIndxInt appends v as variable width integer: 7 bits per byte,
+// most significant first, the last byte has its high bit set
+func encodeIndxInt(buf []byte, v uint32) []byte {
+	var digits []byte
+	for {
+		digits = append([]byte{byte(v & 0x7f)}, digits...)
+		v >>= 7
+		if v == 0 {
+			break
+		}
+	}
+	digits[len(digits)-1] |= 0x80
+	return append(buf, digits...)
+}
+
+// alignBlock pads data with zeros to 4 bytes
+func alignBlock(data []byte) []byte {
+	return append(data, make([]byte, (4-len(data)%4)%4)...)
+}
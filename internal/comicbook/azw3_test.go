@@ -0,0 +1,264 @@
+package comicbook
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+var (
+	kf8EmbedRe = regexp.MustCompile(`src="kindle:embed:([0-9A-V]{4})\?mime=image/jpeg"`)
+	kf8AidRe   = regexp.MustCompile(`aid="([0-9A-V]+)"`)
+)
+
+// decodeIndxInt returns variable width integer at the start of data and its length
+func decodeIndxInt(t *testing.T, data []byte) (uint32, int) {
+	t.Helper()
+	v := uint32(0)
+	for i, b := range data {
+		v = v<<7 | uint32(b&0x7f)
+		if b&0x80 != 0 {
+			return v, i + 1
+		}
+	}
+	t.Fatal("unterminated integer")
+	return 0, 0
+}
+
+// kf8Index is an index of KF8 book read back from its records
+type kf8Index struct {
+	labels []string
+	values []map[byte][]uint32
+	cncx   [][]byte
+}
+
+// readINDX reads index with header record at given number
+func readINDX(t *testing.T, records [][]byte, number int) kf8Index {
+	t.Helper()
+	header := records[number]
+	if string(header[:4]) != "INDX" {
+		t.Fatalf("record %d is not an index header", number)
+	}
+	indexRecords := int(binary.BigEndian.Uint32(header[24:]))
+	cncxRecords := int(binary.BigEndian.Uint32(header[52:]))
+
+	tagx := header[binary.BigEndian.Uint32(header[180:]):]
+	if string(tagx[:4]) != "TAGX" {
+		t.Fatal("TAGX section not found")
+	}
+	tagxLength := int(binary.BigEndian.Uint32(tagx[4:]))
+	var tags []indxTag
+	for i := 12; i < tagxLength; i += 4 {
+		tags = append(tags, indxTag{tagx[i], tagx[i+1], tagx[i+2], tagx[i+3]})
+	}
+
+	var index kf8Index
+	for _, record := range records[number+1 : number+1+indexRecords] {
+		idxt := int(binary.BigEndian.Uint32(record[20:]))
+		count := int(binary.BigEndian.Uint32(record[24:]))
+		if string(record[idxt:idxt+4]) != "IDXT" {
+			t.Fatal("IDXT section not found")
+		}
+		for i := 0; i < count; i++ {
+			entry := record[binary.BigEndian.Uint16(record[idxt+4+2*i:]):]
+			index.labels = append(index.labels, string(entry[1:1+entry[0]]))
+			entry = entry[1+entry[0]:]
+
+			var counts []byte
+			control := 0
+			for _, tag := range tags {
+				if tag.endFlag == 1 {
+					control++
+					continue
+				}
+				counts = append(counts, (entry[control]&tag.mask)>>indxMaskShift(tag.mask))
+			}
+			entry = entry[control:]
+
+			values := make(map[byte][]uint32)
+			j := 0
+			for _, tag := range tags {
+				if tag.endFlag == 1 {
+					continue
+				}
+				for k := 0; k < int(counts[j])*int(tag.valuesPerEntry); k++ {
+					v, n := decodeIndxInt(t, entry)
+					values[tag.number] = append(values[tag.number], v)
+					entry = entry[n:]
+				}
+				j++
+			}
+			index.values = append(index.values, values)
+		}
+	}
+	index.cncx = records[number+1+indexRecords : number+1+indexRecords+cncxRecords]
+	return index
+}
+
+// string returns string of CNCX at offset
+func (index kf8Index) string(t *testing.T, offset uint32) string {
+	t.Helper()
+	record := index.cncx[offset/indxMaxRecordSize][offset%indxMaxRecordSize:]
+	length, n := decodeIndxInt(t, record)
+	return string(record[n : n+int(length)])
+}
+
+func TestEncodeIndxInt(t *testing.T) {
+	tests := []struct {
+		v    uint32
+		want []byte
+	}{
+		{0, []byte{0x80}},
+		{0x7f, []byte{0xff}},
+		{0x80, []byte{0x01, 0x80}},
+		{0x3fff, []byte{0x7f, 0xff}},
+		{0x4000, []byte{0x01, 0x00, 0x80}},
+	}
+	for _, tt := range tests {
+		got := encodeIndxInt(nil, tt.v)
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("encodeIndxInt(%#x) = %x, want %x", tt.v, got, tt.want)
+		}
+		if v, n := decodeIndxInt(t, got); v != tt.v || n != len(got) {
+			t.Errorf("decoded %#x of %d bytes, want %#x", v, n, tt.v)
+		}
+	}
+}
+
+func TestWriteAZW3(t *testing.T) {
+	cb := formatTestBook(t, FormatAZW3)
+	cb.Pages[0].Kind = PageKindCover
+	var buf bytes.Buffer
+	if _, err := cb.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	records := readPalmDB(t, buf.Bytes())
+
+	record0 := records[0]
+	if version := binary.BigEndian.Uint32(record0[16+20:]); version != kf8FileVersion {
+		t.Fatalf("file version is %d, want %d", version, kf8FileVersion)
+	}
+	u32 := func(offset int) int { return int(binary.BigEndian.Uint32(record0[16+offset:])) }
+	firstResource, fdst := u32(92), u32(176)
+	ncxIndex, fragIndex, skelIndex := u32(228), u32(232), u32(236)
+
+	// text records end with zero trailing entry
+	textLength := int(binary.BigEndian.Uint32(record0[4:]))
+	textRecords := int(binary.BigEndian.Uint16(record0[8:]))
+	var text []byte
+	for i, record := range records[1 : 1+textRecords] {
+		if record[len(record)-1] != 0 {
+			t.Errorf("text record %d has trailing entry %d", i, record[len(record)-1])
+		}
+		text = append(text, record[:len(record)-1]...)
+	}
+	if len(text) != textLength {
+		t.Fatalf("text has %d bytes, want %d", len(text), textLength)
+	}
+	if !bytes.Equal(records[fdst], kf8FDST(textLength)) {
+		t.Error("FDST record doesn't cover the whole text")
+	}
+
+	// every page is a skeleton with fragment showing page image
+	skel := readINDX(t, records, skelIndex)
+	frag := readINDX(t, records, fragIndex)
+	if len(skel.labels) != len(cb.Pages) || len(frag.labels) != len(cb.Pages) {
+		t.Fatalf("got %d skeletons and %d fragments, want %d", len(skel.labels), len(frag.labels), len(cb.Pages))
+	}
+	for i, p := range cb.Pages {
+		geometry := skel.values[i][6]
+		start, length := int(geometry[0]), int(geometry[1])
+		skeleton := text[start : start+length]
+
+		insert, err := strconv.Atoi(frag.labels[i])
+		if err != nil {
+			t.Fatalf("page %d: fragment label %q: %v", i, frag.labels[i], err)
+		}
+		fragmentLength := int(frag.values[i][6][1])
+		fragment := text[start+length : start+length+fragmentLength]
+		file := append(append(append([]byte{}, skeleton[:insert-start]...), fragment...), skeleton[insert-start:]...)
+
+		selector := frag.string(t, frag.values[i][2][0])
+		parent := kf8AidRe.FindSubmatch(skeleton)
+		if parent == nil || selector != fmt.Sprintf("P-//*[@aid='%s']", parent[1]) {
+			t.Errorf("page %d: fragment is inserted into %q", i, selector)
+		}
+		if !bytes.HasSuffix(file, []byte("</div></body></html>")) {
+			t.Errorf("page %d: fragment is not inserted into body: %s", i, file)
+		}
+
+		m := kf8EmbedRe.FindSubmatch(fragment)
+		if m == nil {
+			t.Fatalf("page %d shows no image: %s", i, fragment)
+		}
+		var resource int
+		for _, d := range m[1] {
+			resource = resource*32 + bytes.IndexByte([]byte(kf8Base32Digits), d)
+		}
+		if !bytes.Equal(records[firstResource+resource-1], p.Data) {
+			t.Errorf("page %d shows resource %d, which differs from page data", i, resource)
+		}
+	}
+	thumbIndex := firstResource + len(cb.Pages)
+	if !bytes.HasPrefix(records[thumbIndex], []byte("\xff\xd8")) {
+		t.Error("thumbnail is not JPEG")
+	}
+	if !bytes.Equal(records[len(records)-1], mobiEOF) {
+		t.Error("last record is not EOF")
+	}
+
+	// table of contents points to chapter starts
+	ncx := readINDX(t, records, ncxIndex)
+	var titles []string
+	var pages []int
+	for _, values := range ncx.values {
+		titles = append(titles, ncx.string(t, values[3][0]))
+		pages = append(pages, int(values[6][0]))
+	}
+	var wantTitles []string
+	var wantPages []int
+	for _, entry := range cb.TableOfContents() {
+		wantTitles = append(wantTitles, entry.Title)
+		wantPages = append(wantPages, entry.Page)
+	}
+	if !reflect.DeepEqual(titles, wantTitles) || !reflect.DeepEqual(pages, wantPages) {
+		t.Errorf("table of contents = %q at %v, want %q at %v", titles, pages, wantTitles, wantPages)
+	}
+
+	exth := readEXTH(t, record0, kf8Record0Header)
+	for kind, want := range map[uint32]string{
+		exthAuthor:             cb.Metadata.Writer,
+		exthLanguage:           cb.Metadata.LanguageISO,
+		exthUpdatedTitle:       cb.Name,
+		exthFixedLayout:        "true",
+		exthBookType:           "comic",
+		exthOriginalResolution: "60x80",
+		exthCoverURI:           "kindle:embed:0001",
+		exthWritingMode:        "horizontal-rl",
+		exthPageProgression:    "rtl",
+	} {
+		if got := string(exth[kind]); got != want {
+			t.Errorf("EXTH %d = %q, want %q", kind, got, want)
+		}
+	}
+	if got := binary.BigEndian.Uint32(exth[exthThumbOffset]); int(got) != len(cb.Pages) {
+		t.Errorf("thumbnail offset is %d, want %d", got, len(cb.Pages))
+	}
+}
+
+func TestWriteAZW3LeftToRight(t *testing.T) {
+	cb := formatTestBook(t, FormatAZW3, testChapter{volume: 1, number: 1, pages: 1})
+	cb.Metadata.RightToLeft = false
+	var buf bytes.Buffer
+	if _, err := cb.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	exth := readEXTH(t, readPalmDB(t, buf.Bytes())[0], kf8Record0Header)
+	if got := string(exth[exthPageProgression]); got != "ltr" {
+		t.Errorf("page progression is %q, want ltr", got)
+	}
+}
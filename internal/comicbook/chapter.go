@@ -2,10 +2,20 @@ package comicbook
 
 import (
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 )
 
+var (
+	volumeNumberRe  = regexp.MustCompile(`(?i)\bv(?:ol(?:ume)?)?\.?\s*(\d+)`)
+	chapterNumberRe = regexp.MustCompile(`(?i)\b(?:ch(?:apter)?|c)\.?\s*(\d+(?:\.\d+)?)`)
+	anyNumberRe     = regexp.MustCompile(`\d+(?:\.\d+)?`)
+	// matches names formatted by `ChapterInfo.String`
+	chapterDirnameRe = regexp.MustCompile(`^Chapter (\d+(?:\.\d+)?) - (.*)$`)
+	volumeDirnameRe  = regexp.MustCompile(`^Volume (\d+)$`)
+)
+
 type ChapterInfo struct {
 	// Name of chapter
 	// Taken from cbz file name
@@ -15,6 +25,11 @@ type ChapterInfo struct {
 	Number float64
 	// Volume number
 	Volume int
+
+	// whether Number was actually parsed from name
+	numbered bool
+	// whether Volume was actually parsed, it's 1 otherwise
+	hasVolume bool
 }
 
 func ChapterInfoFromName(name string) *ChapterInfo {
@@ -23,9 +38,89 @@ func ChapterInfoFromName(name string) *ChapterInfo {
 		Volume: 1,
 	}
 
+	if parseMangalChapterName(info, name) {
+		return info
+	}
+
+	// fallback for names not produced by `mangal`,
+	// e.g. "Vol.2 Chapter 10", "Ch. 10.5" or "Some Manga 010"
+	if m := volumeNumberRe.FindStringSubmatch(name); m != nil {
+		if volume, err := strconv.Atoi(m[1]); err == nil {
+			info.Volume = volume
+			info.hasVolume = true
+		}
+	}
+
+	numberStr := ""
+	if m := chapterNumberRe.FindStringSubmatch(name); m != nil {
+		numberStr = m[1]
+	} else {
+		// do not mistake volume number for chapter number
+		withoutVolume := volumeNumberRe.ReplaceAllString(name, "")
+		numberStr = anyNumberRe.FindString(withoutVolume)
+	}
+	if number, err := strconv.ParseFloat(numberStr, 64); err == nil {
+		info.Number = number
+		info.numbered = true
+	}
+
+	return info
+}
+
+// ChapterInfoFromDirname parses chapter directory name produced by `ChapterInfo.String`
+func ChapterInfoFromDirname(dirname string) *ChapterInfo {
+	m := chapterDirnameRe.FindStringSubmatch(dirname)
+	if m == nil {
+		return ChapterInfoFromName(dirname)
+	}
+
+	number, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return ChapterInfoFromName(dirname)
+	}
+
+	return &ChapterInfo{
+		Name:     m[2],
+		Number:   number,
+		Volume:   1,
+		numbered: true,
+	}
+}
+
+// isMergedLayout reports if archive directories are "Volume <number>/<chapter dirname>",
+// as written for merged books
+func isMergedLayout(dirs []string) bool {
+	return len(dirs) == 2 && volumeDirnameRe.MatchString(dirs[0])
+}
+
+// chapterInfoFromDirs parses chapter info from archive directories
+// in "Volume <number>/<chapter dirname>" layout
+func chapterInfoFromDirs(dirs []string) *ChapterInfo {
+	volume := 0
+	var info *ChapterInfo
+	for _, dir := range dirs {
+		if m := volumeDirnameRe.FindStringSubmatch(dir); m != nil {
+			volume, _ = strconv.Atoi(m[1])
+		} else {
+			info = ChapterInfoFromDirname(dir)
+		}
+	}
+
+	if info == nil {
+		info = &ChapterInfo{Name: strings.Join(dirs, " "), Volume: 1}
+	}
+	if volume > 0 {
+		info.Volume = volume
+		info.hasVolume = true
+	}
+	return info
+}
+
+// parseMangalChapterName parses names in format "[<number>] <name>"
+func parseMangalChapterName(info *ChapterInfo, name string) bool {
 	numberStr, name, ok := strings.Cut(name, " ")
 	if !ok {
-		return info
+		return false
 	}
 
 	// NOTE: chapter number parsing from path for now is closely tied to how `mangal` saves them
@@ -35,21 +130,40 @@ func ChapterInfoFromName(name string) *ChapterInfo {
 	})
 	number, err := strconv.ParseFloat(numberStr, 64)
 	if err != nil {
-		return info
+		return false
 	}
 
 	name = strings.ReplaceAll(name, "_", " ")
 
 	info.Number = number
 	info.Name = name
+	info.numbered = true
 
-	return info
+	return true
+}
+
+// HasNumber reports whether chapter number was found in chapter name
+func (ci *ChapterInfo) HasNumber() bool {
+	return ci.numbered
+}
+
+// HasVolume reports whether volume number was found, chapters without it are in volume 1
+func (ci *ChapterInfo) HasVolume() bool {
+	return ci.hasVolume
+}
+
+// Less reports whether chapter ci goes before chapter other in reading order
+func (ci *ChapterInfo) Less(other *ChapterInfo) bool {
+	if ci.Volume != other.Volume {
+		return ci.Volume < other.Volume
+	}
+	return ci.Number < other.Number
 }
 
 func (ci *ChapterInfo) String() string {
 	var name string
-	if strings.HasPrefix(ci.Name, "Chapter") {
-		// if chapter name starts with "Chapter",
+	if !ci.numbered || strings.HasPrefix(ci.Name, "Chapter") {
+		// if chapter has no number or chapter name starts with "Chapter",
 		// it probably already already contains chapter number
 		// so just use name as is
 		name = ci.Name
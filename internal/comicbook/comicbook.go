@@ -3,101 +3,390 @@ package comicbook
 import (
 	"archive/zip"
 	"bytes"
+	"fmt"
+	"image"
 	"io"
+	"os"
+	"path/filepath"
 	"sort"
+	"strings"
+	"time"
 
+	"github.com/abbit/m4k/internal/naming"
 	"github.com/abbit/m4k/internal/util"
 )
 
+// Metadata of the series
+type Metadata struct {
+	Summary     string
+	Writer      string
+	LanguageISO string
+	// Pages are read from right to left, as usual for manga
+	RightToLeft bool
+}
+
 type ComicBook struct {
-	Pages   []*Page
-	Name    string
-	cbzData []byte
+	Pages []*Page
+	Name  string
+	// Series title used in naming templates. Default: Name
+	Series string
+	// Template for page paths inside of archive. Default: naming.DefaultPagePath
+	PagePath *naming.Template
+	// Template for names of volumes the book is split into. Default: naming.DefaultVolumeName
+	VolumeName *naming.Template
+	Metadata   Metadata
+	// Chapter info of the book, nil for books merged from several chapters
+	ChapterInfo *ChapterInfo
+	// Modification time of the file the book was read from
+	ModTime time.Time
+	// Problems found while reading or transforming the book
+	Repairs *RepairReport
+	// Output format. Default: FormatCBZ
+	Format Format
+	// Device screen size for fixed-layout formats. Default: size of the first page
+	Viewport image.Point
+	data     []byte
+}
+
+type ReadOptions struct {
+	// Recover what can be read from broken archives instead of failing,
+	// problems are collected to ComicBook.Repairs
+	Tolerant bool
 }
 
 func ReadComicBook(path string) (*ComicBook, error) {
-	r, err := zip.OpenReader(path)
+	return ReadComicBookWithOptions(path, nil)
+}
+
+func ReadComicBookWithOptions(path string, opts *ReadOptions) (*ComicBook, error) {
+	if opts == nil {
+		opts = &ReadOptions{}
+	}
+
+	stat, err := os.Stat(path)
 	if err != nil {
 		return nil, err
 	}
 
+	repairs := &RepairReport{}
+	// strict reading reports nothing and fails instead
+	var tolerantRepairs *RepairReport
+	if opts.Tolerant {
+		tolerantRepairs = repairs
+	}
+
+	entries, closeArchive, err := openArchive(path, tolerantRepairs)
+	if err != nil {
+		return nil, err
+	}
+	defer closeArchive()
+
 	name := util.WithoutPaddedIndex(util.PathStem(path))
-	chapterInfo := ChapterInfoFromName(name)
+	fileChapterInfo := ChapterInfoFromName(name)
 
-	var pages []*Page
-	for _, f := range r.File {
-		if util.IsImage(f.Name) {
-			page, err := PageFromFile(f, chapterInfo)
+	var comicInfo *ComicInfo
+	for _, entry := range entries {
+		if isComicInfo(entry.name) {
+			comicInfo, err = readComicInfoEntry(entry)
 			if err != nil {
-				return nil, err
+				if !opts.Tolerant {
+					return nil, err
+				}
+				repairs.Add(entry.name, RepairSkipped, err)
+				break
+			}
+			comicInfo.applyTo(fileChapterInfo)
+			break
+		}
+	}
+
+	// merged books keep chapters in "Volume <number>/<chapter>/" directories,
+	// pages from the same directory share chapter info. Pages in other directories,
+	// as in many chapter releases, keep chapter info of the file.
+	dirChapterInfos := make(map[string]*ChapterInfo)
+	var pages []*Page
+	for i, entry := range entries {
+		if !util.IsImage(entry.name) {
+			continue
+		}
+
+		chapterInfo := fileChapterInfo
+		if dirs := archiveDirs(entry.name); isMergedLayout(dirs) {
+			key := strings.Join(dirs, "/")
+			if chapterInfo = dirChapterInfos[key]; chapterInfo == nil {
+				chapterInfo = chapterInfoFromDirs(dirs)
+				dirChapterInfos[key] = chapterInfo
 			}
-			pages = append(pages, page)
 		}
+
+		page, err := pageFromEntry(entry, chapterInfo, i, tolerantRepairs)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", entry.name, err)
+		}
+		pages = append(pages, page)
+	}
+	sortPages(pages)
+	if comicInfo != nil {
+		comicInfo.applyPageTypes(pages)
+	}
+
+	bookChapterInfo := fileChapterInfo
+	switch {
+	case len(dirChapterInfos) == 1:
+		for _, info := range dirChapterInfos {
+			bookChapterInfo = info
+		}
+	case len(dirChapterInfos) > 1:
+		bookChapterInfo = nil
+	case comicInfo != nil && comicInfo.applyBookmarks(pages):
+		bookChapterInfo = nil
+	}
+
+	cb := &ComicBook{
+		Pages:       pages,
+		Name:        name,
+		ChapterInfo: bookChapterInfo,
+		ModTime:     stat.ModTime(),
+		Repairs:     repairs,
+	}
+	if comicInfo != nil {
+		cb.Series = comicInfo.Series
+		cb.Metadata = comicInfo.metadata()
+	}
+
+	return cb, nil
+}
+
+// sortPages sorts pages by page number.
+// If page numbers are not unique, e.g. numbering restarts in each chapter directory,
+// pages are sorted by chapter first.
+func sortPages(pages []*Page) {
+	numbers := make(map[uint64]bool, len(pages))
+	unique := true
+	for _, page := range pages {
+		if numbers[page.Number] {
+			unique = false
+			break
+		}
+		numbers[page.Number] = true
+	}
+
+	sort.SliceStable(pages, func(i, j int) bool {
+		a, b := pages[i].ChapterInfo, pages[j].ChapterInfo
+		if !unique && a != b && (a.Less(b) || b.Less(a)) {
+			return a.Less(b)
+		}
+		return pages[i].Number < pages[j].Number
+	})
+}
+
+// openArchive returns entries of zip archive.
+// If repairs is not nil and archive can't be opened, entries are recovered from its raw data.
+func openArchive(path string, repairs *RepairReport) ([]archiveEntry, func() error, error) {
+	r, err := zip.OpenReader(path)
+	if err == nil {
+		return zipEntries(&r.Reader), r.Close, nil
+	}
+	if repairs == nil {
+		return nil, nil, err
+	}
+
+	data, readErr := os.ReadFile(path)
+	if readErr != nil {
+		return nil, nil, readErr
+	}
+	entries := scanZipEntries(data)
+	if len(entries) == 0 {
+		return nil, nil, err
+	}
+	repairs.Add(filepath.Base(path), RepairRecovered, fmt.Errorf("recovered %d files from broken archive: %w", len(entries), err))
+
+	return entries, func() error { return nil }, nil
+}
+
+func readComicInfoEntry(entry archiveEntry) (*ComicInfo, error) {
+	file, err := entry.open()
+	if err != nil {
+		return nil, err
 	}
-	// sort pages by page number
-	sort.Slice(pages, func(i, j int) bool { return pages[i].Number < pages[j].Number })
+	defer file.Close()
 
-	return &ComicBook{Pages: pages, Name: name}, nil
+	return ReadComicInfo(file)
 }
 
 func (cb *ComicBook) FileName() string {
-	return util.SanitizePath(cb.Name) + ".cbz"
+	return util.SanitizePath(cb.Name) + cb.format().Extension()
 }
 
-func (cb *ComicBook) WriteTo(wr io.Writer) (n int64, err error) {
+func (cb *ComicBook) format() Format {
+	if len(cb.Format) == 0 {
+		return FormatCBZ
+	}
+	return cb.Format
+}
+
+// WriteTo writes the book in its output format
+func (cb *ComicBook) WriteTo(wr io.Writer) (int64, error) {
+	cw := &countingWriter{w: wr}
+	var err error
+	switch format := cb.format(); format {
+	case FormatCBZ:
+		err = cb.writeCBZ(cw)
+	case FormatEPUB:
+		err = cb.writeEPUB(cw)
+	case FormatMOBI:
+		err = cb.writeMOBI(cw)
+	case FormatAZW3:
+		err = cb.writeAZW3(cw)
+	case FormatPDF:
+		err = cb.writePDF(cw)
+	default:
+		err = fmt.Errorf("unsupported format %q", format)
+	}
+	return cw.n, err
+}
+
+func (cb *ComicBook) writeCBZ(wr io.Writer) error {
 	w := zip.NewWriter(wr)
-	defer w.Close()
+
+	pagePath := cb.PagePath
+	if pagePath == nil {
+		pagePath = defaultPagePath
+	}
+	bookFields := cb.Fields()
 
 	// write pages to zip archive
 	for _, page := range cb.Pages {
-		file, err := w.Create(page.Filepath())
+		fields := page.Fields(bookFields.Series)
+		fields.RangeFrom, fields.RangeTo, fields.Range = bookFields.RangeFrom, bookFields.RangeTo, bookFields.Range
+		fields.ShortRange = bookFields.ShortRange
+		path, err := pagePath.ExecutePath(fields)
 		if err != nil {
-			return n, err
+			return err
 		}
 
-		nfile, err := file.Write(page.Data)
-		n += int64(nfile)
+		file, err := w.Create(path)
 		if err != nil {
-			return n, err
+			return err
 		}
+
+		if _, err := file.Write(page.Data); err != nil {
+			return err
+		}
+	}
+
+	// write metadata with table of contents
+	comicInfo, err := cb.ComicInfo().Marshal()
+	if err != nil {
+		return err
+	}
+	file, err := w.Create(ComicInfoFilename)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(comicInfo); err != nil {
+		return err
 	}
 
-	return
+	return w.Close()
 }
 
-func (cb *ComicBook) fillCbzData() error {
+func (cb *ComicBook) fillData() error {
 	var buf bytes.Buffer
 	if _, err := cb.WriteTo(&buf); err != nil {
 		return err
 	}
-	cb.cbzData = buf.Bytes()
+	cb.data = buf.Bytes()
 	return nil
 }
 
+// Reader returns reader of the book written in its output format
 func (cb *ComicBook) Reader() (*bytes.Reader, error) {
-	if len(cb.cbzData) == 0 {
-		if err := cb.fillCbzData(); err != nil {
+	if len(cb.data) == 0 {
+		if err := cb.fillData(); err != nil {
 			return nil, err
 		}
 	}
 
-	return bytes.NewReader(cb.cbzData), nil
+	return bytes.NewReader(cb.data), nil
 }
 
-func MergeComicBooks(comicbooks []*ComicBook, name string) *ComicBook {
-	var pages []*Page
-	pageNumber := uint64(0)
-	for _, comicbook := range comicbooks {
-		for _, p := range comicbook.Pages {
-			pageNumber++
-			pages = append(pages, &Page{
-				Data:        p.Data,
-				Extension:   p.Extension,
-				Number:      pageNumber,
-				ChapterInfo: p.ChapterInfo,
-			})
+// Size returns total size of pages data in bytes
+func (cb *ComicBook) Size() int64 {
+	return pagesSize(cb.Pages)
+}
+
+// ChapterPages returns pages grouped by chapter, in pages order
+func (cb *ComicBook) ChapterPages() [][]*Page {
+	var chapters [][]*Page
+	for i, page := range cb.Pages {
+		if i == 0 || page.ChapterInfo != cb.Pages[i-1].ChapterInfo {
+			chapters = append(chapters, nil)
+		}
+		chapters[len(chapters)-1] = append(chapters[len(chapters)-1], page)
+	}
+	return chapters
+}
+
+// Fields returns book fields for naming templates
+func (cb *ComicBook) Fields() naming.Fields {
+	fields := naming.Fields{Series: cb.Series}
+	if len(fields.Series) == 0 {
+		fields.Series = cb.Name
+	}
+
+	var numbered []*ChapterInfo
+	for _, chapter := range cb.ChapterPages() {
+		if info := chapter[0].ChapterInfo; info.HasNumber() {
+			numbered = append(numbered, info)
+		}
+	}
+	if len(numbered) > 0 {
+		first, last := numbered[0], numbered[len(numbered)-1]
+		fields.Volume = first.Volume
+		fields.Chapter = first.Number
+		fields.ChapterTitle = first.Name
+		fields.ChapterFull = first.String()
+		fields.RangeFrom, fields.RangeTo = first.Number, last.Number
+		fields.Range = naming.FormatRange(first.Number, last.Number)
+		fields.ShortRange = naming.FormatShortRange(first.Number, last.Number)
+	}
+
+	return fields
+}
+
+// Renumber numbers pages from 1 in current order
+func (cb *ComicBook) Renumber() {
+	for i, page := range cb.Pages {
+		page.Number = uint64(i + 1)
+	}
+}
+
+// RemovePages removes pages for which remove returns true and renumbers the rest,
+// returns removed pages
+func (cb *ComicBook) RemovePages(remove func(*Page) bool) []*Page {
+	var kept, removed []*Page
+	for _, page := range cb.Pages {
+		if remove(page) {
+			removed = append(removed, page)
+		} else {
+			kept = append(kept, page)
 		}
 	}
 
-	return &ComicBook{Name: name, Pages: pages}
+	cb.Pages = kept
+	cb.Renumber()
+
+	return removed
+}
+
+// ColorPages returns number of pages kept in color
+func (cb *ComicBook) ColorPages() int {
+	count := 0
+	for _, page := range cb.Pages {
+		if page.Color {
+			count++
+		}
+	}
+	return count
 }
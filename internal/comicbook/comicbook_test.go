@@ -0,0 +1,172 @@
+package comicbook
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testImage returns JPEG image of given size filled with gray level
+func testImage(t *testing.T, width, height int, level uint8) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = level
+	}
+	img.SetGray(0, 0, color.Gray{Y: 255 - level})
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testChapter is a chapter of test book, volume 0 is unknown
+type testChapter struct {
+	volume int
+	number float64
+	pages  int
+}
+
+// testBook returns book with chapters of small pages numbered from 1
+func testBook(t *testing.T, name string, chapters ...testChapter) *ComicBook {
+	t.Helper()
+	cb := &ComicBook{Name: name, Viewport: image.Pt(60, 80)}
+	for _, ch := range chapters {
+		info := &ChapterInfo{Name: "Test", Number: ch.number, Volume: ch.volume, numbered: true, hasVolume: ch.volume > 0}
+		if ch.volume == 0 {
+			info.Volume = 1
+		}
+		for i := 0; i < ch.pages; i++ {
+			cb.Pages = append(cb.Pages, &Page{
+				Data:        testImage(t, 60, 80, uint8(40*i)),
+				Extension:   ".jpg",
+				ChapterInfo: info,
+			})
+		}
+	}
+	cb.Renumber()
+	return cb
+}
+
+// writeTestArchive writes zip archive with files of given names to dir
+func writeTestArchive(t *testing.T, dir, name string, files map[string][]byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// formatTestBook returns book of several chapters with metadata set
+func formatTestBook(t *testing.T, format Format, chapters ...testChapter) *ComicBook {
+	t.Helper()
+	if len(chapters) == 0 {
+		chapters = []testChapter{
+			{volume: 1, number: 1, pages: 2},
+			{volume: 1, number: 2, pages: 3},
+			{volume: 2, number: 3, pages: 1},
+		}
+	}
+	cb := testBook(t, "Test", chapters...)
+	cb.Format = format
+	cb.Metadata = Metadata{
+		Summary:     "Summary",
+		Writer:      "Writer",
+		LanguageISO: "ja",
+		RightToLeft: true,
+	}
+	return cb
+}
+
+// writeTestBook writes book in its format to dir and returns file path
+func writeTestBook(t *testing.T, dir string, cb *ComicBook) string {
+	t.Helper()
+	path := filepath.Join(dir, cb.FileName())
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := cb.WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadComicBookChapterInfo(t *testing.T) {
+	page := testImage(t, 10, 10, 0)
+	tests := []struct {
+		name    string
+		file    string
+		pages   []string
+		volumes []int
+		numbers []float64
+	}{
+		{
+			name:    "pages at root",
+			file:    "[000005.0] Foo.cbz",
+			pages:   []string{"001.jpg", "002.jpg"},
+			volumes: []int{1, 1},
+			numbers: []float64{5, 5},
+		},
+		{
+			name:    "pages in folder",
+			file:    "Vol.2 Ch.7 Bar.cbz",
+			pages:   []string{"Bar Chapter 7/001.jpg", "Bar Chapter 7/002.jpg"},
+			volumes: []int{2, 2},
+			numbers: []float64{7, 7},
+		},
+		{
+			name:    "merged layout",
+			file:    "Merged.cbz",
+			pages:   []string{"Volume 1/Chapter 1.0 - A/000001.jpg", "Volume 2/Chapter 2.0 - B/000002.jpg"},
+			volumes: []int{1, 2},
+			numbers: []float64{1, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := make(map[string][]byte)
+			for _, name := range tt.pages {
+				files[name] = page
+			}
+			cb, err := ReadComicBook(writeTestArchive(t, t.TempDir(), tt.file, files))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cb.Pages) != len(tt.pages) {
+				t.Fatalf("got %d pages, want %d", len(cb.Pages), len(tt.pages))
+			}
+			for i, p := range cb.Pages {
+				if p.ChapterInfo.Volume != tt.volumes[i] || p.ChapterInfo.Number != tt.numbers[i] {
+					t.Errorf("page %d: got volume %d chapter %g, want volume %d chapter %g",
+						i, p.ChapterInfo.Volume, p.ChapterInfo.Number, tt.volumes[i], tt.numbers[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package comicbook
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const ComicInfoFilename = "ComicInfo.xml"
+
+// ComicInfo is a subset of ComicInfo.xml metadata used by m4k
+// https://anansi-project.github.io/docs/comicinfo/documentation
+type ComicInfo struct {
+	XMLName xml.Name `xml:"ComicInfo"`
+
+	Title       string          `xml:"Title,omitempty"`
+	Series      string          `xml:"Series,omitempty"`
+	Number      string          `xml:"Number,omitempty"`
+	Volume      int             `xml:"Volume,omitempty"`
+	Summary     string          `xml:"Summary,omitempty"`
+	Writer      string          `xml:"Writer,omitempty"`
+	LanguageISO string          `xml:"LanguageISO,omitempty"`
+	Manga       string          `xml:"Manga,omitempty"`
+	PageCount   int             `xml:"PageCount,omitempty"`
+	Pages       []ComicInfoPage `xml:"Pages>Page,omitempty"`
+}
+
+type ComicInfoPage struct {
+	// Index of page in archive
+	Image    int    `xml:"Image,attr"`
+	Type     string `xml:"Type,attr,omitempty"`
+	Bookmark string `xml:"Bookmark,attr,omitempty"`
+}
+
+const (
+	comicInfoPageTypeCover = "FrontCover"
+	comicInfoPageTypeStory = "Story"
+	comicInfoMangaRTL      = "YesAndRightToLeft"
+)
+
+func isComicInfo(path string) bool {
+	return strings.EqualFold(filepath.Base(path), ComicInfoFilename)
+}
+
+func ReadComicInfo(r io.Reader) (*ComicInfo, error) {
+	var info ComicInfo
+	if err := xml.NewDecoder(r).Decode(&info); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", ComicInfoFilename, err)
+	}
+	return &info, nil
+}
+
+// ComicInfo returns ComicInfo.xml metadata of the book
+// with chapter starts as page bookmarks
+func (cb *ComicBook) ComicInfo() *ComicInfo {
+	fields := cb.Fields()
+	info := &ComicInfo{
+		Title:       cb.Name,
+		Series:      fields.Series,
+		Summary:     cb.Metadata.Summary,
+		Writer:      cb.Metadata.Writer,
+		LanguageISO: cb.Metadata.LanguageISO,
+		PageCount:   len(cb.Pages),
+	}
+	if cb.Metadata.RightToLeft {
+		info.Manga = comicInfoMangaRTL
+	}
+
+	toc := cb.TableOfContents()
+	if len(toc) == 1 && toc[0].ChapterInfo.HasNumber() {
+		info.Number = strconv.FormatFloat(toc[0].ChapterInfo.Number, 'f', -1, 64)
+		info.Volume = toc[0].ChapterInfo.Volume
+	}
+
+	bookmarks := make(map[int]string, len(toc))
+	for _, entry := range toc {
+		bookmarks[entry.Page] = entry.Title
+	}
+	for i, page := range cb.Pages {
+		infoPage := ComicInfoPage{
+			Image:    i,
+			Type:     comicInfoPageTypeStory,
+			Bookmark: bookmarks[i],
+		}
+		if page.Kind == PageKindCover {
+			infoPage.Type = comicInfoPageTypeCover
+		}
+		info.Pages = append(info.Pages, infoPage)
+	}
+
+	return info
+}
+
+func (ci *ComicInfo) Marshal() ([]byte, error) {
+	data, err := xml.MarshalIndent(ci, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// metadata returns series metadata from ComicInfo
+func (ci *ComicInfo) metadata() Metadata {
+	return Metadata{
+		Summary:     ci.Summary,
+		Writer:      ci.Writer,
+		LanguageISO: ci.LanguageISO,
+		RightToLeft: ci.Manga == comicInfoMangaRTL,
+	}
+}
+
+// applyTo fills chapter info fields missing from file name
+func (ci *ComicInfo) applyTo(info *ChapterInfo) {
+	if info.HasNumber() {
+		return
+	}
+
+	number, err := strconv.ParseFloat(strings.TrimSpace(ci.Number), 64)
+	if err != nil {
+		return
+	}
+	info.Number = number
+	info.numbered = true
+	if ci.Volume > 0 {
+		info.Volume = ci.Volume
+		info.hasVolume = true
+	}
+	if len(ci.Title) > 0 {
+		info.Name = ci.Title
+	}
+}
+
+// applyPageTypes marks cover pages, pages are sorted in archive order
+func (ci *ComicInfo) applyPageTypes(pages []*Page) {
+	for _, p := range ci.Pages {
+		if p.Type == comicInfoPageTypeCover && p.Image >= 0 && p.Image < len(pages) {
+			pages[p.Image].Kind = PageKindCover
+		}
+	}
+}
+
+// applyBookmarks assigns chapters to pages sorted in archive order
+// using page bookmarks as chapter starts
+func (ci *ComicInfo) applyBookmarks(pages []*Page) bool {
+	var applied bool
+	var current *ChapterInfo
+	bookmarks := make(map[int]string, len(ci.Pages))
+	for _, p := range ci.Pages {
+		if len(p.Bookmark) > 0 {
+			bookmarks[p.Image] = p.Bookmark
+		}
+	}
+
+	for i, page := range pages {
+		if bookmark, ok := bookmarks[i]; ok {
+			current = ChapterInfoFromDirname(bookmark)
+			current.Volume = page.ChapterInfo.Volume
+			current.hasVolume = page.ChapterInfo.hasVolume
+			applied = true
+		}
+		if current != nil {
+			page.ChapterInfo = current
+		}
+	}
+
+	return applied
+}
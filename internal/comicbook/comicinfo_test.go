@@ -0,0 +1,92 @@
+package comicbook
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/abbit/m4k/internal/naming"
+)
+
+func TestCBZRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		pagePath *naming.Template
+		chapters []testChapter
+	}{
+		{
+			name: "merged layout",
+			chapters: []testChapter{
+				{volume: 1, number: 1, pages: 2},
+				{volume: 1, number: 2, pages: 3},
+				{volume: 2, number: 3, pages: 1},
+			},
+		},
+		{
+			// chapters are only known from ComicInfo bookmarks
+			name:     "flat with bookmarks",
+			pagePath: naming.MustParse("page", `{{printf "%06d" .Page}}{{.Ext}}`),
+			chapters: []testChapter{
+				{volume: 1, number: 1, pages: 2},
+				{volume: 1, number: 2, pages: 3},
+				{volume: 1, number: 3.5, pages: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := formatTestBook(t, FormatCBZ, tt.chapters...)
+			cb.PagePath = tt.pagePath
+
+			read, err := ReadComicBook(writeTestBook(t, t.TempDir(), cb))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if read.Metadata != cb.Metadata {
+				t.Errorf("metadata = %+v, want %+v", read.Metadata, cb.Metadata)
+			}
+			if read.ChapterInfo != nil {
+				t.Errorf("book of several chapters has chapter info %v", read.ChapterInfo)
+			}
+			if len(read.Pages) != len(cb.Pages) {
+				t.Fatalf("read %d pages, want %d", len(read.Pages), len(cb.Pages))
+			}
+			for i, p := range read.Pages {
+				want := cb.Pages[i]
+				if !bytes.Equal(p.Data, want.Data) {
+					t.Errorf("page %d data differs", i)
+				}
+				if p.ChapterInfo.Volume != want.ChapterInfo.Volume || p.ChapterInfo.Number != want.ChapterInfo.Number ||
+					p.ChapterInfo.Name != want.ChapterInfo.Name {
+					t.Errorf("page %d chapter = %+v, want %+v", i, p.ChapterInfo, want.ChapterInfo)
+				}
+			}
+			if got, want := len(read.ChapterPages()), len(cb.ChapterPages()); got != want {
+				t.Errorf("read %d chapters, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestComicInfoBookmarks(t *testing.T) {
+	cb := testBook(t, "Test",
+		testChapter{volume: 1, number: 1, pages: 3},
+		testChapter{volume: 1, number: 2, pages: 2},
+	)
+	// cover is not a part of the first chapter
+	cb.Pages[0].Kind = PageKindCover
+
+	info := cb.ComicInfo()
+	if info.PageCount != len(cb.Pages) {
+		t.Errorf("page count = %d, want %d", info.PageCount, len(cb.Pages))
+	}
+	want := map[int]string{1: "Chapter 1.0 - Test", 3: "Chapter 2.0 - Test"}
+	for _, p := range info.Pages {
+		if p.Bookmark != want[p.Image] {
+			t.Errorf("page %d bookmark = %q, want %q", p.Image, p.Bookmark, want[p.Image])
+		}
+	}
+	if info.Pages[0].Type != comicInfoPageTypeCover {
+		t.Errorf("first page type = %q, want %q", info.Pages[0].Type, comicInfoPageTypeCover)
+	}
+}
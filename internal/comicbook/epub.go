@@ -0,0 +1,325 @@
+package comicbook
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	// register decoders to read page sizes
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	epubMimetype     = "application/epub+zip"
+	epubDefaultLang  = "en"
+	epubPackagePath  = "OEBPS/content.opf"
+	epubNavPath      = "nav.xhtml"
+	epubStylePath    = "style.css"
+	epubPageTemplate = "page-%04d"
+)
+
+var epubImageMediaTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+// epubPage is a page of EPUB, paths are relative to package document
+type epubPage struct {
+	ID        string
+	XHTML     string
+	Image     string
+	MediaType string
+	Width     int
+	Height    int
+	Cover     bool
+	data      []byte
+}
+
+type epubNavEntry struct {
+	Title string
+	XHTML string
+}
+
+type epubPageData struct {
+	Book *epubBook
+	Page epubPage
+}
+
+// epubFile is a file of EPUB generated from template
+type epubFile struct {
+	path string
+	tmpl *template.Template
+	data any
+}
+
+type epubBook struct {
+	ID          string
+	Title       string
+	Series      string
+	Writer      string
+	Summary     string
+	Language    string
+	Modified    string
+	RightToLeft bool
+	Viewport    image.Point
+	Pages       []epubPage
+	CoverImage  string
+	TOC         []epubNavEntry
+}
+
+// writeEPUB writes fixed-layout EPUB3 with one XHTML page per image
+func (cb *ComicBook) writeEPUB(wr io.Writer) error {
+	book, err := cb.epubBook()
+	if err != nil {
+		return err
+	}
+
+	w := zip.NewWriter(wr)
+
+	// mimetype must be the first file, stored uncompressed
+	mimetype, err := w.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(mimetype, epubMimetype); err != nil {
+		return err
+	}
+
+	files := []epubFile{
+		{"META-INF/container.xml", epubContainerTemplate, book},
+		{epubPackagePath, epubPackageTemplate, book},
+		{"OEBPS/" + epubNavPath, epubNavTemplate, book},
+		{"OEBPS/" + epubStylePath, epubStyleTemplate, book},
+	}
+	for _, page := range book.Pages {
+		files = append(files, epubFile{"OEBPS/" + page.XHTML, epubPageTemplateXHTML, epubPageData{book, page}})
+	}
+	for _, file := range files {
+		fw, err := w.Create(file.path)
+		if err != nil {
+			return err
+		}
+		if err := file.tmpl.Execute(fw, file.data); err != nil {
+			return fmt.Errorf("while writing %s: %w", file.path, err)
+		}
+	}
+
+	for _, page := range book.Pages {
+		// images are compressed already
+		fw, err := w.CreateHeader(&zip.FileHeader{Name: "OEBPS/" + page.Image, Method: zip.Store})
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(page.data); err != nil {
+			return err
+		}
+	}
+
+	return w.Close()
+}
+
+func (cb *ComicBook) epubBook() (*epubBook, error) {
+	fields := cb.Fields()
+	book := &epubBook{
+		ID:          epubIdentifier(fields.Series, cb.Name),
+		Title:       cb.Name,
+		Series:      fields.Series,
+		Writer:      cb.Metadata.Writer,
+		Summary:     cb.Metadata.Summary,
+		Language:    cb.Metadata.LanguageISO,
+		RightToLeft: cb.Metadata.RightToLeft,
+		Viewport:    cb.Viewport,
+	}
+	if len(book.Language) == 0 {
+		book.Language = epubDefaultLang
+	}
+	modified := cb.ModTime
+	if modified.IsZero() {
+		modified = time.Now()
+	}
+	book.Modified = modified.UTC().Format(time.RFC3339)
+
+	coverIndex := 0
+	for i, page := range cb.Pages {
+		if page.Kind == PageKindCover {
+			coverIndex = i
+			break
+		}
+	}
+
+	for i, page := range cb.Pages {
+		mediaType, ok := epubImageMediaTypes[strings.ToLower(page.Extension)]
+		if !ok {
+			return nil, fmt.Errorf("page %s: unsupported image type %q", page.Filepath(), page.Extension)
+		}
+		config, _, err := image.DecodeConfig(bytes.NewReader(page.Data))
+		if err != nil {
+			return nil, fmt.Errorf("page %s: reading image size: %w", page.Filepath(), err)
+		}
+
+		id := fmt.Sprintf(epubPageTemplate, i+1)
+		book.Pages = append(book.Pages, epubPage{
+			ID:        id,
+			XHTML:     "pages/" + id + ".xhtml",
+			Image:     "images/" + id + strings.ToLower(page.Extension),
+			MediaType: mediaType,
+			Width:     config.Width,
+			Height:    config.Height,
+			Cover:     i == coverIndex,
+			data:      page.Data,
+		})
+	}
+	if len(book.Pages) == 0 {
+		return nil, fmt.Errorf("book has no pages")
+	}
+
+	book.CoverImage = book.Pages[coverIndex].ID + "-image"
+	if book.Viewport.X <= 0 || book.Viewport.Y <= 0 {
+		book.Viewport = image.Pt(book.Pages[0].Width, book.Pages[0].Height)
+	}
+
+	for _, entry := range cb.TableOfContents() {
+		book.TOC = append(book.TOC, epubNavEntry{
+			Title: entry.Title,
+			XHTML: book.Pages[entry.Page].XHTML,
+		})
+	}
+	// navigation document must not be empty
+	if len(book.TOC) == 0 {
+		book.TOC = append(book.TOC, epubNavEntry{Title: book.Title, XHTML: book.Pages[0].XHTML})
+	}
+
+	return book, nil
+}
+
+// epubIdentifier returns stable identifier, so rewritten book is recognized by readers
+func epubIdentifier(series, name string) string {
+	sum := sha1.Sum([]byte(series + "\x00" + name))
+	// format as version 5 UUID
+	sum[6] = sum[6]&0x0f | 0x50
+	sum[8] = sum[8]&0x3f | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+func escapeXML(s string) string {
+	var buf strings.Builder
+	// writing to strings.Builder can't fail
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+func parseEPUBTemplate(name, text string) *template.Template {
+	return template.Must(template.New(name).Funcs(template.FuncMap{"xml": escapeXML}).Parse(text))
+}
+
+var epubContainerTemplate = parseEPUBTemplate("container.xml", `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="`+epubPackagePath+`" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`)
+
+var epubPackageTemplate = parseEPUBTemplate("content.opf", `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id" prefix="rendition: http://www.idpf.org/vocab/rendition/#">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="book-id">{{.ID}}</dc:identifier>
+    <dc:title>{{xml .Title}}</dc:title>
+    <dc:language>{{xml .Language}}</dc:language>
+    {{- with .Writer}}
+    <dc:creator>{{xml .}}</dc:creator>
+    {{- end}}
+    {{- with .Summary}}
+    <dc:description>{{xml .}}</dc:description>
+    {{- end}}
+    <meta property="belongs-to-collection" id="series">{{xml .Series}}</meta>
+    <meta refines="#series" property="collection-type">series</meta>
+    <meta property="dcterms:modified">{{.Modified}}</meta>
+    <meta property="rendition:layout">pre-paginated</meta>
+    <meta property="rendition:orientation">portrait</meta>
+    <meta property="rendition:spread">none</meta>
+    <meta name="cover" content="{{.CoverImage}}"/>
+    <meta name="fixed-layout" content="true"/>
+    <meta name="book-type" content="comic"/>
+    <meta name="original-resolution" content="{{.Viewport.X}}x{{.Viewport.Y}}"/>
+    {{- if .RightToLeft}}
+    <meta name="primary-writing-mode" content="horizontal-rl"/>
+    {{- end}}
+  </metadata>
+  <manifest>
+    <item id="nav" href="`+epubNavPath+`" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="style" href="`+epubStylePath+`" media-type="text/css"/>
+    {{- range .Pages}}
+    <item id="{{.ID}}" href="{{.XHTML}}" media-type="application/xhtml+xml" properties="svg"/>
+    <item id="{{.ID}}-image" href="{{.Image}}" media-type="{{.MediaType}}"{{if .Cover}} properties="cover-image"{{end}}/>
+    {{- end}}
+  </manifest>
+  <spine{{if .RightToLeft}} page-progression-direction="rtl"{{end}}>
+    {{- range .Pages}}
+    <itemref idref="{{.ID}}"/>
+    {{- end}}
+  </spine>
+</package>
+`)
+
+var epubNavTemplate = parseEPUBTemplate("nav.xhtml", `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+  <title>{{xml .Title}}</title>
+</head>
+<body>
+  <nav epub:type="toc" id="toc">
+    <ol>
+      {{- range .TOC}}
+      <li><a href="{{.XHTML}}">{{xml .Title}}</a></li>
+      {{- end}}
+    </ol>
+  </nav>
+</body>
+</html>
+`)
+
+var epubStyleTemplate = parseEPUBTemplate("style.css", `html, body {
+  margin: 0;
+  padding: 0;
+  width: 100%;
+  height: 100%;
+  overflow: hidden;
+}
+svg {
+  display: block;
+  width: 100%;
+  height: 100%;
+}
+`)
+
+var epubPageTemplateXHTML = parseEPUBTemplate("page.xhtml", `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+  <title>{{xml .Book.Title}}</title>
+  <meta name="viewport" content="width={{.Book.Viewport.X}}, height={{.Book.Viewport.Y}}"/>
+  <link rel="stylesheet" type="text/css" href="../`+epubStylePath+`"/>
+</head>
+<body>
+  <svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" version="1.1" viewBox="0 0 {{.Page.Width}} {{.Page.Height}}" preserveAspectRatio="xMidYMid meet">
+    <image width="{{.Page.Width}}" height="{{.Page.Height}}" xlink:href="../{{.Page.Image}}"/>
+  </svg>
+</body>
+</html>
+`)
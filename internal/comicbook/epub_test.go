@@ -0,0 +1,112 @@
+package comicbook
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+// readZipFiles returns zip archive and its files by name
+func readZipFiles(t *testing.T, data []byte) (*zip.Reader, map[string][]byte) {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte, len(zr.File))
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], err = io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("reading %s: %v", f.Name, err)
+		}
+	}
+	return zr, files
+}
+
+type epubPackage struct {
+	Items []struct {
+		ID         string `xml:"id,attr"`
+		Href       string `xml:"href,attr"`
+		Properties string `xml:"properties,attr"`
+	} `xml:"manifest>item"`
+	Spine struct {
+		Direction string `xml:"page-progression-direction,attr"`
+		Itemrefs  []struct {
+			IDRef string `xml:"idref,attr"`
+		} `xml:"itemref"`
+	} `xml:"spine"`
+}
+
+func TestWriteEPUB(t *testing.T) {
+	cb := formatTestBook(t, FormatEPUB)
+	cb.Pages[0].Kind = PageKindCover
+	var buf bytes.Buffer
+	if _, err := cb.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, files := readZipFiles(t, buf.Bytes())
+
+	if first := zr.File[0]; first.Name != "mimetype" || first.Method != zip.Store {
+		t.Errorf("first file is %q with method %d, want stored mimetype", first.Name, first.Method)
+	}
+	if got := string(files["mimetype"]); got != epubMimetype {
+		t.Errorf("mimetype = %q", got)
+	}
+
+	var pkg epubPackage
+	if err := xml.Unmarshal(files[epubPackagePath], &pkg); err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Spine.Direction != "rtl" {
+		t.Errorf("page progression direction = %q, want rtl", pkg.Spine.Direction)
+	}
+	hrefs := make(map[string]string, len(pkg.Items))
+	var cover string
+	for _, item := range pkg.Items {
+		hrefs[item.ID] = "OEBPS/" + item.Href
+		if item.Properties == "cover-image" {
+			cover = hrefs[item.ID]
+		}
+	}
+	if len(cover) == 0 {
+		t.Error("cover image not found")
+	}
+
+	// spine has pages in order, each showing image of the page
+	if len(pkg.Spine.Itemrefs) != len(cb.Pages) {
+		t.Fatalf("spine has %d pages, want %d", len(pkg.Spine.Itemrefs), len(cb.Pages))
+	}
+	for i, ref := range pkg.Spine.Itemrefs {
+		xhtml, ok := files[hrefs[ref.IDRef]]
+		if !ok {
+			t.Errorf("page %d: xhtml of %q not found", i, ref.IDRef)
+			continue
+		}
+		image := hrefs[ref.IDRef+"-image"]
+		if i == 0 && image != cover {
+			t.Errorf("cover image is %s, want image of the first page %s", cover, image)
+		}
+		if !bytes.Contains(xhtml, []byte(filepath.Base(image))) {
+			t.Errorf("page %d: xhtml doesn't show image %s", i, image)
+		}
+		if !bytes.Equal(files[image], cb.Pages[i].Data) {
+			t.Errorf("page %d: image %s differs from page data", i, image)
+		}
+	}
+
+	// navigation has entry for every chapter
+	nav := files["OEBPS/"+epubNavPath]
+	for _, entry := range cb.TableOfContents() {
+		if !bytes.Contains(nav, []byte(entry.Title)) {
+			t.Errorf("navigation has no entry %q", entry.Title)
+		}
+	}
+}
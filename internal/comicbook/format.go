@@ -0,0 +1,50 @@
+package comicbook
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Format is an output format of the book
+type Format string
+
+const (
+	FormatCBZ Format = "cbz"
+	// fixed-layout EPUB3, one page per image
+	FormatEPUB Format = "epub"
+	// Mobipocket for older Kindles, one page per image
+	FormatMOBI Format = "mobi"
+	// fixed-layout KF8 for stock Kindle reader, one page per image
+	FormatAZW3 Format = "azw3"
+	FormatPDF  Format = "pdf"
+)
+
+// Formats lists supported output formats
+var Formats = []Format{FormatCBZ, FormatEPUB, FormatMOBI, FormatAZW3, FormatPDF}
+
+func ParseFormat(s string) (Format, error) {
+	for _, format := range Formats {
+		if strings.EqualFold(s, string(format)) {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown format %q, expected one of %v", s, Formats)
+}
+
+// Extension returns file extension with dot
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+// countingWriter counts bytes written to underlying writer
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,284 @@
+package comicbook
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+)
+
+// DuplicatePolicy determines which of several books with the same chapter is kept when merging
+type DuplicatePolicy string
+
+const (
+	// keep the book that comes first in the input
+	DuplicatesKeepFirst DuplicatePolicy = "first"
+	// keep the book with the biggest pages data size
+	DuplicatesKeepLargest DuplicatePolicy = "largest"
+	// keep the most recently modified book
+	DuplicatesKeepNewest DuplicatePolicy = "newest"
+)
+
+var DuplicatePolicies = []DuplicatePolicy{DuplicatesKeepFirst, DuplicatesKeepLargest, DuplicatesKeepNewest}
+
+func ParseDuplicatePolicy(s string) (DuplicatePolicy, error) {
+	for _, policy := range DuplicatePolicies {
+		if strings.EqualFold(s, string(policy)) {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("unknown duplicate policy %q, expected one of %v", s, DuplicatePolicies)
+}
+
+type MergeOptions struct {
+	// Policy to resolve duplicate chapters. Default: DuplicatesKeepFirst
+	Duplicates DuplicatePolicy
+}
+
+// Duplicate describes a chapter that was present in several books
+type Duplicate struct {
+	Chapter *ChapterInfo
+	Kept    string
+	Dropped []string
+}
+
+// Gap describes a range of missing chapters, inclusive
+type Gap struct {
+	From, To float64
+}
+
+func (g Gap) String() string {
+	if g.From == g.To {
+		return fmt.Sprintf("%g", g.From)
+	}
+	return fmt.Sprintf("%g-%g", g.From, g.To)
+}
+
+// MergeReport describes decisions made while merging comic books
+type MergeReport struct {
+	// Chapters in resulting order
+	Chapters   []*ChapterInfo
+	Duplicates []Duplicate
+	Gaps       []Gap
+	// Names of books without chapter number, placed at the end in input order
+	Unnumbered []string
+}
+
+// HasIssues reports whether report contains anything worth user attention
+func (r *MergeReport) HasIssues() bool {
+	return len(r.Duplicates) > 0 || len(r.Gaps) > 0 || len(r.Unnumbered) > 0
+}
+
+func (r *MergeReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Merged %d chapters", len(r.Chapters))
+	for _, d := range r.Duplicates {
+		fmt.Fprintf(&sb, "\nDuplicate chapter %g: kept %q, dropped %q", d.Chapter.Number, d.Kept, d.Dropped)
+	}
+	if len(r.Gaps) > 0 {
+		gaps := make([]string, 0, len(r.Gaps))
+		for _, g := range r.Gaps {
+			gaps = append(gaps, g.String())
+		}
+		fmt.Fprintf(&sb, "\nMissing chapters: %s", strings.Join(gaps, ", "))
+	}
+	if len(r.Unnumbered) > 0 {
+		fmt.Fprintf(&sb, "\nBooks without chapter number: %q", r.Unnumbered)
+	}
+	return sb.String()
+}
+
+func (r *MergeReport) LogValue() slog.Value {
+	gaps := make([]string, 0, len(r.Gaps))
+	for _, g := range r.Gaps {
+		gaps = append(gaps, g.String())
+	}
+	duplicates := make([]string, 0, len(r.Duplicates))
+	for _, d := range r.Duplicates {
+		duplicates = append(duplicates, fmt.Sprintf("%g", d.Chapter.Number))
+	}
+
+	return slog.GroupValue(
+		slog.Int("chapters", len(r.Chapters)),
+		slog.Any("duplicates", duplicates),
+		slog.Any("gaps", gaps),
+		slog.Any("unnumbered", r.Unnumbered),
+	)
+}
+
+// MergeComicBooks merges comic books into one, ordering them by volume and chapter number.
+// Books with the same chapter are resolved according to duplicate policy from opts.
+func MergeComicBooks(comicbooks []*ComicBook, name string, opts *MergeOptions) (*ComicBook, *MergeReport) {
+	if opts == nil {
+		opts = &MergeOptions{}
+	}
+
+	report := &MergeReport{}
+	ordered := orderComicBooks(comicbooks, opts.Duplicates, report)
+
+	var pages []*Page
+	pageNumber := uint64(0)
+	for _, comicbook := range ordered {
+		for _, p := range comicbook.Pages {
+			pageNumber++
+			pages = append(pages, &Page{
+				Data:        p.Data,
+				Extension:   p.Extension,
+				Number:      pageNumber,
+				ChapterInfo: p.ChapterInfo,
+				Kind:        p.Kind,
+			})
+		}
+	}
+
+	merged := &ComicBook{Name: name, Pages: pages, Repairs: &RepairReport{}}
+	for _, comicbook := range ordered {
+		merged.Repairs.merge(comicbook.Name, comicbook.Repairs)
+	}
+	// series metadata is the same for all chapters, take it from the first book having it
+	for _, comicbook := range ordered {
+		if len(merged.Series) == 0 {
+			merged.Series = comicbook.Series
+		}
+		if merged.Metadata == (Metadata{}) {
+			merged.Metadata = comicbook.Metadata
+		}
+	}
+
+	return merged, report
+}
+
+// orderComicBooks sorts books by chapter, drops duplicates and fills report
+func orderComicBooks(comicbooks []*ComicBook, policy DuplicatePolicy, report *MergeReport) []*ComicBook {
+	var numbered, unnumbered []*ComicBook
+	for _, cb := range comicbooks {
+		if info := cb.chapterInfo(); info != nil && info.HasNumber() {
+			numbered = append(numbered, cb)
+		} else {
+			unnumbered = append(unnumbered, cb)
+			report.Unnumbered = append(report.Unnumbered, cb.Name)
+		}
+	}
+
+	volumes := orderVolumes(numbered)
+	sort.SliceStable(numbered, func(i, j int) bool {
+		a, b := numbered[i], numbered[j]
+		if volumes[a] != volumes[b] {
+			return volumes[a] < volumes[b]
+		}
+		return a.chapterInfo().Number < b.chapterInfo().Number
+	})
+
+	var ordered []*ComicBook
+	for i := 0; i < len(numbered); {
+		// collect books of the same chapter
+		j := i + 1
+		for j < len(numbered) && volumes[numbered[j]] == volumes[numbered[i]] &&
+			numbered[j].chapterInfo().Number == numbered[i].chapterInfo().Number {
+			j++
+		}
+
+		same := numbered[i:j]
+		kept := pickDuplicate(same, policy)
+		if len(same) > 1 {
+			duplicate := Duplicate{Chapter: kept.chapterInfo(), Kept: kept.Name}
+			for _, cb := range same {
+				if cb != kept {
+					duplicate.Dropped = append(duplicate.Dropped, cb.Name)
+				}
+			}
+			report.Duplicates = append(report.Duplicates, duplicate)
+		}
+
+		if len(ordered) > 0 {
+			prev := ordered[len(ordered)-1].chapterInfo()
+			if gap, ok := chaptersGap(prev, kept.chapterInfo()); ok {
+				report.Gaps = append(report.Gaps, gap)
+			}
+		}
+
+		ordered = append(ordered, kept)
+		i = j
+	}
+	ordered = append(ordered, unnumbered...)
+
+	for _, cb := range ordered {
+		if info := cb.chapterInfo(); info != nil {
+			report.Chapters = append(report.Chapters, info)
+		}
+	}
+
+	return ordered
+}
+
+// orderVolumes returns volumes to order numbered books by.
+// Books without volume number are placed into volume of the closest chapter before them having one,
+// or into the first volume, so they are ordered by chapter number among books with volumes.
+func orderVolumes(numbered []*ComicBook) map[*ComicBook]int {
+	var known []*ChapterInfo
+	for _, cb := range numbered {
+		if info := cb.chapterInfo(); info.HasVolume() {
+			known = append(known, info)
+		}
+	}
+	sort.SliceStable(known, func(i, j int) bool {
+		return known[i].Less(known[j])
+	})
+
+	volumes := make(map[*ComicBook]int, len(numbered))
+	for _, cb := range numbered {
+		info := cb.chapterInfo()
+		switch {
+		case info.HasVolume():
+			volumes[cb] = info.Volume
+		case len(known) > 0:
+			volumes[cb] = known[0].Volume
+			for _, k := range known {
+				if k.Number <= info.Number {
+					volumes[cb] = max(volumes[cb], k.Volume)
+				}
+			}
+		}
+	}
+	return volumes
+}
+
+func pickDuplicate(same []*ComicBook, policy DuplicatePolicy) *ComicBook {
+	kept := same[0]
+	for _, cb := range same[1:] {
+		switch policy {
+		case DuplicatesKeepLargest:
+			if cb.Size() > kept.Size() {
+				kept = cb
+			}
+		case DuplicatesKeepNewest:
+			if cb.ModTime.After(kept.ModTime) {
+				kept = cb
+			}
+		}
+	}
+	return kept
+}
+
+// chaptersGap returns range of whole chapter numbers missing between prev and next chapters
+func chaptersGap(prev, next *ChapterInfo) (Gap, bool) {
+	from := math.Floor(prev.Number) + 1
+	to := math.Ceil(next.Number) - 1
+	if from > to {
+		return Gap{}, false
+	}
+	return Gap{From: from, To: to}, true
+}
+
+// chapterInfo returns chapter info of the book,
+// falling back to chapter info of the first page
+func (cb *ComicBook) chapterInfo() *ChapterInfo {
+	if cb.ChapterInfo != nil {
+		return cb.ChapterInfo
+	}
+	if len(cb.Pages) > 0 {
+		return cb.Pages[0].ChapterInfo
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package comicbook
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// chapterBook returns book of a single chapter, read from file with given name
+func chapterBook(t *testing.T, name string, volume int, number float64, pages int) *ComicBook {
+	t.Helper()
+	cb := testBook(t, name, testChapter{volume: volume, number: number, pages: pages})
+	cb.ChapterInfo = cb.Pages[0].ChapterInfo
+	return cb
+}
+
+// unnumberedBook returns book of a single chapter without number
+func unnumberedBook(t *testing.T, name string, pages int) *ComicBook {
+	t.Helper()
+	cb := testBook(t, name, testChapter{pages: pages})
+	info := ChapterInfoFromName(name)
+	for _, p := range cb.Pages {
+		p.ChapterInfo = info
+	}
+	cb.ChapterInfo = info
+	return cb
+}
+
+func bookNames(books []*ComicBook) []string {
+	names := make([]string, 0, len(books))
+	for _, cb := range books {
+		names = append(names, cb.Name)
+	}
+	return names
+}
+
+func chapterNumbers(infos []*ChapterInfo) []float64 {
+	numbers := make([]float64, 0, len(infos))
+	for _, info := range infos {
+		numbers = append(numbers, info.Number)
+	}
+	return numbers
+}
+
+func TestMergeComicBooksOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		books      func(t *testing.T) []*ComicBook
+		chapters   []float64
+		gaps       []Gap
+		unnumbered []string
+		duplicates []float64
+	}{
+		{
+			name: "chapters",
+			books: func(t *testing.T) []*ComicBook {
+				return []*ComicBook{
+					chapterBook(t, "3", 1, 3, 1),
+					chapterBook(t, "1", 1, 1, 2),
+					chapterBook(t, "2.5", 1, 2.5, 1),
+				}
+			},
+			chapters: []float64{1, 2.5, 3},
+			gaps:     []Gap{{From: 2, To: 2}},
+		},
+		{
+			name: "volumes before chapters",
+			books: func(t *testing.T) []*ComicBook {
+				return []*ComicBook{
+					chapterBook(t, "v2c1", 2, 1, 1),
+					chapterBook(t, "v1c5", 1, 5, 1),
+					chapterBook(t, "v1c4", 1, 4, 1),
+				}
+			},
+			chapters: []float64{4, 5, 1},
+		},
+		{
+			name: "gaps",
+			books: func(t *testing.T) []*ComicBook {
+				return []*ComicBook{
+					chapterBook(t, "1", 1, 1, 1),
+					chapterBook(t, "5", 1, 5, 1),
+					chapterBook(t, "7", 1, 7, 1),
+				}
+			},
+			chapters: []float64{1, 5, 7},
+			gaps:     []Gap{{From: 2, To: 4}, {From: 6, To: 6}},
+		},
+		{
+			name: "unknown volumes",
+			books: func(t *testing.T) []*ComicBook {
+				return []*ComicBook{
+					chapterBook(t, "c4", 0, 4, 1),
+					chapterBook(t, "v2c3", 2, 3, 1),
+					chapterBook(t, "c2", 0, 2, 1),
+					chapterBook(t, "v1c1", 1, 1, 1),
+					chapterBook(t, "c0", 0, 0.5, 1),
+				}
+			},
+			chapters: []float64{0.5, 1, 2, 3, 4},
+		},
+		{
+			name: "no volumes",
+			books: func(t *testing.T) []*ComicBook {
+				return []*ComicBook{
+					chapterBook(t, "c2", 0, 2, 1),
+					chapterBook(t, "c1", 0, 1, 1),
+				}
+			},
+			chapters: []float64{1, 2},
+		},
+		{
+			name: "same chapter with and without volume",
+			books: func(t *testing.T) []*ComicBook {
+				return []*ComicBook{
+					chapterBook(t, "c2", 0, 2, 1),
+					chapterBook(t, "v1c1", 1, 1, 1),
+					chapterBook(t, "v1c2", 1, 2, 1),
+				}
+			},
+			chapters:   []float64{1, 2},
+			duplicates: []float64{2},
+		},
+		{
+			name: "unnumbered last in input order",
+			books: func(t *testing.T) []*ComicBook {
+				return []*ComicBook{
+					unnumberedBook(t, "Extra B", 1),
+					chapterBook(t, "2", 1, 2, 1),
+					unnumberedBook(t, "Extra A", 1),
+					chapterBook(t, "1", 1, 1, 1),
+				}
+			},
+			chapters:   []float64{1, 2, 0, 0},
+			unnumbered: []string{"Extra B", "Extra A"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			books := tt.books(t)
+			pages := 0
+			for _, cb := range books {
+				pages += len(cb.Pages)
+			}
+
+			merged, report := MergeComicBooks(books, "Merged", nil)
+			if got := chapterNumbers(report.Chapters); !reflect.DeepEqual(got, tt.chapters) {
+				t.Errorf("chapters = %v, want %v", got, tt.chapters)
+			}
+			if !reflect.DeepEqual(report.Gaps, tt.gaps) {
+				t.Errorf("gaps = %v, want %v", report.Gaps, tt.gaps)
+			}
+			if !reflect.DeepEqual(report.Unnumbered, tt.unnumbered) {
+				t.Errorf("unnumbered = %q, want %q", report.Unnumbered, tt.unnumbered)
+			}
+			var duplicates []float64
+			for _, d := range report.Duplicates {
+				duplicates = append(duplicates, d.Chapter.Number)
+				pages -= len(d.Dropped)
+			}
+			if !reflect.DeepEqual(duplicates, tt.duplicates) {
+				t.Errorf("duplicates = %v, want %v", duplicates, tt.duplicates)
+			}
+
+			if len(merged.Pages) != pages {
+				t.Fatalf("merged %d pages, want %d", len(merged.Pages), pages)
+			}
+			// pages follow chapters order and are numbered from 1
+			chapters := merged.ChapterPages()
+			if len(chapters) != len(tt.chapters) {
+				t.Fatalf("merged %d chapters, want %d", len(chapters), len(tt.chapters))
+			}
+			for i, chapter := range chapters {
+				if chapter[0].ChapterInfo != report.Chapters[i] {
+					t.Errorf("chapter %d of merged book is %v, want %v", i, chapter[0].ChapterInfo, report.Chapters[i])
+				}
+			}
+			for i, p := range merged.Pages {
+				if p.Number != uint64(i+1) {
+					t.Errorf("page %d has number %d", i, p.Number)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeComicBooksDuplicates(t *testing.T) {
+	now := time.Now()
+	books := func(t *testing.T) []*ComicBook {
+		small := chapterBook(t, "small", 1, 2, 1)
+		small.ModTime = now
+		large := chapterBook(t, "large", 1, 2, 3)
+		large.ModTime = now.Add(-time.Hour)
+		newest := chapterBook(t, "newest", 1, 2, 2)
+		newest.ModTime = now.Add(time.Hour)
+		return []*ComicBook{
+			chapterBook(t, "1", 1, 1, 1),
+			small,
+			large,
+			newest,
+			chapterBook(t, "3", 1, 3, 1),
+		}
+	}
+
+	tests := []struct {
+		policy  DuplicatePolicy
+		kept    string
+		dropped []string
+		pages   int
+	}{
+		{policy: "", kept: "small", dropped: []string{"large", "newest"}, pages: 3},
+		{policy: DuplicatesKeepFirst, kept: "small", dropped: []string{"large", "newest"}, pages: 3},
+		{policy: DuplicatesKeepLargest, kept: "large", dropped: []string{"small", "newest"}, pages: 5},
+		{policy: DuplicatesKeepNewest, kept: "newest", dropped: []string{"small", "large"}, pages: 4},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			merged, report := MergeComicBooks(books(t), "Merged", &MergeOptions{Duplicates: tt.policy})
+
+			if len(report.Duplicates) != 1 {
+				t.Fatalf("got %d duplicates, want 1", len(report.Duplicates))
+			}
+			duplicate := report.Duplicates[0]
+			if duplicate.Chapter.Number != 2 {
+				t.Errorf("duplicate chapter %g, want 2", duplicate.Chapter.Number)
+			}
+			if duplicate.Kept != tt.kept {
+				t.Errorf("kept %q, want %q", duplicate.Kept, tt.kept)
+			}
+			if !reflect.DeepEqual(duplicate.Dropped, tt.dropped) {
+				t.Errorf("dropped %q, want %q", duplicate.Dropped, tt.dropped)
+			}
+			if got := chapterNumbers(report.Chapters); !reflect.DeepEqual(got, []float64{1, 2, 3}) {
+				t.Errorf("chapters = %v, want [1 2 3]", got)
+			}
+			if len(report.Gaps) > 0 {
+				t.Errorf("unexpected gaps %v", report.Gaps)
+			}
+			if len(merged.Pages) != tt.pages {
+				t.Errorf("merged %d pages, want %d", len(merged.Pages), tt.pages)
+			}
+		})
+	}
+}
+
+func TestParseDuplicatePolicy(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    DuplicatePolicy
+		wantErr bool
+	}{
+		{s: "first", want: DuplicatesKeepFirst},
+		{s: "Largest", want: DuplicatesKeepLargest},
+		{s: "NEWEST", want: DuplicatesKeepNewest},
+		{s: "", wantErr: true},
+		{s: "last", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseDuplicatePolicy(tt.s)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseDuplicatePolicy(%q) = %q, %v", tt.s, got, err)
+		}
+	}
+}
@@ -0,0 +1,433 @@
+package comicbook
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/jpeg"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// MOBI is written as Mobipocket 6 book inside of PalmDB container,
+// which is readable by older Kindles too. Fixed layout is only supported
+// by KF8 books (see azw3.go), so pages are images centered on their own screens instead.
+// https://wiki.mobileread.com/wiki/MOBI
+
+const (
+	mobiTextRecordSize  = 4096
+	mobiHeaderLength    = 232
+	mobiRecord0Header   = 16 + mobiHeaderLength
+	mobiEncodingUTF8    = 65001
+	mobiTypeBook        = 2
+	mobiFileVersion     = 6
+	mobiNoIndex         = 0xffffffff
+	mobiExthFlag        = 0x40
+	mobiCompressionNone = 1
+	mobiPalmNameLength  = 32
+	mobiThumbnailHeight = 330
+	// Kindle doesn't show image records larger than this
+	mobiMaxImageBytes = 127 << 10
+	// JPEG quality of images re-encoded to fit record size
+	mobiMaxJpegQuality = 90
+	mobiMinJpegQuality = 40
+)
+
+// EXTH record types
+const (
+	exthAuthor              = 100
+	exthDescription         = 103
+	exthASIN                = 113
+	exthFixedLayout         = 122
+	exthBookType            = 123
+	exthOrientationLock     = 124
+	exthResourceCount       = 125
+	exthOriginalResolution  = 126
+	exthZeroGutter          = 127
+	exthZeroMargin          = 128
+	exthCoverURI            = 129
+	exthRegionMagnification = 132
+	exthCoverOffset         = 201
+	exthThumbOffset         = 202
+	exthHasFakeCover        = 203
+	exthCdeType             = 501
+	exthUpdatedTitle        = 503
+	exthASIN2               = 504
+	exthLanguage            = 524
+	exthWritingMode         = 525
+	exthPageProgression     = 527
+)
+
+// mobiLocales maps ISO language to MOBI locale
+var mobiLocales = map[string]uint32{
+	"zh": 4,
+	"de": 7,
+	"en": 9,
+	"es": 10,
+	"fr": 12,
+	"it": 16,
+	"ja": 17,
+	"ko": 18,
+	"pt": 22,
+	"ru": 25,
+}
+
+var (
+	mobiFLIS = []byte("FLIS\x00\x00\x00\x08\x00\x41\x00\x00\x00\x00\x00\x00\xff\xff\xff\xff\x00\x01\x00\x03\x00\x00\x00\x03\x00\x00\x00\x01\xff\xff\xff\xff")
+	mobiEOF  = []byte("\xe9\x8e\x0d\x0a")
+)
+
+type exthRecord struct {
+	kind uint32
+	data []byte
+}
+
+func exthString(kind uint32, s string) exthRecord {
+	return exthRecord{kind: kind, data: []byte(s)}
+}
+
+func exthUint32(kind uint32, v uint32) exthRecord {
+	return exthRecord{kind: kind, data: binary.BigEndian.AppendUint32(nil, v)}
+}
+
+// writeMOBI writes MOBI with one image per page
+func (cb *ComicBook) writeMOBI(wr io.Writer) error {
+	if len(cb.Pages) == 0 {
+		return fmt.Errorf("book has no pages")
+	}
+
+	var images [][]byte
+	coverIndex := 0
+	for i, page := range cb.Pages {
+		_, format, err := image.DecodeConfig(bytes.NewReader(page.Data))
+		if err != nil {
+			return fmt.Errorf("page %s: reading image: %w", page.Filepath(), err)
+		}
+		if format != "jpeg" && format != "png" && format != "gif" {
+			return fmt.Errorf("page %s: unsupported image format %s", page.Filepath(), format)
+		}
+		if page.Kind == PageKindCover && coverIndex == 0 {
+			coverIndex = i
+		}
+		data, err := mobiImage(page.Data)
+		if err != nil {
+			return fmt.Errorf("page %s: fitting image into record: %w", page.Filepath(), err)
+		}
+		images = append(images, data)
+	}
+
+	thumbnail, err := mobiThumbnail(cb.Pages[coverIndex].Data)
+	if err != nil {
+		return fmt.Errorf("creating cover thumbnail: %w", err)
+	}
+	thumbIndex := len(images)
+	images = append(images, thumbnail)
+
+	// text is ascii only, so it can be split into records at any byte.
+	// Positions in text are padded to fixed width, so guide pointing to
+	// table of contents after pages has the same length whatever it points to.
+	const head = `<html><head><guide><reference type="toc" title="Contents" filepos="%010d"/></guide></head><body>`
+	headLength := len(fmt.Sprintf(head, 0))
+	var body bytes.Buffer
+	pageStarts := make([]int, 0, len(cb.Pages))
+	for i := range cb.Pages {
+		pageStarts = append(pageStarts, headLength+body.Len())
+		fmt.Fprintf(&body, `<div align="center"><img recindex="%05d"/></div><mbp:pagebreak/>`, i+1)
+	}
+
+	toc := cb.TableOfContents()
+	// table of contents must not be empty
+	if len(toc) == 0 {
+		toc = append(toc, TOCEntry{Title: cb.Name})
+	}
+	tocStart := headLength + body.Len()
+	body.WriteString("<div><h2>Contents</h2>")
+	for _, entry := range toc {
+		fmt.Fprintf(&body, `<p><a filepos="%010d">%s</a></p>`, pageStarts[entry.Page], mobiText(entry.Title))
+	}
+	body.WriteString("</div></body></html>")
+
+	var text bytes.Buffer
+	fmt.Fprintf(&text, head, tocStart)
+	text.Write(body.Bytes())
+
+	var textRecords [][]byte
+	for data := text.Bytes(); len(data) > 0; {
+		n := min(len(data), mobiTextRecordSize)
+		textRecords = append(textRecords, data[:n])
+		data = data[n:]
+	}
+
+	firstImage := 1 + len(textRecords)
+	lastContent := firstImage + len(images) - 1
+	flisIndex := lastContent + 1
+	fcisIndex := flisIndex + 1
+
+	uniqueID := mobiUniqueID(cb.Fields().Series, cb.Name)
+	exth := cb.mobiEXTH(uniqueID, coverIndex, thumbIndex)
+	record0 := mobiRecord0(mobiRecord0Params{
+		name:        cb.Name,
+		textLength:  text.Len(),
+		textRecords: len(textRecords),
+		firstImage:  firstImage,
+		lastContent: lastContent,
+		flis:        flisIndex,
+		fcis:        fcisIndex,
+		uniqueID:    uniqueID,
+		locale:      mobiLocales[strings.ToLower(cb.Metadata.LanguageISO)],
+		exth:        exth,
+	})
+
+	records := [][]byte{record0}
+	records = append(records, textRecords...)
+	records = append(records, images...)
+	records = append(records, mobiFLIS, mobiFCIS(text.Len()), mobiEOF)
+
+	modified := cb.ModTime
+	if modified.IsZero() {
+		modified = time.Now()
+	}
+
+	return writePalmDB(wr, mobiPalmName(cb.Name), modified, records)
+}
+
+func (cb *ComicBook) mobiEXTH(uniqueID uint32, coverIndex, thumbIndex int) []exthRecord {
+	asin := fmt.Sprintf("M4K%08X", uniqueID)
+	records := []exthRecord{
+		exthString(exthUpdatedTitle, cb.Name),
+		exthString(exthCdeType, "EBOK"),
+		exthString(exthASIN, asin),
+		exthString(exthASIN2, asin),
+		exthString(exthOrientationLock, "portrait"),
+		exthUint32(exthCoverOffset, uint32(coverIndex)),
+		exthUint32(exthThumbOffset, uint32(thumbIndex)),
+		exthUint32(exthHasFakeCover, 0),
+	}
+	if len(cb.Metadata.Writer) > 0 {
+		records = append(records, exthString(exthAuthor, cb.Metadata.Writer))
+	}
+	if len(cb.Metadata.Summary) > 0 {
+		records = append(records, exthString(exthDescription, cb.Metadata.Summary))
+	}
+	if len(cb.Metadata.LanguageISO) > 0 {
+		records = append(records, exthString(exthLanguage, cb.Metadata.LanguageISO))
+	}
+	return records
+}
+
+type mobiRecord0Params struct {
+	name        string
+	textLength  int
+	textRecords int
+	firstImage  int
+	lastContent int
+	flis, fcis  int
+	uniqueID    uint32
+	locale      uint32
+	exth        []exthRecord
+}
+
+// mobiRecord0 builds PalmDOC header, MOBI header, EXTH header and full name
+func mobiRecord0(p mobiRecord0Params) []byte {
+	exth := mobiEXTHHeader(p.exth)
+	fullNameOffset := mobiRecord0Header + len(exth)
+
+	u32 := func(values ...uint32) []uint32 { return values }
+	var buf bytes.Buffer
+	be := func(v any) { binary.Write(&buf, binary.BigEndian, v) }
+
+	// PalmDOC header
+	be(uint16(mobiCompressionNone))
+	be(uint16(0))
+	be(uint32(p.textLength))
+	be(uint16(p.textRecords))
+	be(uint16(mobiTextRecordSize))
+	be(uint32(0)) // no encryption
+
+	// MOBI header
+	buf.WriteString("MOBI")
+	be(u32(mobiHeaderLength, mobiTypeBook, mobiEncodingUTF8, p.uniqueID, mobiFileVersion))
+	// orthographic, inflection, names, keys and extra indexes
+	for i := 0; i < 10; i++ {
+		be(uint32(mobiNoIndex))
+	}
+	be(u32(uint32(p.firstImage), uint32(fullNameOffset), uint32(len(p.name))))
+	be(u32(p.locale, 0, 0, mobiFileVersion))
+	be(uint32(p.firstImage))
+	be(u32(0, 0, 0, 0)) // no huffman compression
+	be(uint32(mobiExthFlag))
+	buf.Write(make([]byte, 32))
+	be(uint32(mobiNoIndex))
+	be(u32(mobiNoIndex, 0, 0, 0)) // no DRM
+	buf.Write(make([]byte, 8))
+	be(uint16(1))
+	be(uint16(p.lastContent))
+	be(u32(1, uint32(p.fcis), 1, uint32(p.flis), 1))
+	buf.Write(make([]byte, 8))
+	be(u32(mobiNoIndex, 0, mobiNoIndex, mobiNoIndex))
+	be(uint32(0)) // no trailing entries in text records
+	be(uint32(mobiNoIndex))
+
+	buf.Write(exth)
+	buf.Write(mobiFullName(p.name))
+
+	return buf.Bytes()
+}
+
+// mobiEXTHHeader returns EXTH header with given records, padded to 4 bytes
+func mobiEXTHHeader(records []exthRecord) []byte {
+	var data bytes.Buffer
+	for _, record := range records {
+		binary.Write(&data, binary.BigEndian, record.kind)
+		binary.Write(&data, binary.BigEndian, uint32(8+len(record.data)))
+		data.Write(record.data)
+	}
+	length := 12 + data.Len()
+
+	var buf bytes.Buffer
+	buf.WriteString("EXTH")
+	binary.Write(&buf, binary.BigEndian, []uint32{uint32(length), uint32(len(records))})
+	buf.Write(data.Bytes())
+	buf.Write(make([]byte, (4-length%4)%4))
+	return buf.Bytes()
+}
+
+// mobiFullName returns full name of the book ending record 0
+func mobiFullName(name string) []byte {
+	// full name is followed by at least two zero bytes, padded to 4 bytes
+	return append([]byte(name), make([]byte, 2+(4-(len(name)+2)%4)%4)...)
+}
+
+func mobiFCIS(textLength int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("FCIS")
+	binary.Write(&buf, binary.BigEndian, []uint32{0x14, 0x10, 1, 0, uint32(textLength), 0, 0x20, 8})
+	binary.Write(&buf, binary.BigEndian, []uint16{1, 1})
+	binary.Write(&buf, binary.BigEndian, uint32(0))
+	return buf.Bytes()
+}
+
+func mobiThumbnail(data []byte) ([]byte, error) {
+	img, err := imaging.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	img = imaging.Resize(img, 0, mobiThumbnailHeight, imaging.Lanczos)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// mobiImage returns image data fitting into record, re-encoding larger images
+// as JPEG with lower quality and, if it's not enough, downscaling them
+func mobiImage(data []byte) ([]byte, error) {
+	if len(data) <= mobiMaxImageBytes {
+		return data, nil
+	}
+
+	img, err := imaging.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for {
+		for quality := mobiMaxJpegQuality; quality >= mobiMinJpegQuality; quality -= 10 {
+			buf.Reset()
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+				return nil, err
+			}
+			if buf.Len() <= mobiMaxImageBytes {
+				return buf.Bytes(), nil
+			}
+		}
+		size := img.Bounds().Size()
+		if size.X < 2 || size.Y < 2 {
+			return nil, fmt.Errorf("image doesn't fit into %d bytes", mobiMaxImageBytes)
+		}
+		img = imaging.Resize(img, size.X*4/5, size.Y*4/5, imaging.Lanczos)
+	}
+}
+
+// mobiText returns XML-escaped ascii text, so text can be split into records at any byte
+func mobiText(s string) string {
+	var buf strings.Builder
+	for _, r := range escapeXML(s) {
+		if r > 0x7e {
+			fmt.Fprintf(&buf, "&#%d;", r)
+		} else {
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
+
+func mobiUniqueID(series, name string) uint32 {
+	h := fnv.New32a()
+	io.WriteString(h, series+"\x00"+name)
+	return h.Sum32()
+}
+
+// mobiPalmName returns PalmDB database name: ascii, without spaces, at most 31 bytes
+func mobiPalmName(name string) string {
+	palmName := strings.Map(func(r rune) rune {
+		if r == ' ' {
+			return '_'
+		}
+		if r < 0x20 || r > 0x7e {
+			return -1
+		}
+		return r
+	}, name)
+	if len(palmName) > mobiPalmNameLength-1 {
+		palmName = palmName[:mobiPalmNameLength-1]
+	}
+	return palmName
+}
+
+// writePalmDB writes PalmDB container with given records
+func writePalmDB(w io.Writer, name string, modified time.Time, records [][]byte) error {
+	const headerLength = 78
+	const recordInfoLength = 8
+
+	var buf bytes.Buffer
+	be := func(v any) { binary.Write(&buf, binary.BigEndian, v) }
+
+	var palmName [mobiPalmNameLength]byte
+	copy(palmName[:], name)
+	buf.Write(palmName[:])
+	be(uint16(0)) // attributes
+	be(uint16(0)) // version
+	date := uint32(modified.Unix())
+	be([]uint32{date, date, 0, 0, 0, 0})
+	buf.WriteString("BOOKMOBI")
+	be(uint32(2*len(records) - 1)) // unique id seed
+	be(uint32(0))                  // next record list
+	be(uint16(len(records)))
+
+	offset := headerLength + recordInfoLength*len(records) + 2
+	for i, record := range records {
+		be(uint32(offset))
+		// attributes byte and 3 bytes of unique id
+		be(uint32(2 * i))
+		offset += len(record)
+	}
+	be(uint16(0)) // gap to data
+
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	for _, record := range records {
+		if _, err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package comicbook
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+// readPalmDB returns records of PalmDB
+func readPalmDB(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+	if len(data) < 78 || string(data[60:68]) != "BOOKMOBI" {
+		t.Fatal("not a MOBI PalmDB")
+	}
+	count := int(binary.BigEndian.Uint16(data[76:]))
+	offsets := make([]int, 0, count+1)
+	for i := 0; i < count; i++ {
+		offsets = append(offsets, int(binary.BigEndian.Uint32(data[78+8*i:])))
+	}
+	offsets = append(offsets, len(data))
+
+	records := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		if offsets[i] > offsets[i+1] {
+			t.Fatalf("record %d offsets are out of order", i)
+		}
+		records = append(records, data[offsets[i]:offsets[i+1]])
+	}
+	return records
+}
+
+// readEXTH returns EXTH records of MOBI record 0 by type, headerLength is length of PalmDOC and MOBI headers
+func readEXTH(t *testing.T, record0 []byte, headerLength int) map[uint32][]byte {
+	t.Helper()
+	exth := record0[headerLength:]
+	if string(exth[:4]) != "EXTH" {
+		t.Fatal("EXTH header not found")
+	}
+	count := int(binary.BigEndian.Uint32(exth[8:]))
+	records := make(map[uint32][]byte, count)
+	for i, offset := 0, 12; i < count; i++ {
+		kind := binary.BigEndian.Uint32(exth[offset:])
+		length := int(binary.BigEndian.Uint32(exth[offset+4:]))
+		records[kind] = exth[offset+8 : offset+length]
+		offset += length
+	}
+	return records
+}
+
+func TestWriteMOBI(t *testing.T) {
+	cb := formatTestBook(t, FormatMOBI)
+	var buf bytes.Buffer
+	if _, err := cb.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	records := readPalmDB(t, buf.Bytes())
+
+	record0 := records[0]
+	textRecords := int(binary.BigEndian.Uint16(record0[8:]))
+	firstImage := int(binary.BigEndian.Uint32(record0[80:]))
+	if firstImage != 1+textRecords {
+		t.Errorf("first image record is %d, want %d", firstImage, 1+textRecords)
+	}
+
+	// pages, thumbnail, FLIS, FCIS and EOF follow text records
+	if got, want := len(records), firstImage+len(cb.Pages)+4; got != want {
+		t.Fatalf("got %d records, want %d", got, want)
+	}
+	var text []byte
+	for _, record := range records[1:firstImage] {
+		text = append(text, record...)
+	}
+	for i, p := range cb.Pages {
+		if !bytes.Equal(records[firstImage+i], p.Data) {
+			t.Errorf("image record of page %d differs from page data", i)
+		}
+		recindex := fmt.Sprintf(`recindex="%05d"`, i+1)
+		if !bytes.Contains(text, []byte(recindex)) {
+			t.Errorf("text has no image %s of page %d", recindex, i)
+		}
+	}
+	if !bytes.Equal(records[len(records)-1], mobiEOF) {
+		t.Error("last record is not EOF")
+	}
+
+	exth := readEXTH(t, record0, mobiRecord0Header)
+	for kind, want := range map[uint32]string{
+		exthAuthor:       cb.Metadata.Writer,
+		exthDescription:  cb.Metadata.Summary,
+		exthLanguage:     cb.Metadata.LanguageISO,
+		exthUpdatedTitle: cb.Name,
+	} {
+		if got := string(exth[kind]); got != want {
+			t.Errorf("EXTH %d = %q, want %q", kind, got, want)
+		}
+	}
+	// fixed layout and page progression are only supported by KF8 books
+	for _, kind := range []uint32{exthFixedLayout, exthBookType, exthOriginalResolution, exthPageProgression} {
+		if _, ok := exth[kind]; ok {
+			t.Errorf("unexpected fixed layout EXTH %d", kind)
+		}
+	}
+}
+
+var (
+	mobiGuideTOCRe = regexp.MustCompile(`<reference type="toc" title="Contents" filepos="(\d{10})"/>`)
+	mobiTOCEntryRe = regexp.MustCompile(`<a filepos="(\d{10})">([^<]*)</a>`)
+)
+
+func TestWriteMOBITableOfContents(t *testing.T) {
+	cb := formatTestBook(t, FormatMOBI)
+	cb.Pages[0].Kind = PageKindCover
+	var buf bytes.Buffer
+	if _, err := cb.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	records := readPalmDB(t, buf.Bytes())
+	textRecords := int(binary.BigEndian.Uint16(records[0][8:]))
+	var text []byte
+	for _, record := range records[1 : 1+textRecords] {
+		text = append(text, record...)
+	}
+
+	m := mobiGuideTOCRe.FindSubmatch(text)
+	if m == nil {
+		t.Fatal("guide has no table of contents")
+	}
+	tocStart, _ := strconv.Atoi(string(m[1]))
+	toc := text[tocStart:]
+	if !bytes.HasPrefix(toc, []byte("<div><h2>Contents</h2>")) {
+		t.Fatalf("guide points to %.40q, not to table of contents", toc)
+	}
+
+	entries := mobiTOCEntryRe.FindAllSubmatch(toc, -1)
+	want := cb.TableOfContents()
+	if len(entries) != len(want) {
+		t.Fatalf("table of contents has %d entries, want %d", len(entries), len(want))
+	}
+	for i, entry := range entries {
+		if title := string(entry[2]); title != want[i].Title {
+			t.Errorf("entry %d is %q, want %q", i, title, want[i].Title)
+		}
+		pos, _ := strconv.Atoi(string(entry[1]))
+		page := fmt.Sprintf(`<div align="center"><img recindex="%05d"/>`, want[i].Page+1)
+		if !bytes.HasPrefix(text[pos:], []byte(page)) {
+			t.Errorf("entry %d points to %.40q, want page %d", i, text[pos:], want[i].Page)
+		}
+	}
+}
+
+func TestMobiText(t *testing.T) {
+	if got, want := mobiText(`Vol 1 <Ch 2> & "進撃"`), "Vol 1 &lt;Ch 2&gt; &amp; &#34;&#36914;&#25731;&#34;"; got != want {
+		t.Errorf("mobiText = %q, want %q", got, want)
+	}
+}
@@ -2,56 +2,112 @@ package comicbook
 
 import (
 	"archive/zip"
-	"bytes"
 	"fmt"
+	"math"
 	"path/filepath"
 	"strconv"
+	"strings"
 
+	"github.com/abbit/m4k/internal/naming"
 	"github.com/abbit/m4k/internal/util"
 )
 
+type PageKind int
+
+const (
+	// page of the original book
+	PageKindStory PageKind = iota
+	// generated cover page
+	PageKindCover
+	// generated page before chapter start
+	PageKindDivider
+)
+
 type Page struct {
 	Data        []byte
 	Number      uint64
 	Extension   string
 	ChapterInfo *ChapterInfo
+	Kind        PageKind
+	// Page image is in color, set when page is transformed for color screen
+	Color bool
 }
 
 func PageFromFile(zfile *zip.File, chapterInfo *ChapterInfo) (*Page, error) {
-	file, err := zfile.Open()
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
+	return pageFromEntry(archiveEntry{name: zfile.Name, open: zfile.Open}, chapterInfo, 0, nil)
+}
 
-	var buf bytes.Buffer
-	_, err = buf.ReadFrom(file)
+// pageFromEntry reads page from archive entry with index in archive.
+// If repairs is not nil, problems are reported to it instead of failing:
+// page keeps data read so far and is numbered by index if its name has no number.
+func pageFromEntry(entry archiveEntry, chapterInfo *ChapterInfo, index int, repairs *RepairReport) (*Page, error) {
+	data, err := entry.readAll()
 	if err != nil {
-		return nil, err
+		if repairs == nil {
+			return nil, err
+		}
+
+		action := RepairRecovered
+		if len(data) == 0 {
+			action = RepairBroken
+		}
+		repairs.Add(entry.name, action, err)
 	}
 
-	number, err := strconv.ParseUint(util.PathStem(zfile.Name), 10, 64)
+	number, err := strconv.ParseUint(util.PathStem(entry.name), 10, 64)
 	if err != nil {
-		return nil, err
+		if repairs == nil {
+			return nil, err
+		}
+
+		// place after properly numbered pages, keeping archive order
+		number = math.MaxUint32 + uint64(index)
+		repairs.Add(entry.name, RepairRecovered, fmt.Errorf("numbered by position in archive: %w", err))
 	}
 
 	return &Page{
-		Data:        buf.Bytes(),
+		Data:        data,
 		Number:      number,
-		Extension:   filepath.Ext(zfile.Name),
+		Extension:   filepath.Ext(entry.name),
 		ChapterInfo: chapterInfo,
 	}, nil
 }
 
+var defaultPagePath = naming.MustParse("page path", naming.DefaultPagePath)
+
+// Filepath returns page path inside of archive formatted with default template
 func (p *Page) Filepath() string {
-	// TODO: use config to determine how to format file path
-	volumeDirname := fmt.Sprintf("Volume %d", p.ChapterInfo.Volume)
-	chapterDirname := p.ChapterInfo.String()
-	pageFilename := fmt.Sprintf("%06d%s", p.Number, p.Extension)
-
-	return filepath.Join(
-		volumeDirname,
-		chapterDirname,
-		pageFilename,
-	)
+	// default template can't fail
+	path, _ := defaultPagePath.ExecutePath(p.Fields(""))
+	return path
+}
+
+// Fields returns page fields for naming templates
+func (p *Page) Fields(series string) naming.Fields {
+	return naming.Fields{
+		Series:       series,
+		Volume:       p.ChapterInfo.Volume,
+		Chapter:      p.ChapterInfo.Number,
+		ChapterTitle: p.ChapterInfo.Name,
+		ChapterFull:  p.ChapterInfo.String(),
+		Page:         p.Number,
+		Ext:          p.Extension,
+	}
+}
+
+// archiveDirs returns directories of file path in zip archive
+func archiveDirs(name string) []string {
+	dirs := strings.Split(name, "/")
+	return dirs[:len(dirs)-1]
+}
+
+// copyPages returns copies of pages numbered from 1, pages data is shared
+func copyPages(pages []*Page) []*Page {
+	copied := make([]*Page, 0, len(pages))
+	for i, p := range pages {
+		page := *p
+		page.Number = uint64(i + 1)
+		copied = append(copied, &page)
+	}
+	return copied
 }
@@ -0,0 +1,379 @@
+package comicbook
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// PDF pages are sized in points equal to viewport pixels,
+// images are embedded as is where PDF supports their encoding.
+
+// pdfImage is image XObject: dictionary entries and stream data
+type pdfImage struct {
+	width, height int
+	dict          string
+	data          []byte
+}
+
+// pdfWriter writes objects, remembering their offsets for cross-reference table
+type pdfWriter struct {
+	w       *countingWriter
+	offsets map[int]int64
+	err     error
+}
+
+func (pw *pdfWriter) printf(format string, args ...any) {
+	if pw.err != nil {
+		return
+	}
+	_, pw.err = fmt.Fprintf(pw.w, format, args...)
+}
+
+func (pw *pdfWriter) object(id int, dict string) {
+	pw.offsets[id] = pw.w.n
+	pw.printf("%d 0 obj\n%s\nendobj\n", id, dict)
+}
+
+func (pw *pdfWriter) stream(id int, dict string, data []byte) {
+	pw.offsets[id] = pw.w.n
+	pw.printf("%d 0 obj\n<<%s /Length %d>>\nstream\n", id, dict, len(data))
+	if pw.err == nil {
+		_, pw.err = pw.w.Write(data)
+	}
+	pw.printf("\nendstream\nendobj\n")
+}
+
+// writePDF writes PDF with one image per page and chapter outline
+func (cb *ComicBook) writePDF(wr io.Writer) error {
+	if len(cb.Pages) == 0 {
+		return fmt.Errorf("book has no pages")
+	}
+
+	images := make([]*pdfImage, 0, len(cb.Pages))
+	for _, page := range cb.Pages {
+		img, err := newPDFImage(page.Data)
+		if err != nil {
+			return fmt.Errorf("page %s: %w", page.Filepath(), err)
+		}
+		images = append(images, img)
+	}
+	viewport := cb.Viewport
+	if viewport.X <= 0 || viewport.Y <= 0 {
+		viewport = image.Pt(images[0].width, images[0].height)
+	}
+
+	const (
+		catalogID = 1
+		pagesID   = 2
+		infoID    = 3
+		outlineID = 4
+		firstPage = 5
+	)
+	pageID := func(i int) int { return firstPage + 3*i }
+	// size is the next free object id, as all outline items have ids
+	outlineItems, size := cb.pdfOutline(pageID, firstPage+3*len(cb.Pages))
+
+	pw := &pdfWriter{w: &countingWriter{w: wr}, offsets: make(map[int]int64)}
+	pw.printf("%%PDF-1.7\n%%\xe2\xe3\xcf\xd3\n")
+
+	catalog := fmt.Sprintf("<</Type /Catalog /Pages %d 0 R /PageLayout /SinglePage", pagesID)
+	if len(outlineItems) > 0 {
+		catalog += fmt.Sprintf(" /Outlines %d 0 R /PageMode /UseOutlines", outlineID)
+	}
+	if len(cb.Metadata.LanguageISO) > 0 {
+		catalog += " /Lang " + pdfString(cb.Metadata.LanguageISO)
+	}
+	if cb.Metadata.RightToLeft {
+		catalog += " /ViewerPreferences <</Direction /R2L>>"
+	}
+	pw.object(catalogID, catalog+">>")
+
+	kids := make([]string, 0, len(cb.Pages))
+	for i := range cb.Pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageID(i)))
+	}
+	pw.object(pagesID, fmt.Sprintf("<</Type /Pages /Kids [%s] /Count %d>>", strings.Join(kids, " "), len(kids)))
+
+	pw.object(infoID, cb.pdfInfo())
+
+	for i, img := range images {
+		id := pageID(i)
+		pw.object(id, fmt.Sprintf(
+			"<</Type /Page /Parent %d 0 R /MediaBox [0 0 %d %d] /Contents %d 0 R /Resources <</XObject <</Im0 %d 0 R>>>>>>",
+			pagesID, viewport.X, viewport.Y, id+1, id+2,
+		))
+		pw.stream(id+1, "", pdfPageContent(img, viewport))
+		pw.stream(id+2, img.dict, img.data)
+	}
+
+	if len(outlineItems) > 0 {
+		cb.writePDFOutline(pw, outlineID, outlineItems)
+	}
+
+	// cross-reference table, ids of objects which aren't written are free
+	xrefOffset := pw.w.n
+	pw.printf("xref\n0 %d\n0000000000 65535 f \n", size)
+	for id := 1; id < size; id++ {
+		if offset, ok := pw.offsets[id]; ok {
+			pw.printf("%010d 00000 n \n", offset)
+		} else {
+			pw.printf("0000000000 00000 f \n")
+		}
+	}
+	pw.printf("trailer\n<</Size %d /Root %d 0 R /Info %d 0 R>>\nstartxref\n%d\n%%%%EOF\n", size, catalogID, infoID, xrefOffset)
+
+	return pw.err
+}
+
+func (cb *ComicBook) pdfInfo() string {
+	modified := cb.ModTime
+	if modified.IsZero() {
+		modified = time.Now()
+	}
+	date := modified.UTC().Format("(D:20060102150405Z)")
+
+	info := fmt.Sprintf("<</Title %s /Creator (m4k) /CreationDate %s /ModDate %s", pdfString(cb.Name), date, date)
+	if writer := cb.Metadata.Writer; len(writer) > 0 {
+		info += " /Author " + pdfString(writer)
+	}
+	if summary := cb.Metadata.Summary; len(summary) > 0 {
+		info += " /Subject " + pdfString(summary)
+	}
+	if series := cb.Fields().Series; len(series) > 0 {
+		info += " /Keywords " + pdfString(series)
+	}
+	return info + ">>"
+}
+
+// pdfOutlineItem is a bookmark, children are set for volumes
+type pdfOutlineItem struct {
+	id       int
+	title    string
+	page     int
+	children []*pdfOutlineItem
+}
+
+// pdfOutline returns top level bookmarks with object ids assigned from firstID
+// and the next unused id. Chapters are grouped by volume if book has several volumes.
+func (cb *ComicBook) pdfOutline(pageID func(int) int, firstID int) ([]*pdfOutlineItem, int) {
+	toc := cb.TableOfContents()
+	volumes := make(map[int]bool)
+	for _, entry := range toc {
+		volumes[entry.ChapterInfo.Volume] = true
+	}
+
+	nextID := firstID
+	newItem := func(title string, page int) *pdfOutlineItem {
+		item := &pdfOutlineItem{id: nextID, title: title, page: pageID(page)}
+		nextID++
+		return item
+	}
+
+	var items []*pdfOutlineItem
+	var volume *pdfOutlineItem
+	volumeNumber := 0
+	for _, entry := range toc {
+		if len(volumes) <= 1 {
+			items = append(items, newItem(entry.Title, entry.Page))
+			continue
+		}
+
+		if volume == nil || volumeNumber != entry.ChapterInfo.Volume {
+			volumeNumber = entry.ChapterInfo.Volume
+			volume = newItem(fmt.Sprintf("Volume %d", volumeNumber), entry.Page)
+			items = append(items, volume)
+		}
+		volume.children = append(volume.children, newItem(entry.Title, entry.Page))
+	}
+	return items, nextID
+}
+
+// writePDFOutline writes outline dictionary and its items.
+// Items are linked to siblings with /Prev and /Next.
+func (cb *ComicBook) writePDFOutline(pw *pdfWriter, outlineID int, items []*pdfOutlineItem) {
+	count := 0
+	var writeItems func(parent int, items []*pdfOutlineItem)
+	writeItems = func(parent int, items []*pdfOutlineItem) {
+		for i, item := range items {
+			count++
+			dict := fmt.Sprintf("<</Title %s /Parent %d 0 R /Dest [%d 0 R /Fit]", pdfString(item.title), parent, item.page)
+			if i > 0 {
+				dict += fmt.Sprintf(" /Prev %d 0 R", items[i-1].id)
+			}
+			if i < len(items)-1 {
+				dict += fmt.Sprintf(" /Next %d 0 R", items[i+1].id)
+			}
+			if len(item.children) > 0 {
+				last := item.children[len(item.children)-1]
+				dict += fmt.Sprintf(" /First %d 0 R /Last %d 0 R /Count %d", item.children[0].id, last.id, len(item.children))
+			}
+			pw.object(item.id, dict+">>")
+			writeItems(item.id, item.children)
+		}
+	}
+	writeItems(outlineID, items)
+
+	pw.object(outlineID, fmt.Sprintf("<</Type /Outlines /First %d 0 R /Last %d 0 R /Count %d>>", items[0].id, items[len(items)-1].id, count))
+}
+
+// pdfPageContent draws image fitted into center of the page
+func pdfPageContent(img *pdfImage, viewport image.Point) []byte {
+	scale := min(float64(viewport.X)/float64(img.width), float64(viewport.Y)/float64(img.height))
+	w, h := float64(img.width)*scale, float64(img.height)*scale
+	x, y := (float64(viewport.X)-w)/2, (float64(viewport.Y)-h)/2
+	return []byte(fmt.Sprintf("q %.2f 0 0 %.2f %.2f %.2f cm /Im0 Do Q", w, h, x, y))
+}
+
+// pdfString encodes text string as UTF-16 hex string
+func pdfString(s string) string {
+	var buf bytes.Buffer
+	buf.Write([]byte{0xfe, 0xff})
+	for _, c := range utf16.Encode([]rune(s)) {
+		binary.Write(&buf, binary.BigEndian, c)
+	}
+	return "<" + hex.EncodeToString(buf.Bytes()) + ">"
+}
+
+func newPDFImage(data []byte) (*pdfImage, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("reading image: %w", err)
+	}
+
+	switch format {
+	case "jpeg":
+		colorSpace := "/DeviceRGB"
+		switch config.ColorModel {
+		case color.GrayModel:
+			colorSpace = "/DeviceGray"
+		case color.CMYKModel:
+			colorSpace = "/DeviceCMYK"
+		}
+		return &pdfImage{
+			width:  config.Width,
+			height: config.Height,
+			dict:   pdfImageDict(config.Width, config.Height, colorSpace, 8) + " /Filter /DCTDecode",
+			data:   data,
+		}, nil
+	case "png":
+		if img, ok := pngPDFImage(data, config); ok {
+			return img, nil
+		}
+	}
+
+	return decodedPDFImage(data)
+}
+
+func pdfImageDict(width, height int, colorSpace string, bitsPerComponent int) string {
+	return fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent %d",
+		width, height, colorSpace, bitsPerComponent)
+}
+
+// pngPDFImage embeds PNG data as is, PDF supports PNG predictors in FlateDecode.
+// Not possible for images with alpha channel and interlaced images.
+func pngPDFImage(data []byte, config image.Config) (*pdfImage, bool) {
+	const signatureLen = 8
+	if len(data) < signatureLen {
+		return nil, false
+	}
+
+	var idat, palette []byte
+	var bitDepth, colorType, interlace byte
+	for rest := data[signatureLen:]; len(rest) >= 12; {
+		length := int(binary.BigEndian.Uint32(rest))
+		if length < 0 || len(rest) < 12+length {
+			return nil, false
+		}
+		kind, chunk := string(rest[4:8]), rest[8:8+length]
+		if crc32.ChecksumIEEE(rest[4:8+length]) != binary.BigEndian.Uint32(rest[8+length:]) {
+			return nil, false
+		}
+		switch kind {
+		case "IHDR":
+			if length < 13 {
+				return nil, false
+			}
+			bitDepth, colorType, interlace = chunk[8], chunk[9], chunk[12]
+		case "PLTE":
+			palette = chunk
+		case "IDAT":
+			idat = append(idat, chunk...)
+		}
+		rest = rest[12+length:]
+	}
+
+	var colorSpace string
+	var colors int
+	switch colorType {
+	case 0:
+		colorSpace, colors = "/DeviceGray", 1
+	case 2:
+		colorSpace, colors = "/DeviceRGB", 3
+	case 3:
+		if len(palette) == 0 {
+			return nil, false
+		}
+		colorSpace = fmt.Sprintf("[/Indexed /DeviceRGB %d <%s>]", len(palette)/3-1, hex.EncodeToString(palette))
+		colors = 1
+	default:
+		// alpha channel
+		return nil, false
+	}
+	if interlace != 0 || len(idat) == 0 {
+		return nil, false
+	}
+
+	return &pdfImage{
+		width:  config.Width,
+		height: config.Height,
+		dict: pdfImageDict(config.Width, config.Height, colorSpace, int(bitDepth)) + fmt.Sprintf(
+			" /Filter /FlateDecode /DecodeParms <</Predictor 15 /Colors %d /BitsPerComponent %d /Columns %d>>",
+			colors, bitDepth, config.Width,
+		),
+		data: idat,
+	}, true
+}
+
+// decodedPDFImage decodes image and embeds its pixels, transparency is flattened on white
+func decodedPDFImage(data []byte) (*pdfImage, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decoding image: %w", err)
+	}
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Over)
+
+	rgb := make([]byte, 0, len(rgba.Pix)/4*3)
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		rgb = append(rgb, rgba.Pix[i:i+3]...)
+	}
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(rgb); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return &pdfImage{
+		width:  bounds.Dx(),
+		height: bounds.Dy(),
+		dict:   pdfImageDict(bounds.Dx(), bounds.Dy(), "/DeviceRGB", 8) + " /Filter /FlateDecode",
+		data:   buf.Bytes(),
+	}, nil
+}
@@ -0,0 +1,150 @@
+package comicbook
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+var (
+	pdfObjectRe    = regexp.MustCompile(`(?m)^(\d+) 0 obj$`)
+	pdfStartXrefRe = regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`)
+	pdfXrefRe      = regexp.MustCompile(`^xref\n0 (\d+)\n`)
+	pdfSizeRe      = regexp.MustCompile(`/Size (\d+) `)
+	pdfKidsRe      = regexp.MustCompile(`/Kids \[([^\]]*)\]`)
+	pdfImageRe     = regexp.MustCompile(`/Im0 (\d+) 0 R`)
+	pdfLengthRe    = regexp.MustCompile(`/Length (\d+)>>\nstream\n`)
+	pdfRefRe       = regexp.MustCompile(`(\d+) 0 R`)
+)
+
+// parsePDFXref returns offsets of objects in use by cross-reference table and its size
+func parsePDFXref(t *testing.T, data []byte) (map[int]int, int) {
+	t.Helper()
+	m := pdfStartXrefRe.FindSubmatch(data)
+	if m == nil {
+		t.Fatal("startxref not found")
+	}
+	xrefOffset, _ := strconv.Atoi(string(m[1]))
+	xref := data[xrefOffset:]
+	m = pdfXrefRe.FindSubmatch(xref)
+	if m == nil {
+		t.Fatalf("xref not found at offset %d", xrefOffset)
+	}
+	size, _ := strconv.Atoi(string(m[1]))
+	entries := xref[len(m[0]):]
+
+	offsets := make(map[int]int)
+	for id := 0; id < size; id++ {
+		entry := string(entries[20*id : 20*id+20])
+		var offset, generation int
+		var kind string
+		if _, err := fmt.Sscanf(entry, "%010d %05d %1s", &offset, &generation, &kind); err != nil {
+			t.Fatalf("entry %d %q: %v", id, entry, err)
+		}
+		if kind == "n" {
+			offsets[id] = offset
+		}
+	}
+	return offsets, size
+}
+
+func TestWritePDFXref(t *testing.T) {
+	tests := []struct {
+		name     string
+		chapters []testChapter
+	}{
+		{"one chapter", []testChapter{{volume: 1, number: 1, pages: 2}}},
+		{"one volume", []testChapter{{volume: 1, number: 1, pages: 2}, {volume: 1, number: 2, pages: 1}}},
+		{"two volumes", []testChapter{
+			{volume: 1, number: 1, pages: 2},
+			{volume: 1, number: 2, pages: 1},
+			{volume: 2, number: 3, pages: 2},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := testBook(t, "Test", tt.chapters...)
+			var buf bytes.Buffer
+			if err := cb.writePDF(&buf); err != nil {
+				t.Fatal(err)
+			}
+			data := buf.Bytes()
+
+			offsets, size := parsePDFXref(t, data)
+			if m := pdfSizeRe.FindSubmatch(data); m == nil || string(m[1]) != strconv.Itoa(size) {
+				t.Errorf("trailer /Size doesn't match xref size %d", size)
+			}
+
+			// every written object is in xref at its offset
+			written := make(map[int]bool)
+			for _, m := range pdfObjectRe.FindAllSubmatchIndex(data, -1) {
+				id, _ := strconv.Atoi(string(data[m[2]:m[3]]))
+				written[id] = true
+				if id >= size {
+					t.Errorf("object %d is out of xref size %d", id, size)
+				} else if offsets[id] != m[0] {
+					t.Errorf("object %d is at %d, xref has %d", id, m[0], offsets[id])
+				}
+			}
+			// and every object in use is written
+			for id := range offsets {
+				if !written[id] {
+					t.Errorf("object %d is in use in xref, but not written", id)
+				}
+			}
+		})
+	}
+}
+
+func TestWritePDF(t *testing.T) {
+	cb := formatTestBook(t, FormatPDF)
+	var buf bytes.Buffer
+	if _, err := cb.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	offsets, _ := parsePDFXref(t, data)
+
+	m := pdfKidsRe.FindSubmatch(data)
+	if m == nil {
+		t.Fatal("page tree not found")
+	}
+	kids := pdfRefRe.FindAllSubmatch(m[1], -1)
+	if len(kids) != len(cb.Pages) {
+		t.Fatalf("page tree has %d pages, want %d", len(kids), len(cb.Pages))
+	}
+
+	// every page shows JPEG data of the page as is
+	for i, kid := range kids {
+		pageID, _ := strconv.Atoi(string(kid[1]))
+		page := data[offsets[pageID]:]
+		m := pdfImageRe.FindSubmatch(page)
+		if m == nil {
+			t.Fatalf("page %d has no image", i)
+		}
+		imageID, _ := strconv.Atoi(string(m[1]))
+		image := data[offsets[imageID]:]
+		if !bytes.Contains(image[:bytes.Index(image, []byte("stream\n"))], []byte("/DCTDecode")) {
+			t.Errorf("page %d image is not JPEG", i)
+		}
+		loc := pdfLengthRe.FindSubmatchIndex(image)
+		if loc == nil {
+			t.Fatalf("page %d image stream not found", i)
+		}
+		length, _ := strconv.Atoi(string(image[loc[2]:loc[3]]))
+		if stream := image[loc[1] : loc[1]+length]; !bytes.Equal(stream, cb.Pages[i].Data) {
+			t.Errorf("page %d image differs from page data", i)
+		}
+	}
+
+	for _, entry := range cb.TableOfContents() {
+		if !bytes.Contains(data, []byte(pdfString(entry.Title))) {
+			t.Errorf("outline has no entry %q", entry.Title)
+		}
+	}
+	if !bytes.Contains(data, []byte("/Direction /R2L")) {
+		t.Error("right to left direction not set")
+	}
+}
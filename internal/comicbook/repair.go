@@ -0,0 +1,89 @@
+package comicbook
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+type RepairAction string
+
+const (
+	// file was left out
+	RepairSkipped RepairAction = "skipped"
+	// part of the data was recovered
+	RepairRecovered RepairAction = "recovered"
+	// page was replaced with placeholder
+	RepairReplaced RepairAction = "replaced"
+	// problem was found, but nothing was done
+	RepairBroken RepairAction = "broken"
+)
+
+type RepairIssue struct {
+	File   string
+	Action RepairAction
+	Err    error
+}
+
+func (i RepairIssue) String() string {
+	return fmt.Sprintf("%s %s: %v", i.File, i.Action, i.Err)
+}
+
+// RepairReport collects problems found in a book, safe for concurrent use
+type RepairReport struct {
+	mu     sync.Mutex
+	issues []RepairIssue
+}
+
+// Add adds issue to report, does nothing on nil report
+func (r *RepairReport) Add(file string, action RepairAction, err error) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.issues = append(r.issues, RepairIssue{File: file, Action: action, Err: err})
+}
+
+func (r *RepairReport) Issues() []RepairIssue {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]RepairIssue(nil), r.issues...)
+}
+
+func (r *RepairReport) HasIssues() bool {
+	return len(r.Issues()) > 0
+}
+
+// merge adds issues from other report, prefixing file names with book name
+func (r *RepairReport) merge(book string, other *RepairReport) {
+	for _, issue := range other.Issues() {
+		r.Add(book+": "+issue.File, issue.Action, issue.Err)
+	}
+}
+
+func (r *RepairReport) String() string {
+	issues := r.Issues()
+	lines := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		lines = append(lines, issue.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (r *RepairReport) LogValue() slog.Value {
+	issues := r.Issues()
+	attrs := make([]slog.Attr, 0, len(issues))
+	for _, issue := range issues {
+		attrs = append(attrs, slog.String(issue.File, fmt.Sprintf("%s: %v", issue.Action, issue.Err)))
+	}
+	return slog.GroupValue(attrs...)
+}
@@ -0,0 +1,175 @@
+package comicbook
+
+import (
+	"fmt"
+
+	"github.com/abbit/m4k/internal/naming"
+)
+
+// ErrNothingToSplit is returned when combined book has only one chapter or volume
+var ErrNothingToSplit = fmt.Errorf("nothing to split")
+
+// SplitOptions determines how combined comic book is split into several volumes.
+// Chapters are never split between volumes, so volume can exceed the limits
+// if a single chapter does.
+type SplitOptions struct {
+	// Max number of pages in one volume
+	MaxPages int
+	// Max size of pages data in one volume, in bytes
+	MaxBytes int64
+	// Number of chapters in one volume
+	Chapters int
+	// Split on source volume boundaries
+	ByVolume bool
+}
+
+func (o *SplitOptions) Enabled() bool {
+	return o != nil && (o.MaxPages > 0 || o.MaxBytes > 0 || o.Chapters > 0 || o.ByVolume)
+}
+
+// SplitComicBook splits comic book into volumes according to opts.
+// Should be called after transformation when splitting by size,
+// as sizes of pages are not final before it.
+func SplitComicBook(cb *ComicBook, opts *SplitOptions) []*ComicBook {
+	if !opts.Enabled() {
+		return []*ComicBook{cb}
+	}
+
+	var (
+		parts   [][][]*Page
+		current [][]*Page
+		pages   int
+		size    int64
+	)
+	for _, chapter := range cb.ChapterPages() {
+		chapterSize := pagesSize(chapter)
+		if len(current) > 0 && opts.exceeded(current, chapter, pages+len(chapter), size+chapterSize) {
+			parts = append(parts, current)
+			current, pages, size = nil, 0, 0
+		}
+		current = append(current, chapter)
+		pages += len(chapter)
+		size += chapterSize
+	}
+	if len(current) > 0 {
+		parts = append(parts, current)
+	}
+
+	if len(parts) <= 1 {
+		return []*ComicBook{cb}
+	}
+
+	volumes := make([]*ComicBook, 0, len(parts))
+	for i, chapters := range parts {
+		volumeNumber := i + 1
+		if opts.ByVolume {
+			volumeNumber = chapters[0][0].ChapterInfo.Volume
+		}
+
+		var pages []*Page
+		for _, chapter := range chapters {
+			pages = append(pages, chapter...)
+		}
+
+		volume := cb.derive("", pages)
+		volume.Name = cb.volumeName(volume, volumeNumber)
+		volumes = append(volumes, volume)
+	}
+
+	return volumes
+}
+
+// exceeded reports whether next chapter should start a new volume
+func (o *SplitOptions) exceeded(current [][]*Page, next []*Page, pages int, size int64) bool {
+	if o.ByVolume && current[0][0].ChapterInfo.Volume != next[0].ChapterInfo.Volume {
+		return true
+	}
+	if o.Chapters > 0 && len(current) >= o.Chapters {
+		return true
+	}
+	if o.MaxPages > 0 && pages > o.MaxPages {
+		return true
+	}
+	if o.MaxBytes > 0 && size > o.MaxBytes {
+		return true
+	}
+	return false
+}
+
+var defaultVolumeName = naming.MustParse("volume name", naming.DefaultVolumeName)
+
+// volumeName formats name of volume with given number the book is split into
+func (cb *ComicBook) volumeName(volume *ComicBook, number int) string {
+	fields := volume.Fields()
+	if len(cb.Series) == 0 {
+		fields.Series = cb.Name
+	}
+	fields.Part = number
+
+	tmpl := cb.VolumeName
+	if tmpl == nil {
+		tmpl = defaultVolumeName
+	}
+	name, err := tmpl.Execute(fields)
+	if err != nil {
+		// fallback to default template, which can't fail
+		name, _ = defaultVolumeName.Execute(fields)
+	}
+	return name
+}
+
+// derive returns book with copies of pages and settings of cb
+func (cb *ComicBook) derive(name string, pages []*Page) *ComicBook {
+	return &ComicBook{
+		Name:       name,
+		Pages:      copyPages(pages),
+		Series:     cb.Series,
+		PagePath:   cb.PagePath,
+		VolumeName: cb.VolumeName,
+		Metadata:   cb.Metadata,
+		ModTime:    cb.ModTime,
+		Format:     cb.Format,
+		Viewport:   cb.Viewport,
+	}
+}
+
+func pagesSize(pages []*Page) int64 {
+	var size int64
+	for _, page := range pages {
+		size += int64(len(page.Data))
+	}
+	return size
+}
+
+// SplitIntoChapters splits combined comic book back into per-chapter books,
+// named the same way `mangal` names downloaded chapters, so they can be merged again
+func SplitIntoChapters(cb *ComicBook) ([]*ComicBook, error) {
+	chapters := cb.ChapterPages()
+	if len(chapters) <= 1 {
+		return nil, ErrNothingToSplit
+	}
+	books := make([]*ComicBook, 0, len(chapters))
+	for _, chapter := range chapters {
+		info := chapter[0].ChapterInfo
+
+		name := info.Name
+		if info.HasNumber() {
+			name = fmt.Sprintf("[%06.1f] %s", info.Number, info.Name)
+		}
+
+		book := cb.derive(name, chapter)
+		book.ChapterInfo = info
+		books = append(books, book)
+	}
+
+	return books, nil
+}
+
+// SplitIntoVolumes splits combined comic book back into books on volume boundaries
+func SplitIntoVolumes(cb *ComicBook) ([]*ComicBook, error) {
+	books := SplitComicBook(cb, &SplitOptions{ByVolume: true})
+	if len(books) <= 1 {
+		return nil, ErrNothingToSplit
+	}
+	return books, nil
+}
@@ -0,0 +1,211 @@
+package comicbook
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/abbit/m4k/internal/naming"
+)
+
+func TestSplitComicBook(t *testing.T) {
+	chapters := []testChapter{
+		{volume: 1, number: 1, pages: 2},
+		{volume: 1, number: 2, pages: 3},
+		{volume: 2, number: 3, pages: 1},
+		{volume: 2, number: 4, pages: 2},
+	}
+	tests := []struct {
+		name       string
+		opts       *SplitOptions
+		volumeName *naming.Template
+		names      []string
+		pages      []int
+	}{
+		{
+			name:  "disabled",
+			opts:  &SplitOptions{},
+			names: []string{"Test"},
+			pages: []int{8},
+		},
+		{
+			name:  "max pages",
+			opts:  &SplitOptions{MaxPages: 5},
+			names: []string{"Test Vol 01 (Ch 1-2)", "Test Vol 02 (Ch 3-4)"},
+			pages: []int{5, 3},
+		},
+		{
+			// chapters are not split between volumes even if they exceed the limit
+			name:  "chapter exceeding max pages",
+			opts:  &SplitOptions{MaxPages: 2},
+			names: []string{"Test Vol 01 (Ch 1)", "Test Vol 02 (Ch 2)", "Test Vol 03 (Ch 3)", "Test Vol 04 (Ch 4)"},
+			pages: []int{2, 3, 1, 2},
+		},
+		{
+			name:  "chapters",
+			opts:  &SplitOptions{Chapters: 3},
+			names: []string{"Test Vol 01 (Ch 1-3)", "Test Vol 02 (Ch 4)"},
+			pages: []int{6, 2},
+		},
+		{
+			name:  "by volume",
+			opts:  &SplitOptions{ByVolume: true},
+			names: []string{"Test Vol 01 (Ch 1-2)", "Test Vol 02 (Ch 3-4)"},
+			pages: []int{5, 3},
+		},
+		{
+			name:       "volume name template",
+			opts:       &SplitOptions{ByVolume: true},
+			volumeName: naming.MustParse("volume", `{{.Series}} {{.Part}} - {{.Range}}`),
+			names:      []string{"Series 1 - Chapters 1-2", "Series 2 - Chapters 3-4"},
+			pages:      []int{5, 3},
+		},
+		{
+			name:  "fits in one volume",
+			opts:  &SplitOptions{MaxPages: 100},
+			names: []string{"Test"},
+			pages: []int{8},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := testBook(t, "Test", chapters...)
+			if tt.volumeName != nil {
+				cb.Series = "Series"
+				cb.VolumeName = tt.volumeName
+			}
+			volumes := SplitComicBook(cb, tt.opts)
+			if got := bookNames(volumes); !reflect.DeepEqual(got, tt.names) {
+				t.Errorf("names = %q, want %q", got, tt.names)
+			}
+			pages := make([]int, 0, len(volumes))
+			for _, volume := range volumes {
+				pages = append(pages, len(volume.Pages))
+			}
+			if !reflect.DeepEqual(pages, tt.pages) {
+				t.Errorf("pages = %v, want %v", pages, tt.pages)
+			}
+		})
+	}
+}
+
+func TestSplitComicBookMaxBytes(t *testing.T) {
+	cb := testBook(t, "Test",
+		testChapter{volume: 1, number: 1, pages: 2},
+		testChapter{volume: 1, number: 2, pages: 2},
+		testChapter{volume: 1, number: 3, pages: 2},
+	)
+	chapterSize := pagesSize(cb.ChapterPages()[0])
+
+	volumes := SplitComicBook(cb, &SplitOptions{MaxBytes: chapterSize + 1})
+	if len(volumes) != 3 {
+		t.Fatalf("got %d volumes, want 3", len(volumes))
+	}
+	for _, volume := range volumes {
+		if volume.Size() > chapterSize+1 {
+			t.Errorf("volume %q has %d bytes, limit is %d", volume.Name, volume.Size(), chapterSize+1)
+		}
+	}
+}
+
+func TestSplitComicBookKeepsPages(t *testing.T) {
+	cb := testBook(t, "Test",
+		testChapter{volume: 1, number: 1, pages: 2},
+		testChapter{volume: 1, number: 2, pages: 2},
+	)
+	cb.Pages[0].Kind = PageKindCover
+	cb.Pages[1].Color = true
+	cb.Pages[3].Color = true
+
+	volumes := SplitComicBook(cb, &SplitOptions{Chapters: 1})
+	if len(volumes) != 2 {
+		t.Fatalf("got %d volumes, want 2", len(volumes))
+	}
+	var pages []*Page
+	for _, volume := range volumes {
+		for i, p := range volume.Pages {
+			if p.Number != uint64(i+1) {
+				t.Errorf("page %d of %q has number %d", i, volume.Name, p.Number)
+			}
+		}
+		pages = append(pages, volume.Pages...)
+	}
+	for i, p := range pages {
+		want := cb.Pages[i]
+		if p == want {
+			t.Errorf("page %d is not copied", i)
+		}
+		if p.Kind != want.Kind || p.Color != want.Color || p.ChapterInfo != want.ChapterInfo || p.Extension != want.Extension {
+			t.Errorf("page %d = %+v, want %+v", i, p, want)
+		}
+	}
+}
+
+func TestSplitIntoChapters(t *testing.T) {
+	cb := testBook(t, "Test",
+		testChapter{volume: 1, number: 1, pages: 2},
+		testChapter{volume: 1, number: 1.5, pages: 1},
+		testChapter{volume: 2, number: 2, pages: 2},
+	)
+	cb.Metadata.Writer = "Writer"
+
+	books, err := SplitIntoChapters(cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"[0001.0] Test", "[0001.5] Test", "[0002.0] Test"}
+	if got := bookNames(books); !reflect.DeepEqual(got, want) {
+		t.Errorf("names = %q, want %q", got, want)
+	}
+	for i, book := range books {
+		if book.ChapterInfo == nil || book.ChapterInfo != cb.ChapterPages()[i][0].ChapterInfo {
+			t.Errorf("book %q has chapter info %v", book.Name, book.ChapterInfo)
+		}
+		if book.Metadata != cb.Metadata {
+			t.Errorf("book %q has metadata %+v", book.Name, book.Metadata)
+		}
+	}
+
+	// split chapters are merged back into the same book
+	merged, report := MergeComicBooks(books, "Test", nil)
+	if report.HasIssues() {
+		t.Errorf("merge report has issues: %s", report)
+	}
+	if len(merged.Pages) != len(cb.Pages) {
+		t.Fatalf("merged %d pages, want %d", len(merged.Pages), len(cb.Pages))
+	}
+	for i, p := range merged.Pages {
+		if p.ChapterInfo != cb.Pages[i].ChapterInfo {
+			t.Errorf("page %d has chapter %v, want %v", i, p.ChapterInfo, cb.Pages[i].ChapterInfo)
+		}
+	}
+
+	single := testBook(t, "Single", testChapter{volume: 1, number: 1, pages: 3})
+	if _, err := SplitIntoChapters(single); !errors.Is(err, ErrNothingToSplit) {
+		t.Errorf("splitting single chapter: got error %v, want %v", err, ErrNothingToSplit)
+	}
+}
+
+func TestSplitIntoVolumes(t *testing.T) {
+	cb := testBook(t, "Test",
+		testChapter{volume: 1, number: 1, pages: 2},
+		testChapter{volume: 1, number: 2, pages: 1},
+		testChapter{volume: 2, number: 3, pages: 2},
+	)
+	books, err := SplitIntoVolumes(cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Test Vol 01 (Ch 1-2)", "Test Vol 02 (Ch 3)"}
+	if got := bookNames(books); !reflect.DeepEqual(got, want) {
+		t.Errorf("names = %q, want %q", got, want)
+	}
+
+	single := testBook(t, "Single",
+		testChapter{volume: 1, number: 1, pages: 2},
+		testChapter{volume: 1, number: 2, pages: 2},
+	)
+	if _, err := SplitIntoVolumes(single); !errors.Is(err, ErrNothingToSplit) {
+		t.Errorf("splitting single volume: got error %v, want %v", err, ErrNothingToSplit)
+	}
+}
@@ -0,0 +1,124 @@
+package comicbook
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/abbit/m4k/internal/render"
+	"github.com/disintegration/imaging"
+)
+
+// SynthOptions determines how generated pages are rendered
+type SynthOptions struct {
+	// Page size, usually device resolution
+	Width, Height int
+	// Title for cover page. Default: book name
+	Title string
+	// Background image for cover page, e.g. cover from provider. Default: first story page
+	CoverImage []byte
+}
+
+// AddCoverPage inserts generated cover page with title and chapters range before the first page
+func AddCoverPage(cb *ComicBook, opts *SynthOptions) error {
+	if len(cb.Pages) == 0 {
+		return nil
+	}
+
+	background, err := coverBackground(cb, opts)
+	if err != nil {
+		return fmt.Errorf("decoding cover background: %w", err)
+	}
+
+	title := opts.Title
+	if len(title) == 0 {
+		title = cb.Name
+	}
+
+	img, err := render.Cover(background, title, cb.Fields().Range, opts.Width, opts.Height)
+	if err != nil {
+		return fmt.Errorf("rendering cover: %w", err)
+	}
+	data, err := render.EncodePNG(img)
+	if err != nil {
+		return fmt.Errorf("encoding cover: %w", err)
+	}
+
+	cover := &Page{
+		Data:        data,
+		Extension:   ".png",
+		ChapterInfo: cb.Pages[0].ChapterInfo,
+		Kind:        PageKindCover,
+	}
+	cb.Pages = append([]*Page{cover}, cb.Pages...)
+	cb.Renumber()
+
+	return nil
+}
+
+// AddDividerPages inserts generated page with chapter number and name before each chapter
+func AddDividerPages(cb *ComicBook, opts *SynthOptions) error {
+	var pages []*Page
+	for _, chapter := range cb.ChapterPages() {
+		start := 0
+		// keep cover before divider of the first chapter
+		for start < len(chapter) && chapter[start].Kind == PageKindCover {
+			start++
+		}
+		pages = append(pages, chapter[:start]...)
+
+		info := chapter[0].ChapterInfo
+		title, subtitle := dividerText(info)
+		img, err := render.Divider(title, subtitle, opts.Width, opts.Height)
+		if err != nil {
+			return fmt.Errorf("rendering divider for %s: %w", info, err)
+		}
+		data, err := render.EncodePNG(img)
+		if err != nil {
+			return fmt.Errorf("encoding divider for %s: %w", info, err)
+		}
+
+		pages = append(pages, &Page{
+			Data:        data,
+			Extension:   ".png",
+			ChapterInfo: info,
+			Kind:        PageKindDivider,
+		})
+		pages = append(pages, chapter[start:]...)
+	}
+
+	cb.Pages = pages
+	cb.Renumber()
+
+	return nil
+}
+
+func coverBackground(cb *ComicBook, opts *SynthOptions) (image.Image, error) {
+	data := opts.CoverImage
+	for _, page := range cb.Pages {
+		if len(data) > 0 {
+			break
+		}
+		// do not use generated pages as background
+		if page.Kind == PageKindStory {
+			data = page.Data
+		}
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+}
+
+func dividerText(info *ChapterInfo) (title, subtitle string) {
+	if !info.HasNumber() {
+		return info.Name, ""
+	}
+
+	title = fmt.Sprintf("Chapter %g", info.Number)
+	if !strings.EqualFold(strings.TrimSpace(info.Name), title) {
+		subtitle = info.Name
+	}
+	return title, subtitle
+}
@@ -0,0 +1,39 @@
+package comicbook
+
+// TOCEntry is a chapter start in table of contents
+type TOCEntry struct {
+	Title string
+	// Index of chapter's first page in ComicBook.Pages
+	Page        int
+	ChapterInfo *ChapterInfo
+}
+
+// TableOfContents returns chapter starts of the book.
+// Chapters are tracked by pages' chapter info, which merge keeps from source books,
+// so TOC stays valid after adding, removing or renumbering pages.
+func (cb *ComicBook) TableOfContents() []TOCEntry {
+	var toc []TOCEntry
+	index := 0
+	for _, chapter := range cb.ChapterPages() {
+		start := index
+		// cover is not a part of the chapter
+		for _, page := range chapter {
+			if page.Kind != PageKindCover {
+				break
+			}
+			start++
+		}
+		index += len(chapter)
+
+		if start == index {
+			continue
+		}
+		info := chapter[0].ChapterInfo
+		toc = append(toc, TOCEntry{
+			Title:       info.String(),
+			Page:        start,
+			ChapterInfo: info,
+		})
+	}
+	return toc
+}
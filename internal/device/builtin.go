@@ -0,0 +1,39 @@
+package device
+
+import (
+	"github.com/abbit/m4k/internal/comicbook"
+	"github.com/abbit/m4k/internal/transform"
+)
+
+// Kaleido panels show colors pale and dark
+var kaleidoColor = transform.ColorOptions{Saturation: 30, Gamma: 1.1}
+
+// Kindles are expected to run KOReader, which reads CBZ best.
+// Kobo's reading app handles fixed-layout EPUB better than CBZ, but stretches pages to screen.
+// Tone mapping changes how scans look, so it's left to users to turn on.
+var builtinProfiles = []Profile{
+	// older Kindles have little memory and turn large pages slowly
+	{Name: "kindle-basic", Title: "Kindle (10th generation and older)", Width: 600, Height: 800,
+		Quirks:    Quirks{MaxPageBytes: 256 << 10},
+		Transform: TransformDefaults{Spread: transform.SpreadSplit}},
+	{Name: "kindle-11", Title: "Kindle (11th generation)", Width: 1072, Height: 1448},
+	{Name: "kindle-pw3", Title: "Kindle Paperwhite 3", Width: 1072, Height: 1448},
+	{Name: "kindle-pw4", Title: "Kindle Paperwhite 4", Width: 1072, Height: 1448},
+	{Name: "kindle-pw5", Title: "Kindle Paperwhite 5", Width: 1236, Height: 1648},
+	{Name: "kindle-oasis", Title: "Kindle Oasis", Width: 1264, Height: 1680},
+	{Name: "kindle-scribe", Title: "Kindle Scribe", Width: 1860, Height: 2480},
+	{Name: "kindle-colorsoft", Title: "Kindle Colorsoft", Width: 1264, Height: 1680, Color: true,
+		Transform: TransformDefaults{Color: kaleidoColor}},
+	{Name: "kobo-clara", Title: "Kobo Clara", Width: 1072, Height: 1448,
+		Format: comicbook.FormatEPUB, Quirks: Quirks{PadPages: true}},
+	{Name: "kobo-libra", Title: "Kobo Libra", Width: 1264, Height: 1680,
+		Format: comicbook.FormatEPUB, Quirks: Quirks{PadPages: true}},
+	{Name: "kobo-libra-colour", Title: "Kobo Libra Colour", Width: 1264, Height: 1680, Color: true,
+		Format: comicbook.FormatEPUB, Quirks: Quirks{PadPages: true},
+		Transform: TransformDefaults{Color: kaleidoColor}},
+	{Name: "kobo-sage", Title: "Kobo Sage", Width: 1440, Height: 1920,
+		Format: comicbook.FormatEPUB, Quirks: Quirks{PadPages: true}},
+	{Name: "boox-note-air", Title: "Boox Note Air", Width: 1404, Height: 1872},
+	{Name: "boox-palma", Title: "Boox Palma", Width: 824, Height: 1648,
+		Transform: TransformDefaults{Spread: transform.SpreadSplit}},
+}
@@ -0,0 +1,123 @@
+package device
+
+import (
+	"github.com/abbit/m4k/internal/comicbook"
+	"github.com/abbit/m4k/internal/transform"
+)
+
+const (
+	DefaultProfile    = "kindle-pw5"
+	defaultEncoding   = "jpg"
+	defaultGrayLevels = 16
+)
+
+// Profile describes device screen, its reading app and how pages are prepared for it
+type Profile struct {
+	Name  string `json:"name"`
+	Title string `json:"title,omitempty"`
+	// Screen resolution in portrait orientation, px
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// Screen can show colors
+	Color bool `json:"color,omitempty"`
+	// Number of gray levels screen can show. Default: 16, as for most e-ink screens
+	GrayLevels int `json:"grayLevels,omitempty"`
+	// Format best supported by device's reading app. Default: cbz
+	Format    comicbook.Format  `json:"format,omitempty"`
+	Quirks    Quirks            `json:"quirks,omitempty"`
+	Transform TransformDefaults `json:"transform,omitempty"`
+}
+
+// Quirks of device's reading app, which pages are adapted to
+type Quirks struct {
+	// Reading app stretches pages which don't match screen size, so pages are padded to it
+	PadPages bool `json:"padPages,omitempty"`
+	// Reading app scales pages to screen itself, so pages aren't enlarged,
+	// which would only make them larger
+	NoUpscale bool `json:"noUpscale,omitempty"`
+	// Reading app is slow to turn or fails to open pages larger than this, in bytes.
+	// JPEG pages are fitted into it by lowering quality. 0 means no limit
+	MaxPageBytes int64 `json:"maxPageBytes,omitempty"`
+}
+
+// TransformDefaults are transform options suitable for device
+type TransformDefaults struct {
+	Encoding    string                `json:"encoding,omitempty"`
+	JpegQuality int                   `json:"jpegQuality,omitempty"`
+	Fit         transform.FitMode     `json:"fit,omitempty"`
+	Spread      transform.SpreadMode  `json:"spread,omitempty"`
+	Crop        bool                  `json:"crop,omitempty"`
+	Tone        transform.ToneOptions `json:"tone,omitempty"`
+	// Dithering of pages quantized to gray levels of screen, which is done for PNG encoding
+	Dither transform.Dither `json:"dither,omitempty"`
+	// Tuning of color pages for color screens
+	Color transform.ColorOptions `json:"color,omitempty"`
+}
+
+// PreferredFormat returns output format for device
+func (p *Profile) PreferredFormat() comicbook.Format {
+	if len(p.Format) == 0 {
+		return comicbook.FormatCBZ
+	}
+	return p.Format
+}
+
+// Levels returns number of gray levels screen can show
+func (p *Profile) Levels() int {
+	if p.GrayLevels <= 0 {
+		return defaultGrayLevels
+	}
+	return p.GrayLevels
+}
+
+func (p *Profile) colorOptions() transform.ColorOptions {
+	opts := p.Transform.Color
+	opts.Enabled = p.Color
+	return opts
+}
+
+// TransformOptions returns transform options for device, to be adjusted by caller
+func (p *Profile) TransformOptions() *transform.Options {
+	encoding := p.Transform.Encoding
+	if len(encoding) == 0 {
+		encoding = defaultEncoding
+	}
+	// JPEG can't keep exact levels, so only PNG pages are quantized
+	levels := 0
+	if encoding == "png" {
+		levels = p.Levels()
+	}
+	// size budget can't be kept by PNG
+	var budget transform.BudgetOptions
+	if encoding != "png" {
+		budget.PageBytes = p.Quirks.MaxPageBytes
+	}
+	maxUpscale := 0.0
+	if p.Quirks.NoUpscale {
+		maxUpscale = 1
+	}
+	return &transform.Options{
+		Width:       p.Width,
+		Height:      p.Height,
+		Encoding:    encoding,
+		JpegQuality: p.Transform.JpegQuality,
+		Budget:      budget,
+		Fit:         p.Transform.Fit,
+		Pad:         p.Quirks.PadPages,
+		MaxUpscale:  maxUpscale,
+		Spread:      p.Transform.Spread,
+		Crop:        transform.CropOptions{Enabled: p.Transform.Crop},
+		Tone:        p.Transform.Tone,
+		Levels:      levels,
+		Dither:      p.Transform.Dither,
+		Color:       p.colorOptions(),
+	}
+}
+
+// SynthOptions returns options for generated pages
+func (p *Profile) SynthOptions() *comicbook.SynthOptions {
+	return &comicbook.SynthOptions{
+		Width:  p.Width,
+		Height: p.Height,
+	}
+}
@@ -0,0 +1,139 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/abbit/m4k/internal/comicbook"
+)
+
+// Registry holds device profiles by name
+type Registry struct {
+	profiles map[string]*Profile
+}
+
+// NewRegistry returns registry with built-in profiles
+func NewRegistry() *Registry {
+	r := &Registry{profiles: make(map[string]*Profile)}
+	for i := range builtinProfiles {
+		p := builtinProfiles[i]
+		r.profiles[p.Name] = &p
+	}
+	return r
+}
+
+// Get returns profile by name, case-insensitive
+func (r *Registry) Get(name string) (*Profile, error) {
+	if p, ok := r.profiles[strings.ToLower(name)]; ok {
+		return p, nil
+	}
+	return nil, fmt.Errorf("unknown device %q, expected one of %v", name, r.Names())
+}
+
+// Names returns sorted names of all profiles
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.profiles))
+	for name := range r.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Profiles returns all profiles sorted by name
+func (r *Registry) Profiles() []*Profile {
+	profiles := make([]*Profile, 0, len(r.profiles))
+	for _, name := range r.Names() {
+		profiles = append(profiles, r.profiles[name])
+	}
+	return profiles
+}
+
+// Add adds profile to registry, replacing profile with the same name
+func (r *Registry) Add(p *Profile) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	p.Name = strings.ToLower(p.Name)
+	if len(p.Title) == 0 {
+		p.Title = p.Name
+	}
+	r.profiles[p.Name] = p
+	return nil
+}
+
+// Load adds profiles from JSON file with array of profiles.
+// Profiles from file replace built-in profiles with the same name.
+func (r *Registry) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("while reading device profiles: %w", err)
+	}
+
+	var profiles []*Profile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return fmt.Errorf("while parsing device profiles %s: %w", path, err)
+	}
+	for _, p := range profiles {
+		if err := r.Add(p); err != nil {
+			return fmt.Errorf("in device profiles %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// DefaultProfilesPath returns path to user-defined profiles in user config directory
+func DefaultProfilesPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "m4k", "devices.json"), nil
+}
+
+// LoadRegistry returns registry with built-in profiles and user-defined profiles from path.
+// If path is empty, profiles are loaded from DefaultProfilesPath, if it exists.
+func LoadRegistry(path string) (*Registry, error) {
+	r := NewRegistry()
+	if len(path) > 0 {
+		return r, r.Load(path)
+	}
+
+	path, err := DefaultProfilesPath()
+	if err != nil {
+		// no config dir, no user-defined profiles
+		return r, nil
+	}
+	if err := r.Load(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return r, err
+	}
+	return r, nil
+}
+
+func (p *Profile) validate() error {
+	if len(p.Name) == 0 {
+		return fmt.Errorf("device profile has no name")
+	}
+	if p.Width <= 0 || p.Height <= 0 {
+		return fmt.Errorf("device %q: resolution must be positive, got %dx%d", p.Name, p.Width, p.Height)
+	}
+	if len(p.Format) > 0 {
+		if _, err := comicbook.ParseFormat(string(p.Format)); err != nil {
+			return fmt.Errorf("device %q: %w", p.Name, err)
+		}
+	}
+	if p.Quirks.MaxPageBytes < 0 {
+		return fmt.Errorf("device %q: maximum page size must not be negative, got %d", p.Name, p.Quirks.MaxPageBytes)
+	}
+	// encoding, modes and tone of transform defaults are checked as transform options
+	if err := p.TransformOptions().Validate(); err != nil {
+		return fmt.Errorf("device %q: %w", p.Name, err)
+	}
+	return nil
+}
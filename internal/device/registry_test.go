@@ -0,0 +1,171 @@
+package device
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/abbit/m4k/internal/comicbook"
+	"github.com/abbit/m4k/internal/transform"
+)
+
+func TestBuiltinProfiles(t *testing.T) {
+	r := NewRegistry()
+	if _, err := r.Get(DefaultProfile); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range r.Profiles() {
+		if err := p.validate(); err != nil {
+			t.Errorf("built-in profile: %v", err)
+		}
+		// tone mapping is opt-in
+		if p.Transform.Tone != (transform.ToneOptions{}) {
+			t.Errorf("built-in profile %q maps tone: %+v", p.Name, p.Transform.Tone)
+		}
+	}
+}
+
+func TestRegistryGet(t *testing.T) {
+	r := NewRegistry()
+	p, err := r.Get("Kobo-Sage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "kobo-sage" {
+		t.Errorf("got profile %q", p.Name)
+	}
+	if _, err := r.Get("nook"); err == nil {
+		t.Error("unknown device is found")
+	}
+}
+
+// writeProfiles writes profiles file into temporary directory
+func writeProfiles(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "devices.json")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadRegistry(t *testing.T) {
+	path := writeProfiles(t, `[
+		{"name": "Pocketbook-Era", "width": 1264, "height": 1680, "quirks": {"noUpscale": true}},
+		{"name": "kindle-pw5", "title": "My Kindle", "width": 1236, "height": 1648, "format": "epub"}
+	]`)
+	r, err := LoadRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.Get("pocketbook-era")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "pocketbook-era" || p.Title != "pocketbook-era" || !p.Quirks.NoUpscale {
+		t.Errorf("user-defined profile is %+v", p)
+	}
+	p, err = r.Get("kindle-pw5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Title != "My Kindle" || p.PreferredFormat() != comicbook.FormatEPUB {
+		t.Errorf("built-in profile isn't replaced: %+v", p)
+	}
+	if len(r.Names()) != len(builtinProfiles)+1 {
+		t.Errorf("got %d profiles, want %d", len(r.Names()), len(builtinProfiles)+1)
+	}
+
+	if _, err := LoadRegistry(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("missing profiles file is loaded")
+	}
+}
+
+func TestLoadInvalidProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+	}{
+		{"no name", `{"width": 600, "height": 800}`},
+		{"no resolution", `{"name": "a", "width": 600}`},
+		{"unknown format", `{"name": "a", "width": 600, "height": 800, "format": "djvu"}`},
+		{"negative page size", `{"name": "a", "width": 600, "height": 800, "quirks": {"maxPageBytes": -1}}`},
+		{"unknown encoding", `{"name": "a", "width": 600, "height": 800, "transform": {"encoding": "webp"}}`},
+		{"jpeg quality", `{"name": "a", "width": 600, "height": 800, "transform": {"jpegQuality": 101}}`},
+		{"unknown fit", `{"name": "a", "width": 600, "height": 800, "transform": {"fit": "zoom"}}`},
+		{"unknown spread", `{"name": "a", "width": 600, "height": 800, "transform": {"spread": "fold"}}`},
+		{"unknown dither", `{"name": "a", "width": 600, "height": 800, "transform": {"dither": "noise"}}`},
+		{"negative gamma", `{"name": "a", "width": 600, "height": 800, "transform": {"tone": {"gamma": -1}}}`},
+		{"negative contrast", `{"name": "a", "width": 600, "height": 800, "transform": {"tone": {"contrast": -2}}}`},
+		{"levels clip", `{"name": "a", "width": 600, "height": 800, "transform": {"tone": {"levelsClip": 0.5}}}`},
+		{"gray levels", `{"name": "a", "width": 600, "height": 800, "grayLevels": 1000, "transform": {"encoding": "png"}}`},
+		{"malformed", `{"name": 1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			if err := r.Load(writeProfiles(t, "["+tt.profile+"]")); err == nil {
+				t.Error("invalid profile is loaded")
+			}
+		})
+	}
+}
+
+func TestTransformOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile Profile
+		check   func(*transform.Options) bool
+	}{
+		{
+			name:    "defaults",
+			profile: Profile{},
+			check: func(o *transform.Options) bool {
+				return o.Encoding == "jpg" && o.Levels == 0 && !o.Pad && o.MaxUpscale == 0 && o.Budget.PageBytes == 0
+			},
+		},
+		{
+			name:    "png is quantized to gray levels",
+			profile: Profile{GrayLevels: 4, Transform: TransformDefaults{Encoding: "png"}},
+			check:   func(o *transform.Options) bool { return o.Levels == 4 },
+		},
+		{
+			name:    "padded pages",
+			profile: Profile{Quirks: Quirks{PadPages: true}},
+			check:   func(o *transform.Options) bool { return o.Pad },
+		},
+		{
+			name:    "no upscale",
+			profile: Profile{Quirks: Quirks{NoUpscale: true}},
+			check:   func(o *transform.Options) bool { return o.MaxUpscale == 1 },
+		},
+		{
+			name:    "page size limit",
+			profile: Profile{Quirks: Quirks{MaxPageBytes: 1000}},
+			check:   func(o *transform.Options) bool { return o.Budget.PageBytes == 1000 },
+		},
+		{
+			name:    "page size limit isn't kept by png",
+			profile: Profile{Quirks: Quirks{MaxPageBytes: 1000}, Transform: TransformDefaults{Encoding: "png"}},
+			check:   func(o *transform.Options) bool { return o.Budget.PageBytes == 0 },
+		},
+		{
+			name:    "color",
+			profile: Profile{Color: true, Transform: TransformDefaults{Color: transform.ColorOptions{Saturation: 30}}},
+			check:   func(o *transform.Options) bool { return o.Color.Enabled && o.Color.Saturation == 30 },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.profile.Name, tt.profile.Width, tt.profile.Height = "test", 600, 800
+			opts := tt.profile.TransformOptions()
+			if !tt.check(opts) {
+				t.Errorf("got options %+v", opts)
+			}
+			if err := opts.Validate(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
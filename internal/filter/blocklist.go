@@ -0,0 +1,43 @@
+package filter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ParseBlocklist reads hashes, one per line.
+// Text after hash and lines starting with "#" are comments.
+func ParseBlocklist(r io.Reader) ([]Hash, error) {
+	var hashes []Hash
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		hash, err := ParseHash(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, scanner.Err()
+}
+
+func ReadBlocklist(path string) ([]Hash, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hashes, err := ParseBlocklist(file)
+	if err != nil {
+		return nil, fmt.Errorf("while reading blocklist %s: %w", path, err)
+	}
+	return hashes, nil
+}
@@ -0,0 +1,169 @@
+package filter
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+
+	"github.com/abbit/m4k/internal/comicbook"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	DefaultMinChapters = 3
+	DefaultMaxDistance = 6
+)
+
+type Options struct {
+	// Remove pages repeating in at least MinChapters chapters
+	Repeated    bool
+	MinChapters int
+	// Maximum hash distance for pages to be considered the same
+	MaxDistance int
+	// Remove pages matching any of these hashes
+	Blocklist []Hash
+}
+
+func (o *Options) Enabled() bool {
+	return o.Repeated || len(o.Blocklist) > 0
+}
+
+type RemovedPage struct {
+	File   string
+	Hash   Hash
+	Reason string
+}
+
+func (p RemovedPage) String() string {
+	return fmt.Sprintf("%s %s: %s", p.File, p.Hash, p.Reason)
+}
+
+type Report struct {
+	Removed []RemovedPage
+}
+
+func (r *Report) String() string {
+	lines := make([]string, 0, len(r.Removed))
+	for _, removed := range r.Removed {
+		lines = append(lines, removed.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (r *Report) LogValue() slog.Value {
+	attrs := make([]slog.Attr, 0, len(r.Removed))
+	for _, removed := range r.Removed {
+		attrs = append(attrs, slog.String(removed.File, removed.Reason))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// FilterComicBook removes credit and ad pages: pages repeating across chapters
+// and pages matching blocklist. Only story pages are checked, pages which can't
+// be decoded are kept.
+func FilterComicBook(cb *comicbook.ComicBook, opts *Options) (*Report, error) {
+	report := &Report{}
+	if !opts.Enabled() {
+		return report, nil
+	}
+
+	hashes, err := hashPages(cb.Pages)
+	if err != nil {
+		return nil, err
+	}
+
+	reasons := make(map[*comicbook.Page]string)
+	for page, hash := range hashes {
+		if reason, ok := blocklisted(hash, opts); ok {
+			reasons[page] = reason
+		}
+	}
+	if opts.Repeated {
+		for page, reason := range repeatedPages(hashes, opts) {
+			if _, ok := reasons[page]; !ok {
+				reasons[page] = reason
+			}
+		}
+	}
+
+	// page paths change after renumbering, so report is filled before removing
+	for _, page := range cb.Pages {
+		if reason, ok := reasons[page]; ok {
+			report.Removed = append(report.Removed, RemovedPage{
+				File:   page.Filepath(),
+				Hash:   hashes[page],
+				Reason: reason,
+			})
+		}
+	}
+	cb.RemovePages(func(p *comicbook.Page) bool {
+		_, ok := reasons[p]
+		return ok
+	})
+
+	return report, nil
+}
+
+func hashPages(pages []*comicbook.Page) (map[*comicbook.Page]Hash, error) {
+	var eg errgroup.Group
+	eg.SetLimit(max(1, runtime.NumCPU()-1))
+
+	hashes := make([]Hash, len(pages))
+	decoded := make([]bool, len(pages))
+	for i, p := range pages {
+		if p.Kind != comicbook.PageKindStory {
+			continue
+		}
+
+		i, p := i, p
+		eg.Go(func() error {
+			hash, err := DataHash(p.Data)
+			hashes[i], decoded[i] = hash, err == nil
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	result := make(map[*comicbook.Page]Hash, len(pages))
+	for i, p := range pages {
+		// uniform pages, e.g. blank ones, have zero hash and are often intentional
+		if decoded[i] && hashes[i] != 0 {
+			result[p] = hashes[i]
+		}
+	}
+	return result, nil
+}
+
+func blocklisted(hash Hash, opts *Options) (string, bool) {
+	for _, blocked := range opts.Blocklist {
+		if hash.Distance(blocked) <= opts.MaxDistance {
+			return fmt.Sprintf("matches blocklisted %s", blocked), true
+		}
+	}
+	return "", false
+}
+
+// repeatedPages finds pages with near-identical pages in at least MinChapters chapters
+func repeatedPages(hashes map[*comicbook.Page]Hash, opts *Options) map[*comicbook.Page]string {
+	minChapters := opts.MinChapters
+	if minChapters < 2 {
+		minChapters = DefaultMinChapters
+	}
+
+	repeated := make(map[*comicbook.Page]string)
+	for page, hash := range hashes {
+		chapters := make(map[*comicbook.ChapterInfo]struct{})
+		for other, otherHash := range hashes {
+			if hash.Distance(otherHash) <= opts.MaxDistance {
+				chapters[other.ChapterInfo] = struct{}{}
+			}
+		}
+		if len(chapters) >= minChapters {
+			repeated[page] = fmt.Sprintf("repeats in %d chapters", len(chapters))
+		}
+	}
+	return repeated
+}
@@ -0,0 +1,66 @@
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math/bits"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+)
+
+// Hash is a 64-bit difference hash of an image.
+// Similar images have hashes with small Hamming distance.
+type Hash uint64
+
+const (
+	hashWidth  = 9
+	hashHeight = 8
+)
+
+// ImageHash computes difference hash: image is reduced to 9x8 grayscale
+// and each bit tells if pixel is brighter than its right neighbour
+func ImageHash(img image.Image) Hash {
+	small := imaging.Resize(imaging.Grayscale(img), hashWidth, hashHeight, imaging.Box)
+
+	var hash Hash
+	for y := 0; y < hashHeight; y++ {
+		for x := 0; x < hashWidth-1; x++ {
+			// grayscale image has equal channels, red is enough
+			left := small.Pix[small.PixOffset(x, y)]
+			right := small.Pix[small.PixOffset(x+1, y)]
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// DataHash decodes image data and computes its hash
+func DataHash(data []byte) (Hash, error) {
+	img, err := imaging.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	return ImageHash(img), nil
+}
+
+func ParseHash(s string) (Hash, error) {
+	hash, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hash %q: %w", s, err)
+	}
+	return Hash(hash), nil
+}
+
+// Distance returns number of differing bits
+func (h Hash) Distance(other Hash) int {
+	return bits.OnesCount64(uint64(h ^ other))
+}
+
+func (h Hash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
@@ -7,14 +7,17 @@ import (
 	"time"
 
 	"github.com/abbit/m4k/internal/mangal/provider/manager"
-	"github.com/abbit/m4k/internal/util"
+	"github.com/abbit/m4k/internal/naming"
 	"github.com/luevano/libmangal"
 	"github.com/luevano/libmangal/mangadata"
 )
 
+var defaultChapterNameTemplate = naming.MustParse("chapter file", naming.DefaultChapterFile)
+
 var (
-	clients   []*libmangal.Client
-	clientsMu sync.Mutex
+	clients             []*libmangal.Client
+	chapterNameTemplate = defaultChapterNameTemplate
+	clientsMu           sync.Mutex
 )
 
 func Get(loader libmangal.ProviderLoader) *libmangal.Client {
@@ -110,8 +113,28 @@ func findLoader(loaders []libmangal.ProviderLoader, f func(libmangal.ProviderLoa
 	return nil, false
 }
 
+// SetChapterNameTemplate sets template for downloaded chapter file names,
+// should be called before creating clients
+func SetChapterNameTemplate(t *naming.Template) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	chapterNameTemplate = t
+}
+
 func chapterName(provider libmangal.ProviderInfo, chapter mangadata.Chapter) string {
-	res := fmt.Sprintf("[%06.1f] %s", chapter.Info().Number, chapter.Info().Title)
+	volume := chapter.Volume()
+	fields := naming.Fields{
+		Series:       volume.Manga().Info().Title,
+		Volume:       int(volume.Info().Number),
+		Chapter:      float64(chapter.Info().Number),
+		ChapterTitle: chapter.Info().Title,
+	}
 
-	return util.SanitizePath(res)
+	name, err := chapterNameTemplate.Execute(fields)
+	if err != nil {
+		// fallback to default template, which can't fail
+		name, _ = defaultChapterNameTemplate.Execute(fields)
+	}
+	return name
 }
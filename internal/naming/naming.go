@@ -0,0 +1,125 @@
+package naming
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/abbit/m4k/internal/util"
+)
+
+// Default templates, producing the same names as m4k always did
+const (
+	// path of page inside of archive
+	DefaultPagePath = `Volume {{.Volume}}/{{.ChapterFull}}/{{printf "%06d" .Page}}{{.Ext}}`
+	// name of merged book
+	DefaultBookName = `{{.Series}}{{with .Range}} {{.}}{{end}}`
+	// name of volume merged book is split into
+	DefaultVolumeName = `{{.Series}} Vol {{printf "%02d" .Part}}{{with .ShortRange}} ({{.}}){{end}}`
+	// name of downloaded chapter file, should stay parseable by `comicbook.ChapterInfoFromName`
+	DefaultChapterFile = `[{{printf "%06.1f" .Chapter}}] {{.ChapterTitle}}`
+)
+
+// Fields available in templates
+type Fields struct {
+	// Series title
+	Series string
+	// Volume number
+	Volume int
+	// Chapter number
+	Chapter float64
+	// Chapter title as is
+	ChapterTitle string
+	// Chapter title with number, e.g. "Chapter 1.0 - Title"
+	ChapterFull string
+	// Page number
+	Page uint64
+	// Page file extension with dot
+	Ext string
+	// First and last chapter numbers of the book
+	RangeFrom, RangeTo float64
+	// Formatted chapters range, e.g. "Chapter 1" or "Chapters 1-10"
+	Range string
+	// Short formatted chapters range, e.g. "Ch 1" or "Ch 1-10"
+	ShortRange string
+	// Number of volume the book is split into
+	Part int
+}
+
+type Template struct {
+	tmpl *template.Template
+}
+
+func Parse(name, text string) (*Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s template: %w", name, err)
+	}
+	return &Template{tmpl: tmpl}, nil
+}
+
+func MustParse(name, text string) *Template {
+	t, err := Parse(name, text)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Execute formats name, result is sanitized to be usable as file name
+func (t *Template) Execute(f Fields) (string, error) {
+	name, err := t.execute(f)
+	if err != nil {
+		return "", err
+	}
+	return util.SanitizePath(name), nil
+}
+
+// ExecutePath formats path, where "/" in template separates directories.
+// Fields are sanitized, so they can't add directories.
+func (t *Template) ExecutePath(f Fields) (string, error) {
+	f.Series = util.SanitizePath(f.Series)
+	f.ChapterTitle = util.SanitizePath(f.ChapterTitle)
+	f.ChapterFull = util.SanitizePath(f.ChapterFull)
+	f.Range = util.SanitizePath(f.Range)
+	f.ShortRange = util.SanitizePath(f.ShortRange)
+
+	path, err := t.execute(f)
+	if err != nil {
+		return "", err
+	}
+
+	// drop empty directories, e.g. when field is empty
+	var parts []string
+	for _, part := range strings.Split(path, "/") {
+		if part = strings.TrimSpace(part); len(part) > 0 {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+func (t *Template) execute(f Fields) (string, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, f); err != nil {
+		return "", fmt.Errorf("executing %s template: %w", t.tmpl.Name(), err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// FormatRange formats chapters range as "Chapter <from>" or "Chapters <from>-<to>"
+func FormatRange(from, to float64) string {
+	if from == to {
+		return fmt.Sprintf("Chapter %g", from)
+	}
+	return fmt.Sprintf("Chapters %g-%g", from, to)
+}
+
+// FormatShortRange formats chapters range as "Ch <from>" or "Ch <from>-<to>"
+func FormatShortRange(from, to float64) string {
+	if from == to {
+		return fmt.Sprintf("Ch %g", from)
+	}
+	return fmt.Sprintf("Ch %g-%g", from, to)
+}
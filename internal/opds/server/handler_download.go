@@ -2,17 +2,21 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"image"
 	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/abbit/m4k/internal/comicbook"
+	"github.com/abbit/m4k/internal/opds"
 	"github.com/abbit/m4k/internal/transform"
 	"github.com/abbit/m4k/internal/util"
 	"github.com/luevano/libmangal"
@@ -23,15 +27,19 @@ const (
 	transformResultsDir = "transformed"
 )
 
-const (
-	KindlePW5Width  = 1236 // px
-	KindlePW5Height = 1648 // px
-)
-
 const (
 	maxRetries = 5
 )
 
+// formatFileTypes maps output formats to OPDS file types
+var formatFileTypes = map[comicbook.Format]string{
+	comicbook.FormatCBZ:  opds.FileTypeCBZ,
+	comicbook.FormatEPUB: opds.FileTypeEPUB,
+	comicbook.FormatMOBI: opds.FileTypeMOBI,
+	comicbook.FormatAZW3: opds.FileTypeAZW3,
+	comicbook.FormatPDF:  opds.FileTypePDF,
+}
+
 var (
 	baseDirPath               = path.Join(os.TempDir(), baseDirName)
 	transformedResultsDirPath = path.Join(baseDirPath, transformResultsDir)
@@ -48,6 +56,11 @@ func init() {
 func (s *Server) downloadHandler(w http.ResponseWriter, r *http.Request) {
 	var resultErr error
 	defer func() {
+		if errors.Is(resultErr, context.Canceled) {
+			// client is gone, nobody to respond to
+			slog.Info("downloadHandler: request canceled", slog.String("url", r.URL.String()))
+			return
+		}
 		if resultErr != nil {
 			slog.Error("downloadHandler", slog.Any("error", resultErr))
 			http.Error(w, resultErr.Error(), http.StatusInternalServerError)
@@ -61,22 +74,36 @@ func (s *Server) downloadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	forDevice := r.URL.Query().Get("for")
+	if len(forDevice) == 0 {
+		forDevice = s.device
+	}
+	profile, err := s.devices.Get(forDevice)
+	if err != nil {
+		resultErr = nil
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := profile.PreferredFormat()
+	if formatStr := r.URL.Query().Get("format"); len(formatStr) > 0 {
+		format, err = comicbook.ParseFormat(formatStr)
+		if err != nil {
+			resultErr = nil
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
 	slog.Debug("params",
 		slog.Any("params", params),
 		slog.String("forDevice", forDevice),
+		slog.String("format", string(format)),
 	)
 
-	var deviceWidth, deviceHeight int
-	switch forDevice {
-	case "kindle-pw5":
-		deviceWidth, deviceHeight = KindlePW5Width, KindlePW5Height
-	default:
-		resultErr = fmt.Errorf("unsupported device: %s", forDevice)
-		return
-	}
-
+	// chapters are downloaded to completion to not leave partial files,
+	// the rest of the work stops when client disconnects
 	ctx := context.Background()
+	reqCtx := r.Context()
 
 	chapters, err := getChapters(ctx, params.Client, params.Manga, params.ChaptersRange)
 	if err != nil {
@@ -100,9 +127,14 @@ func (s *Server) downloadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// TODO: rework this mess
-	var downloadedMangaDir string
+	// paths of downloaded chapters, chapter names can't be parsed back with custom chapter template
+	var chapterPaths []string
 	retryCount := 0
 	for _, chapter := range chapters {
+		if err := reqCtx.Err(); err != nil {
+			resultErr = err
+			return
+		}
 		retry := true
 		for retry {
 			retry = false
@@ -137,13 +169,23 @@ func (s *Server) downloadHandler(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			downloadedMangaDir = res.Directory
+			chapterPaths = append(chapterPaths, res.Path())
 		}
 	}
 
-	mangaChaptersTitle := formatMangaChaptersTitle(params)
-	transformedFileName := util.SanitizePath(mangaChaptersTitle) + ".cbz"
-	transformedFilePath := path.Join(transformedResultsDirPath, transformedFileName)
+	mangaChaptersTitle, err := s.mangaChaptersTitle(params)
+	if err != nil {
+		resultErr = fmt.Errorf("formatting title: %w", err)
+		return
+	}
+	transformedFileName := mangaChaptersTitle + format.Extension()
+	// files are transformed differently for each device and generated pages
+	transformedDirPath := path.Join(transformedResultsDirPath, s.transformedDirName(profile.Name))
+	if err := os.MkdirAll(transformedDirPath, os.ModePerm); err != nil {
+		resultErr = fmt.Errorf("creating transformed results dir: %w", err)
+		return
+	}
+	transformedFilePath := path.Join(transformedDirPath, transformedFileName)
 
 	exists, err := util.FileExists(transformedFilePath)
 	if err != nil {
@@ -154,84 +196,120 @@ func (s *Server) downloadHandler(w http.ResponseWriter, r *http.Request) {
 	var cbzReader io.ReadSeeker
 	if exists {
 		// file exists already, serve it
-		cbzReader, err = os.Open(transformedFilePath)
+		file, err := os.Open(transformedFilePath)
 		if err != nil {
 			resultErr = fmt.Errorf("opening transformed cbz file: %w", err)
 			return
 		}
+		defer file.Close()
+		cbzReader = file
 	} else {
 		// file does not exist, transform it
 
-		transformOpts := &transform.Options{
-			Width:  deviceWidth,
-			Height: deviceHeight,
-			// TODO: make configurable
-			Encoding: "jpg",
+		transformOpts := profile.TransformOptions()
+		transformOpts.Tolerant = true
+		transformOpts.Pipeline = s.pipeline
+		transformOpts.Cache = s.cache
+		transformOpts.Scheduler = s.scheduler
+		// a broken page shouldn't fail the whole book
+		transformOpts.OnError = transform.ErrorPlaceholder
+		transformOpts.Callback = logTransformEvent
+		synthOpts := profile.SynthOptions()
+		synthOpts.Title = params.Manga.Info().Title
+		if s.cover {
+			synthOpts.CoverImage, err = getMangaCover(ctx, params.Manga)
+			if err != nil {
+				// first page will be used instead
+				slog.Warn("getting manga cover", slog.Any("error", err))
+			}
 		}
-		cb, err := transformCBZ(downloadedMangaDir, mangaChaptersTitle, params.ChaptersRange, transformOpts)
+
+		metadata, err := getMangaMetadata(ctx, params.Client, params.Manga)
 		if err != nil {
-			resultErr = fmt.Errorf("transforming cbz file: %w", err)
-			return
+			// book is written without metadata
+			slog.Warn("getting manga metadata", slog.Any("error", err))
 		}
 
-		cbzReader, err = cb.Reader()
+		cb, err := s.transformCBZ(reqCtx, chapterPaths, mangaChaptersTitle, metadata, synthOpts, transformOpts)
 		if err != nil {
-			resultErr = fmt.Errorf("creating cbz reader: %w", err)
+			resultErr = fmt.Errorf("transforming cbz file: %w", err)
 			return
 		}
+		cb.Series = params.Manga.Info().Title
+		cb.PagePath = s.pagePath
+		cb.Format = format
+		cb.Viewport = image.Pt(profile.Width, profile.Height)
 
-		// write transformed cbz file to disk
-		cbzfile, err := os.Create(transformedFilePath)
+		reader, err := cb.Reader()
 		if err != nil {
-			resultErr = fmt.Errorf("creating transformed cbz file: %w", err)
+			resultErr = fmt.Errorf("creating cbz reader: %w", err)
 			return
 		}
 
-		if _, err := io.Copy(cbzfile, cbzReader); err != nil {
+		// write transformed cbz file to disk
+		if err := writeFileAtomically(transformedFilePath, reader); err != nil {
 			resultErr = fmt.Errorf("writing transformed cbz file: %w", err)
 			return
 		}
 
 		// reset reader to start of file
-		cbzReader.Seek(0, io.SeekStart)
+		reader.Seek(0, io.SeekStart)
+		cbzReader = reader
 	}
 
+	w.Header().Set("Content-Type", formatFileTypes[format])
 	http.ServeContent(w, r, transformedFileName, time.Time{}, cbzReader)
 }
 
-func transformCBZ(srcdir, mergedFileName string, chaptersRange []int, transformOpts *transform.Options) (*comicbook.ComicBook, error) {
-	slog.Debug("Searching cbz files",
-		slog.String("srcdir", srcdir),
-		slog.Any("chaptersRange", chaptersRange),
-	)
-	cbzFiles, err := util.FilterDirFilePaths(srcdir, func(filepath string) bool {
-		if path.Ext(filepath) != ".cbz" {
-			return false
-		}
-
-		filename := util.WithoutPaddedIndex(util.PathStem(filepath))
-		chapterInfo := comicbook.ChapterInfoFromName(filename)
-
-		var fromChapter, toChapter float64
-		fromChapter = float64(chaptersRange[0])
-		if len(chaptersRange) == 1 {
-			toChapter = fromChapter
-		} else {
-			toChapter = float64(chaptersRange[1])
-		}
-
-		return chapterInfo.Number >= fromChapter && chapterInfo.Number <= toChapter
-	})
+// writeFileAtomically writes file through temporary file in the same directory,
+// so partially written file is never served
+func writeFileAtomically(path string, r io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("searching cbz files: %w", err)
+		os.Remove(tmp.Name())
 	}
+	return err
+}
+
+// transformedDirName returns name of directory with files transformed for device
+func (s *Server) transformedDirName(device string) string {
+	name := device
+	if s.cover {
+		name += "+cover"
+	}
+	if s.dividers {
+		name += "+dividers"
+	}
+	return name
+}
 
+func (s *Server) transformCBZ(
+	ctx context.Context,
+	cbzFiles []string,
+	mergedFileName string,
+	metadata comicbook.Metadata,
+	synthOpts *comicbook.SynthOptions,
+	transformOpts *transform.Options,
+) (*comicbook.ComicBook, error) {
 	slog.Debug("Reading cbz files",
 		slog.Any("cbzFiles", cbzFiles),
 	)
 	var comicbooks []*comicbook.ComicBook
 	for _, path := range cbzFiles {
-		cb, err := comicbook.ReadComicBook(path)
+		cb, err := comicbook.ReadComicBookWithOptions(path, &comicbook.ReadOptions{Tolerant: true})
 		if err != nil {
 			return nil, fmt.Errorf("reading comicbook from path %s: %w", path, err)
 		}
@@ -241,16 +319,75 @@ func transformCBZ(srcdir, mergedFileName string, chaptersRange []int, transformO
 	slog.Debug("Merging cbz files",
 		slog.Any("mergedFileName", mergedFileName),
 	)
-	combined := comicbook.MergeComicBooks(comicbooks, mergedFileName)
+	combined, mergeReport := comicbook.MergeComicBooks(comicbooks, mergedFileName, &comicbook.MergeOptions{
+		Duplicates: comicbook.DuplicatesKeepLargest,
+	})
+	if mergeReport.HasIssues() {
+		slog.Warn("Merged with issues", slog.Any("report", mergeReport))
+	} else {
+		slog.Debug("Merged", slog.Any("report", mergeReport))
+	}
+
+	// provider metadata fills in what chapter files don't have,
+	// reading direction is used for spreads too, so it's set before transforming
+	if len(combined.Metadata.Summary) == 0 {
+		combined.Metadata.Summary = metadata.Summary
+	}
+	if len(combined.Metadata.Writer) == 0 {
+		combined.Metadata.Writer = metadata.Writer
+	}
+	combined.Metadata.RightToLeft = combined.Metadata.RightToLeft || metadata.RightToLeft
+
+	if s.dividers {
+		slog.Debug("Adding divider pages")
+		if err := comicbook.AddDividerPages(combined, synthOpts); err != nil {
+			return nil, fmt.Errorf("adding divider pages: %w", err)
+		}
+	}
+	if s.cover {
+		slog.Debug("Adding cover page")
+		if err := comicbook.AddCoverPage(combined, synthOpts); err != nil {
+			return nil, fmt.Errorf("adding cover page: %w", err)
+		}
+	}
 
 	slog.Debug("Transforming combined file",
 		slog.Any("transformOpts", transformOpts),
 	)
-	if err := transform.TransformComicBook(combined, transformOpts); err != nil {
+	if err := transform.TransformComicBook(ctx, combined, transformOpts); err != nil {
 		return nil, fmt.Errorf("transforming pages: %w", err)
 	}
 
+	if transformOpts.Color.Enabled {
+		slog.Debug("Kept color pages",
+			slog.Int("colorPages", combined.ColorPages()),
+			slog.Int("pages", len(combined.Pages)),
+		)
+	}
+
+	if combined.Repairs.HasIssues() {
+		slog.Warn("Repaired problems in combined file", slog.Any("repairs", combined.Repairs))
+	}
+
 	slog.Debug("Done transforming combined file")
 
 	return combined, nil
 }
+
+func logTransformEvent(e transform.Event) {
+	switch e.Kind {
+	case transform.EventPageFinished:
+		slog.Debug("Transformed page",
+			slog.String("page", e.Page),
+			slog.Int("bytesIn", e.BytesIn),
+			slog.Int("bytesOut", e.BytesOut),
+			slog.Duration("duration", e.Duration),
+			slog.Bool("cached", e.Cached),
+			slog.Int64("memoryBytes", e.MemoryBytes),
+		)
+	case transform.EventWarning:
+		slog.Warn("Repairing page", slog.String("page", e.Page), slog.Any("error", e.Err))
+	case transform.EventError:
+		slog.Error("Transforming page", slog.String("page", e.Page), slog.Any("error", e.Err))
+	}
+}
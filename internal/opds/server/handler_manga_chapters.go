@@ -6,7 +6,9 @@ import (
 	"log/slog"
 	"math"
 	"net/http"
+	"net/url"
 
+	"github.com/abbit/m4k/internal/comicbook"
 	"github.com/abbit/m4k/internal/opds"
 )
 
@@ -59,7 +61,11 @@ func (s *Server) mangaChaptersHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	title := formatMangaChaptersTitle(params)
+	title, err := s.mangaChaptersTitle(params)
+	if err != nil {
+		resultErr = fmt.Errorf("formatting title: %w", err)
+		return
+	}
 	mangaChaptersFeed := opds.Feed{
 		ID:          r.RequestURI,
 		Title:       title,
@@ -76,14 +82,7 @@ func (s *Server) mangaChaptersHandler(w http.ResponseWriter, r *http.Request) {
 			{
 				Title:       title,
 				LastUpdated: opds.TimeNow(),
-				Link: []opds.Link{
-					{
-						Rel:   opds.RelAcquisition,
-						Type:  opds.FileTypeCBZ,
-						Href:  fmt.Sprintf("/opds/%s/%s/%s/download?for=kindle-pw5", params.Provider, params.MangaEncoded, encodeChaptersRange(params.ChaptersRange)),
-						Title: "cbz",
-					},
-				},
+				Link:        acquisitionLinks(params, s.device),
 			},
 		},
 	}
@@ -92,3 +91,17 @@ func (s *Server) mangaChaptersHandler(w http.ResponseWriter, r *http.Request) {
 		resultErr = fmt.Errorf("writing response: %w", err)
 	}
 }
+
+// acquisitionLinks returns download link for each supported format
+func acquisitionLinks(params *params, forDevice string) []opds.Link {
+	links := make([]opds.Link, 0, len(comicbook.Formats))
+	for _, format := range comicbook.Formats {
+		links = append(links, opds.Link{
+			Rel:   opds.RelAcquisition,
+			Type:  formatFileTypes[format],
+			Href:  fmt.Sprintf("/opds/%s/%s/%s/download?for=%s&format=%s", params.Provider, params.MangaEncoded, encodeChaptersRange(params.ChaptersRange), url.QueryEscape(forDevice), format),
+			Title: string(format),
+		})
+	}
+	return links
+}
@@ -2,10 +2,17 @@ package server
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
+	"strings"
+	"time"
 
+	"github.com/abbit/m4k/internal/comicbook"
 	"github.com/luevano/libmangal"
 	"github.com/luevano/libmangal/mangadata"
+	"github.com/luevano/libmangal/metadata"
 )
 
 func getChapters(ctx context.Context, client *libmangal.Client, manga mangadata.Manga, chaptersRange []int) ([]mangadata.Chapter, error) {
@@ -58,3 +65,63 @@ func getAllVolumeChapters(ctx context.Context, client *libmangal.Client, volumes
 	}
 	return chapters, nil
 }
+
+// getMangaMetadata returns book metadata from provider metadata of manga.
+// Metadata isn't kept in download links, so manga is searched for again if it has none.
+func getMangaMetadata(ctx context.Context, client *libmangal.Client, manga mangadata.Manga) (comicbook.Metadata, error) {
+	meta := manga.Metadata()
+	if meta == nil {
+		mangas, err := client.SearchMangas(ctx, manga.Info().Title)
+		if err != nil {
+			return comicbook.Metadata{}, err
+		}
+		for _, found := range mangas {
+			if found.Info().ID == manga.Info().ID {
+				meta = found.Metadata()
+				break
+			}
+		}
+	}
+	if meta == nil {
+		return comicbook.Metadata{}, fmt.Errorf("manga %q has no metadata", manga.Info().Title)
+	}
+
+	return bookMetadata(meta), nil
+}
+
+func bookMetadata(meta metadata.Metadata) comicbook.Metadata {
+	return comicbook.Metadata{
+		Summary: meta.Description(),
+		Writer:  strings.Join(meta.Authors(), ", "),
+		// japanese manga is read from right to left, unlike korean and chinese comics
+		RightToLeft: strings.EqualFold(meta.Country(), "JP"),
+	}
+}
+
+// getMangaCover downloads manga cover image from provider
+func getMangaCover(ctx context.Context, manga mangadata.Manga) ([]byte, error) {
+	coverURL := manga.Info().Cover
+	if len(coverURL) == 0 {
+		return nil, fmt.Errorf("manga %q has no cover", manga.Info().Title)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, coverURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(resp.Body)
+}
@@ -6,13 +6,47 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/abbit/m4k/internal/cache"
+	"github.com/abbit/m4k/internal/device"
 	"github.com/abbit/m4k/internal/mangal/client"
+	"github.com/abbit/m4k/internal/naming"
+	"github.com/abbit/m4k/internal/transform"
 	"github.com/luevano/libmangal"
 )
 
+type Options struct {
+	// Template for names of served files. Default: naming.DefaultBookName
+	BookName *naming.Template
+	// Template for page paths inside of served archives. Default: naming.DefaultPagePath
+	PagePath *naming.Template
+	// Device profiles selectable with "for" parameter of download links. Default: built-in profiles
+	Devices *device.Registry
+	// Device used in acquisition links. Default: device.DefaultProfile
+	Device string
+	// Stages pages are passed through. Default: transform.DefaultPreset
+	Pipeline *transform.Pipeline
+	// Cache of transformed pages, shared by overlapping chapter ranges. Default: no cache
+	Cache *cache.Cache
+	// Limits memory of pages transformed at once, shared by all downloads. Default: no memory limit
+	Scheduler *transform.Scheduler
+	// Add generated cover page with manga cover, title and chapters range
+	Cover bool
+	// Add generated page before each chapter
+	Dividers bool
+}
+
 type Server struct {
 	providers        []string
 	providerToClient map[string]*libmangal.Client
+	bookName         *naming.Template
+	pagePath         *naming.Template
+	devices          *device.Registry
+	device           string
+	pipeline         *transform.Pipeline
+	cache            *cache.Cache
+	scheduler        *transform.Scheduler
+	cover            bool
+	dividers         bool
 
 	handler http.Handler
 }
@@ -20,10 +54,33 @@ type Server struct {
 func New(
 	ctx context.Context,
 	providers []string,
+	opts *Options,
 ) *Server {
+	if opts == nil {
+		opts = &Options{}
+	}
+
 	s := &Server{
 		providers:        providers,
 		providerToClient: make(map[string]*libmangal.Client),
+		bookName:         opts.BookName,
+		pagePath:         opts.PagePath,
+		devices:          opts.Devices,
+		device:           opts.Device,
+		pipeline:         opts.Pipeline,
+		cache:            opts.Cache,
+		scheduler:        opts.Scheduler,
+		cover:            opts.Cover,
+		dividers:         opts.Dividers,
+	}
+	if s.bookName == nil {
+		s.bookName = naming.MustParse("book name", naming.DefaultBookName)
+	}
+	if s.devices == nil {
+		s.devices = device.NewRegistry()
+	}
+	if len(s.device) == 0 {
+		s.device = device.DefaultProfile
 	}
 	for _, provider := range providers {
 		client, err := client.NewClientByID(ctx, provider)
@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/abbit/m4k/internal/naming"
 	"github.com/abbit/m4k/internal/opds"
 	"github.com/luevano/libmangal/mangadata"
 	"github.com/luevano/libmangal/metadata"
@@ -120,14 +121,19 @@ func encodeChaptersRange(chaptersRange []int) string {
 	return fmt.Sprintf("%d-%d", chaptersRange[0], chaptersRange[1])
 }
 
-func formatChaptersRange(chaptersRange []int) string {
-	if len(chaptersRange) == 1 {
-		return fmt.Sprintf("Chapter %d", chaptersRange[0])
-	}
-
-	return fmt.Sprintf("Chapters %d-%d", chaptersRange[0], chaptersRange[1])
-}
-
-func formatMangaChaptersTitle(params *params) string {
-	return fmt.Sprintf("%s %s", params.Manga, formatChaptersRange(params.ChaptersRange))
+// mangaChaptersTitle formats title of manga chapters range with book name template
+func (s *Server) mangaChaptersTitle(params *params) (string, error) {
+	from := float64(params.ChaptersRange[0])
+	to := from
+	if len(params.ChaptersRange) == 2 {
+		to = float64(params.ChaptersRange[1])
+	}
+
+	return s.bookName.Execute(naming.Fields{
+		Series:     params.Manga.String(),
+		RangeFrom:  from,
+		RangeTo:    to,
+		Range:      naming.FormatRange(from, to),
+		ShortRange: naming.FormatShortRange(from, to),
+	})
 }
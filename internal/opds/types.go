@@ -26,7 +26,11 @@ const (
 )
 
 const (
-	FileTypeCBZ string = "application/x-cbz"
+	FileTypeCBZ  string = "application/x-cbz"
+	FileTypeEPUB string = "application/epub+zip"
+	FileTypeMOBI string = "application/x-mobipocket-ebook"
+	FileTypeAZW3 string = "application/vnd.amazon.mobi8-ebook"
+	FileTypePDF  string = "application/pdf"
 )
 
 type Time time.Time
@@ -3,11 +3,15 @@ package protocol
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Protocol struct {
@@ -45,7 +49,7 @@ func (p *Protocol) write(b []byte) (n int64, err error) {
 func (p *Protocol) read() (b []byte, err error) {
 	header := make([]byte, 4)
 	if _, err = io.ReadFull(p.conn, header); err != nil {
-		err = fmt.Errorf("reading header: %v", err)
+		err = fmt.Errorf("reading header: %w", err)
 		return
 	}
 
@@ -61,7 +65,28 @@ func (p *Protocol) read() (b []byte, err error) {
 	return
 }
 
-func (p *Protocol) SendManga(name string, r io.Reader) error {
+// Version of protocol. Receivers of version 2 greet senders on connection
+// and accept file names with extension, older ones save every file as .cbz
+const Version = 2
+
+const helloPrefix = "m4k-receiver/"
+
+// older receivers don't greet, so sender waits for greeting only this long
+var helloTimeout = 2 * time.Second
+
+// SendManga sends file with name without extension and extension with dot.
+// Older receivers can only receive .cbz files.
+func (p *Protocol) SendManga(name, ext string, r io.Reader) error {
+	version, err := p.receiverVersion()
+	if err != nil {
+		return fmt.Errorf("reading greeting: %v", err)
+	}
+	if version >= 2 {
+		name += ext
+	} else if !strings.EqualFold(ext, ".cbz") {
+		return fmt.Errorf("receiver of protocol version %d can only receive .cbz files, update it to send %s files", version, ext)
+	}
+
 	nameBytes := []byte(name)
 	n, err := p.write(nameBytes)
 	if err != nil {
@@ -78,7 +103,35 @@ func (p *Protocol) SendManga(name string, r io.Reader) error {
 	return nil
 }
 
+// receiverVersion returns protocol version from receiver's greeting, 1 if receiver doesn't greet
+func (p *Protocol) receiverVersion() (int, error) {
+	if err := p.conn.SetReadDeadline(time.Now().Add(helloTimeout)); err != nil {
+		return 0, err
+	}
+	defer p.conn.SetReadDeadline(time.Time{})
+
+	hello, err := p.read()
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	version, err := strconv.Atoi(strings.TrimPrefix(string(hello), helloPrefix))
+	if !strings.HasPrefix(string(hello), helloPrefix) || err != nil {
+		return 0, fmt.Errorf("unexpected greeting %q", hello)
+	}
+	return version, nil
+}
+
 func (p *Protocol) ReceiveManga(destdir string) error {
+	// greet sender, so it can send file names with extension
+	if _, err := p.write([]byte(helloPrefix + strconv.Itoa(Version))); err != nil {
+		return fmt.Errorf("writing greeting: %v", err)
+	}
+
 	// receive file name
 	nameBytes, err := p.read()
 	if err != nil {
@@ -88,7 +141,7 @@ func (p *Protocol) ReceiveManga(destdir string) error {
 
 	// create dest file
 	// TODO: handle situation when file already exists
-	file, err := os.Create(filepath.Join(destdir, fmt.Sprintf("%s.cbz", filepath.Base(name))))
+	file, err := os.Create(filepath.Join(destdir, receivedFileName(name)))
 	if err != nil {
 		return fmt.Errorf("creating receiving file: %v", err)
 	}
@@ -101,3 +154,14 @@ func (p *Protocol) ReceiveManga(destdir string) error {
 
 	return nil
 }
+
+// extensions of files which can be sent, older senders send names without extension
+var knownExtensions = map[string]bool{".cbz": true, ".epub": true, ".mobi": true, ".azw3": true, ".pdf": true}
+
+func receivedFileName(name string) string {
+	name = filepath.Base(name)
+	if knownExtensions[strings.ToLower(filepath.Ext(name))] {
+		return name
+	}
+	return name + ".cbz"
+}
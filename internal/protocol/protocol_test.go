@@ -0,0 +1,168 @@
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// connPair returns connected sender and receiver ends of loopback TCP connection
+func connPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+	sender, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver := <-accepted
+	if receiver == nil {
+		t.Fatal("accepting connection failed")
+	}
+	t.Cleanup(func() {
+		sender.Close()
+		receiver.Close()
+	})
+	return sender, receiver
+}
+
+// oldReceive receives file as receivers of version 1 did, returns name and data
+func oldReceive(conn net.Conn) (string, []byte, error) {
+	p := New(conn)
+	name, err := p.read()
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := io.ReadAll(conn)
+	return string(name) + ".cbz", data, err
+}
+
+func TestSendManga(t *testing.T) {
+	helloTimeout = 200 * time.Millisecond
+	data := bytes.Repeat([]byte("data"), 1000)
+
+	tests := []struct {
+		name     string
+		ext      string
+		old      bool
+		wantName string
+		wantErr  bool
+	}{
+		{name: "Book", ext: ".cbz", wantName: "Book.cbz"},
+		{name: "Book", ext: ".epub", wantName: "Book.epub"},
+		{name: "Book.v2", ext: ".mobi", wantName: "Book.v2.mobi"},
+		{name: "Book", ext: ".cbz", old: true, wantName: "Book.cbz"},
+		{name: "Book", ext: ".epub", old: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+tt.ext, func(t *testing.T) {
+			sender, receiver := connPair(t)
+			dir := t.TempDir()
+
+			type result struct {
+				name string
+				data []byte
+				err  error
+			}
+			received := make(chan result, 1)
+			go func() {
+				if tt.old {
+					name, data, err := oldReceive(receiver)
+					received <- result{name, data, err}
+					return
+				}
+				err := New(receiver).ReceiveManga(dir)
+				entries, _ := os.ReadDir(dir)
+				if len(entries) != 1 {
+					received <- result{err: err}
+					return
+				}
+				data, _ := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+				received <- result{entries[0].Name(), data, err}
+			}()
+
+			err := New(sender).SendManga(tt.name, tt.ext, bytes.NewReader(data))
+			sender.Close()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res := <-received
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+			if res.name != tt.wantName {
+				t.Errorf("received %q, want %q", res.name, tt.wantName)
+			}
+			if !bytes.Equal(res.data, data) {
+				t.Errorf("received %d bytes, want %d", len(res.data), len(data))
+			}
+		})
+	}
+}
+
+func TestReceiveMangaFromOldSender(t *testing.T) {
+	sender, receiver := connPair(t)
+	dir := t.TempDir()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- New(receiver).ReceiveManga(dir)
+	}()
+
+	// senders of version 1 don't read greeting and send name without extension
+	p := New(sender)
+	if _, err := p.write([]byte("Book")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(sender, strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+	sender.Close()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "Book.cbz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "data" {
+		t.Errorf("received %q", data)
+	}
+}
+
+func TestReceivedFileName(t *testing.T) {
+	tests := map[string]string{
+		"Book":             "Book.cbz",
+		"Book.cbz":         "Book.cbz",
+		"Book.EPUB":        "Book.EPUB",
+		"Vol.2 Ch.3":       "Vol.2 Ch.3.cbz",
+		"../../etc/passwd": "passwd.cbz",
+	}
+	for name, want := range tests {
+		if got := receivedFileName(name); got != want {
+			t.Errorf("receivedFileName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"sync"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+var (
+	ErrZeroWidthHeight = fmt.Errorf("width and height must be greater than 0")
+)
+
+var (
+	fontsOnce   sync.Once
+	boldFont    *opentype.Font
+	regularFont *opentype.Font
+	fontsErr    error
+)
+
+func loadFonts() error {
+	fontsOnce.Do(func() {
+		boldFont, fontsErr = opentype.Parse(gobold.TTF)
+		if fontsErr != nil {
+			return
+		}
+		regularFont, fontsErr = opentype.Parse(goregular.TTF)
+	})
+	return fontsErr
+}
+
+func newFace(f *opentype.Font, size float64) (font.Face, error) {
+	return opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+}
+
+// Cover renders cover page with title and subtitle on a band under background image.
+// Background is cropped to fill the page, if nil page is left white.
+func Cover(background image.Image, title, subtitle string, width, height int) (image.Image, error) {
+	if width <= 0 || height <= 0 {
+		return nil, ErrZeroWidthHeight
+	}
+
+	page := newPage(width, height, color.White)
+	if background != nil {
+		background = imaging.Fill(background, width, height, imaging.Center, imaging.Lanczos)
+		draw.Draw(page, page.Bounds(), background, image.Point{}, draw.Src)
+	}
+
+	// title band at the bottom of the page
+	band := image.Rect(0, height*7/10, width, height)
+	draw.Draw(page, band, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(page, image.Rect(0, band.Min.Y, width, band.Min.Y+max(2, height/400)), image.NewUniform(color.Black), image.Point{}, draw.Src)
+
+	if err := drawTitle(page, band, title, subtitle, width); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// Divider renders chapter divider page with title and subtitle in the middle of white page
+func Divider(title, subtitle string, width, height int) (image.Image, error) {
+	if width <= 0 || height <= 0 {
+		return nil, ErrZeroWidthHeight
+	}
+
+	page := newPage(width, height, color.White)
+	if err := drawTitle(page, image.Rect(0, height/4, width, height*3/4), title, subtitle, width); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// Placeholder renders page with a message, used in place of pages that can't be decoded
+func Placeholder(message string, width, height int) (image.Image, error) {
+	if width <= 0 || height <= 0 {
+		return nil, ErrZeroWidthHeight
+	}
+
+	page := newPage(width, height, color.Gray{Y: 0xdd})
+	if err := drawTitle(page, image.Rect(0, height/3, width, height*2/3), "Page unavailable", message, width); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+func EncodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, img, imaging.PNG); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func newPage(width, height int, bg color.Color) *image.NRGBA {
+	page := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(page, page.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	return page
+}
+
+// drawTitle draws bold title and regular subtitle centered in rect,
+// font sizes are relative to page width
+func drawTitle(dst draw.Image, rect image.Rectangle, title, subtitle string, width int) error {
+	if err := loadFonts(); err != nil {
+		return fmt.Errorf("loading fonts: %w", err)
+	}
+
+	titleFace, err := newFace(boldFont, float64(width)/14)
+	if err != nil {
+		return err
+	}
+	defer titleFace.Close()
+
+	subtitleFace, err := newFace(regularFont, float64(width)/24)
+	if err != nil {
+		return err
+	}
+	defer subtitleFace.Close()
+
+	margin := width / 12
+	textWidth := rect.Dx() - 2*margin
+
+	titleLines := wrapText(titleFace, title, textWidth)
+	subtitleLines := wrapText(subtitleFace, subtitle, textWidth)
+
+	titleLineHeight := titleFace.Metrics().Height.Ceil()
+	subtitleLineHeight := subtitleFace.Metrics().Height.Ceil()
+	gap := 0
+	if len(titleLines) > 0 && len(subtitleLines) > 0 {
+		gap = subtitleLineHeight / 2
+	}
+	textHeight := len(titleLines)*titleLineHeight + gap + len(subtitleLines)*subtitleLineHeight
+
+	y := rect.Min.Y + max(0, (rect.Dy()-textHeight)/2)
+	y = drawLines(dst, titleFace, titleLines, rect, y)
+	drawLines(dst, subtitleFace, subtitleLines, rect, y+gap)
+
+	return nil
+}
+
+// drawLines draws horizontally centered lines starting at y, returns y after last line
+func drawLines(dst draw.Image, face font.Face, lines []string, rect image.Rectangle, y int) int {
+	metrics := face.Metrics()
+	for _, line := range lines {
+		lineWidth := font.MeasureString(face, line).Ceil()
+		d := &font.Drawer{
+			Dst:  dst,
+			Src:  image.NewUniform(color.Black),
+			Face: face,
+			Dot: fixed.Point26_6{
+				X: fixed.I(rect.Min.X + (rect.Dx()-lineWidth)/2),
+				Y: fixed.I(y) + metrics.Ascent,
+			},
+		}
+		d.DrawString(line)
+		y += metrics.Height.Ceil()
+	}
+	return y
+}
+
+// wrapText splits text into lines fitting into width
+func wrapText(face font.Face, text string, width int) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		words := strings.Fields(paragraph)
+		if len(words) == 0 {
+			continue
+		}
+
+		line := words[0]
+		for _, word := range words[1:] {
+			if font.MeasureString(face, line+" "+word).Ceil() <= width {
+				line += " " + word
+			} else {
+				lines = append(lines, line)
+				line = word
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
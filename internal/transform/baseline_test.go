@@ -0,0 +1,63 @@
+package transform
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/disintegration/imaging"
+)
+
+// baselineTransformImage is TransformImage as it was before pipeline stages were added,
+// copied from commit 8efb496 with its options passed as arguments and callback removed.
+// It's kept to benchmark current transformation against it. Page is encoded into new buffer,
+// as the original reused buffer of source data and overwrote it.
+func baselineTransformImage(data []byte, width, height int, rotate bool, encoding string, jpegQuality int) ([]byte, error) {
+	if width <= 0 || height <= 0 {
+		return nil, ErrZeroWidthHeight
+	}
+
+	if len(encoding) == 0 {
+		return nil, ErrNoEncoding
+	}
+
+	// decode image
+	img, err := imaging.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("while decoding image: %w", err)
+	}
+
+	// transform image
+	twopages := false
+	// check if image is in landscape mode
+	if imgsize := img.Bounds().Size(); imgsize.X > imgsize.Y {
+		if rotate {
+			img = imaging.Rotate90(img)
+		} else {
+			twopages = true
+		}
+	}
+	if twopages {
+		width *= 2
+	}
+	img = imaging.Resize(img, width, height, imaging.Lanczos)
+	img = imaging.Grayscale(img)
+
+	// encode image
+	buf := new(bytes.Buffer)
+	switch encoding {
+	case "png":
+		err = imaging.Encode(buf, img, imaging.PNG)
+	case "jpeg", "jpg":
+		if jpegQuality == 0 {
+			jpegQuality = defaultJpegQuality
+		}
+		err = imaging.Encode(buf, img, imaging.JPEG, imaging.JPEGQuality(jpegQuality))
+	default:
+		err = fmt.Errorf("unsupported encoding: %s", encoding)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("while encoding image: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,226 @@
+package transform
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/abbit/m4k/internal/comicbook"
+)
+
+const (
+	DefaultMinJpegQuality = 30
+	DefaultMaxJpegQuality = 95
+	// share of black and white pixels for page to be line art
+	lineArtShare = 0.9
+	// pixels darker or brighter than this distance from black or white count as line art
+	lineArtTolerance = 32
+	// gray levels of line art pages encoded as PNG
+	lineArtLevels = 16
+)
+
+// BudgetOptions limits size of encoded pages by choosing the highest JPEG quality fitting it.
+// Line art pages are encoded as PNG with quantized levels when it's smaller.
+// Budget can only be used with JPEG encoding.
+type BudgetOptions struct {
+	// Maximum size of page in bytes
+	PageBytes int64
+	// Maximum size of book in bytes, shared equally between pages the book is transformed into
+	// by TransformComicBook
+	BookBytes int64
+	// Range of JPEG quality to search in. Default: DefaultMinJpegQuality, DefaultMaxJpegQuality.
+	// Pages are encoded with MinQuality if even it doesn't fit.
+	MinQuality, MaxQuality int
+}
+
+func (o *BudgetOptions) validate(encoding string) error {
+	if o.PageBytes < 0 || o.BookBytes < 0 {
+		return fmt.Errorf("size budget must not be negative")
+	}
+	if (o.PageBytes > 0 || o.BookBytes > 0) && !isJPEG(encoding) {
+		return fmt.Errorf("size budget can only be used with jpeg encoding, got %q", encoding)
+	}
+	if o.MinQuality < 0 || o.MaxQuality > 100 || (o.MaxQuality > 0 && o.MinQuality > o.MaxQuality) {
+		return fmt.Errorf("invalid jpeg quality range [%d, %d]", o.MinQuality, o.MaxQuality)
+	}
+	return nil
+}
+
+// bookPageBytes returns page budget with book budget shared between pages
+func (o *BudgetOptions) bookPageBytes(pages int) int64 {
+	if o.BookBytes <= 0 || pages == 0 {
+		return o.PageBytes
+	}
+	share := o.BookBytes / int64(pages)
+	if o.PageBytes > 0 {
+		return min(o.PageBytes, share)
+	}
+	return share
+}
+
+// estimateOutputPages returns number of pages the book is transformed into:
+// split spreads make several pages and strips are sliced into pages of screen height
+func estimateOutputPages(cb *comicbook.ComicBook, opts *Options) int {
+	total := 0
+	for _, chapter := range cb.ChapterPages() {
+		var story []*comicbook.Page
+		for _, p := range chapter {
+			if p.Kind == comicbook.PageKindStory {
+				story = append(story, p)
+			} else {
+				total++
+			}
+		}
+		if len(story) == 0 {
+			continue
+		}
+		if isStripChapter(story, opts) {
+			total += estimateStripPages(story, opts)
+			continue
+		}
+		for _, p := range story {
+			total += estimatePages(p, opts)
+		}
+	}
+	return total
+}
+
+// estimatePages returns number of pages page is laid out into
+func estimatePages(p *comicbook.Page, opts *Options) int {
+	if opts.spreadMode() != SpreadSplit || !opts.pipeline().has("spread") {
+		return 1
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(p.Data))
+	if err != nil || config.Width <= config.Height {
+		return 1
+	}
+	if opts.KeepSpread {
+		return 3
+	}
+	return 2
+}
+
+// estimateStripPages returns number of pages strip is sliced into
+func estimateStripPages(pages []*comicbook.Page, opts *Options) int {
+	maxUpscale := opts.MaxUpscale
+	if maxUpscale <= 0 {
+		maxUpscale = DefaultMaxUpscale
+	}
+
+	height := 0.0
+	for _, p := range pages {
+		config, _, err := image.DecodeConfig(bytes.NewReader(p.Data))
+		if err != nil || config.Width == 0 {
+			// broken page is replaced with page of screen size
+			height += float64(opts.Height)
+			continue
+		}
+		width := min(float64(opts.Width), float64(config.Width)*maxUpscale)
+		height += float64(config.Height) * width / float64(config.Width)
+	}
+	return max(1, int(math.Ceil(height/float64(opts.Height))))
+}
+
+func (o *BudgetOptions) qualityRange() (int, int) {
+	lo, hi := o.MinQuality, o.MaxQuality
+	if lo <= 0 {
+		lo = DefaultMinJpegQuality
+	}
+	if hi <= 0 {
+		hi = DefaultMaxJpegQuality
+	}
+	return lo, max(lo, hi)
+}
+
+// encodedPage is an encoded image of page
+type encodedPage struct {
+	data     []byte
+	encoding string
+	// JPEG quality, 0 for PNG
+	quality int
+}
+
+// encodeBudgeted encodes page, fitting it into budget if it's set.
+// Grayscale version of page is used to detect line art, it's nil for color pages.
+func encodeBudgeted(img image.Image, gray *image.Gray, opts *Options) (encodedPage, error) {
+	if opts.Budget.PageBytes <= 0 {
+		quality := 0
+		if isJPEG(opts.Encoding) {
+			quality = opts.jpegQuality()
+		}
+		data, err := encodeImage(img, opts.Encoding, quality)
+		return encodedPage{data: data, encoding: opts.Encoding, quality: quality}, err
+	}
+
+	page, err := encodeFittingJPEG(img, opts)
+	if err != nil {
+		return page, err
+	}
+
+	if gray != nil && isLineArt(gray) {
+		data, err := encodeImage(quantize(gray, lineArtLevels, opts.dither()), "png", 0)
+		if err != nil {
+			return page, err
+		}
+		if len(data) < len(page.data) {
+			return encodedPage{data: data, encoding: "png"}, nil
+		}
+	}
+
+	return page, nil
+}
+
+// encodeFittingJPEG encodes image with the highest quality fitting page budget
+func encodeFittingJPEG(img image.Image, opts *Options) (encodedPage, error) {
+	minQuality, maxQuality := opts.Budget.qualityRange()
+	budget := int(opts.Budget.PageBytes)
+	encode := func(quality int) (encodedPage, error) {
+		data, err := encodeImage(img, opts.Encoding, quality)
+		return encodedPage{data: data, encoding: opts.Encoding, quality: quality}, err
+	}
+
+	// most pages fit with the highest quality
+	best, err := encode(maxQuality)
+	if err != nil || len(best.data) <= budget {
+		return best, err
+	}
+
+	// the lowest quality is used if nothing fits
+	best, err = encode(minQuality)
+	if err != nil || len(best.data) > budget {
+		return best, err
+	}
+
+	// best fits with quality lo, search for higher one up to hi
+	lo, hi := minQuality, maxQuality-1
+	for lo < hi {
+		quality := (lo + hi + 1) / 2
+		page, err := encode(quality)
+		if err != nil {
+			return best, err
+		}
+		if len(page.data) <= budget {
+			best, lo = page, quality
+		} else {
+			hi = quality - 1
+		}
+	}
+	return best, nil
+}
+
+// isLineArt reports if grayscale image is almost all black and white
+func isLineArt(gray *image.Gray) bool {
+	bounds := gray.Bounds()
+	total, bw := 0, 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := gray.Pix[gray.PixOffset(bounds.Min.X, y):gray.PixOffset(bounds.Max.X, y)]
+		for _, v := range row {
+			total++
+			if v < lineArtTolerance || v > 255-lineArtTolerance {
+				bw++
+			}
+		}
+	}
+	return total > 0 && float64(bw) >= lineArtShare*float64(total)
+}
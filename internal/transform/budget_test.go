@@ -0,0 +1,217 @@
+package transform
+
+import (
+	"context"
+	"image"
+	"math/rand"
+	"testing"
+
+	"github.com/abbit/m4k/internal/comicbook"
+)
+
+// noiseImage returns grayscale image of random brightness, which compresses badly
+func noiseImage(width, height int) *image.Gray {
+	random := rand.New(rand.NewSource(1))
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(64 + random.Intn(128))
+	}
+	return img
+}
+
+func TestEncodeFittingJPEG(t *testing.T) {
+	img := noiseImage(100, 100)
+	size := func(quality int) int {
+		data, err := encodeImage(img, "jpg", quality)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(data)
+	}
+	// budget between the lowest and the highest quality
+	budget := (size(DefaultMinJpegQuality) + size(DefaultMaxJpegQuality)) / 2
+
+	tests := []struct {
+		name        string
+		budget      BudgetOptions
+		wantQuality int
+	}{
+		{"fits with the highest quality", BudgetOptions{PageBytes: 1 << 20}, DefaultMaxJpegQuality},
+		{"custom highest quality", BudgetOptions{PageBytes: 1 << 20, MaxQuality: 80}, 80},
+		{"doesn't fit with the lowest quality", BudgetOptions{PageBytes: 100}, DefaultMinJpegQuality},
+		{"custom lowest quality", BudgetOptions{PageBytes: 100, MinQuality: 10}, 10},
+		// the highest quality fitting budget is searched below
+		{"fits with lower quality", BudgetOptions{PageBytes: int64(budget)}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := encodeFittingJPEG(img, &Options{Encoding: "jpg", Budget: tt.budget})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if page.encoding != "jpg" || len(page.data) != size(page.quality) {
+				t.Fatalf("page is %s of %d bytes with quality %d", page.encoding, len(page.data), page.quality)
+			}
+			if tt.wantQuality > 0 {
+				if page.quality != tt.wantQuality {
+					t.Errorf("quality is %d, want %d", page.quality, tt.wantQuality)
+				}
+				return
+			}
+			if len(page.data) > budget {
+				t.Errorf("page of %d bytes doesn't fit budget of %d bytes", len(page.data), budget)
+			}
+			if size(page.quality+1) <= budget {
+				t.Errorf("quality %d isn't the highest fitting budget", page.quality)
+			}
+		})
+	}
+}
+
+func TestEncodeBudgeted(t *testing.T) {
+	lineArt := pageImage(255, 0, image.Rect(20, 30, 180, 270), image.Rect(50, 50, 60, 250))
+	tests := []struct {
+		name         string
+		img          *image.Gray
+		gray         bool
+		budget       int64
+		wantEncoding string
+	}{
+		{"no budget", lineArt, true, 0, "jpg"},
+		{"line art", lineArt, true, 1 << 20, "png"},
+		{"color page", lineArt, false, 1 << 20, "jpg"},
+		{"photo", noiseImage(200, 300), true, 1 << 20, "jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gray *image.Gray
+			if tt.gray {
+				gray = tt.img
+			}
+			opts := &Options{Encoding: "jpg", Budget: BudgetOptions{PageBytes: tt.budget}}
+			page, err := encodeBudgeted(tt.img, gray, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if page.encoding != tt.wantEncoding {
+				t.Errorf("page is encoded as %s, want %s", page.encoding, tt.wantEncoding)
+			}
+		})
+	}
+}
+
+func TestIsLineArt(t *testing.T) {
+	tests := []struct {
+		name string
+		img  *image.Gray
+		want bool
+	}{
+		{"black and white", pageImage(255, 0, image.Rect(20, 30, 180, 270)), true},
+		{"nearly black and white", pageImage(240, 20, image.Rect(20, 30, 180, 270)), true},
+		{"screentone", pageImage(255, 128, image.Rect(20, 30, 180, 270)), false},
+		{"photo", noiseImage(100, 100), false},
+		{"empty", image.NewGray(image.Rect(0, 0, 0, 0)), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isLineArt(tt.img); got != tt.want {
+				t.Errorf("isLineArt = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBookPageBytes(t *testing.T) {
+	tests := []struct {
+		budget BudgetOptions
+		pages  int
+		want   int64
+	}{
+		{BudgetOptions{PageBytes: 100}, 10, 100},
+		{BudgetOptions{BookBytes: 1000}, 10, 100},
+		{BudgetOptions{BookBytes: 1000}, 0, 0},
+		{BudgetOptions{PageBytes: 50, BookBytes: 1000}, 10, 50},
+		{BudgetOptions{PageBytes: 500, BookBytes: 1000}, 10, 100},
+	}
+	for _, tt := range tests {
+		if got := tt.budget.bookPageBytes(tt.pages); got != tt.want {
+			t.Errorf("budget %+v shared between %d pages is %d, want %d", tt.budget, tt.pages, got, tt.want)
+		}
+	}
+}
+
+func TestEstimateOutputPages(t *testing.T) {
+	cb := testBook(t, grayImage(100, 200, 0), spreadImage(), grayImage(100, 200, 0))
+	cb.Pages[0].Kind = comicbook.PageKindCover
+	tests := []struct {
+		name string
+		opts Options
+		want int
+	}{
+		{"double", Options{}, 3},
+		{"split", Options{Spread: SpreadSplit}, 4},
+		{"split keeping spread", Options{Spread: SpreadSplit, KeepSpread: true}, 5},
+		// story pages are 25 + 100 px high strip of screen width, sliced into 30 px pages
+		{"strip", Options{Strip: StripOn}, 1 + 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.Width, opts.Height = 50, 30
+			if got := estimateOutputPages(cb, &opts); got != tt.want {
+				t.Errorf("estimated %d pages, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformComicBookBudget(t *testing.T) {
+	images := make([]image.Image, 4)
+	for i := range images {
+		images[i] = noiseImage(200, 200)
+	}
+	cb := testBook(t, images...)
+
+	// pages can fit only with quality lower than the highest
+	var bookBytes int
+	for _, quality := range []int{DefaultMinJpegQuality, DefaultMaxJpegQuality} {
+		data, err := encodeImage(images[0], "jpg", quality)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bookBytes += len(images) * len(data) / 2
+	}
+	opts := &Options{Width: 200, Height: 200, Encoding: "jpg", Budget: BudgetOptions{BookBytes: int64(bookBytes)}}
+	if err := TransformComicBook(context.Background(), cb, opts); err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, p := range cb.Pages {
+		total += len(p.Data)
+	}
+	if total > bookBytes {
+		t.Errorf("book is %d bytes, budget is %d bytes", total, bookBytes)
+	}
+}
+
+func TestBudgetOptionsValidate(t *testing.T) {
+	tests := []struct {
+		budget   BudgetOptions
+		encoding string
+		wantErr  bool
+	}{
+		{BudgetOptions{}, "png", false},
+		{BudgetOptions{PageBytes: 100}, "jpg", false},
+		{BudgetOptions{BookBytes: 100, MinQuality: 20, MaxQuality: 90}, "jpeg", false},
+		{BudgetOptions{PageBytes: 100}, "png", true},
+		{BudgetOptions{BookBytes: 100}, "png", true},
+		{BudgetOptions{PageBytes: -1}, "jpg", true},
+		{BudgetOptions{MinQuality: 90, MaxQuality: 20}, "jpg", true},
+		{BudgetOptions{MaxQuality: 101}, "jpg", true},
+	}
+	for _, tt := range tests {
+		if err := tt.budget.validate(tt.encoding); (err != nil) != tt.wantErr {
+			t.Errorf("validate(%+v, %s) = %v", tt.budget, tt.encoding, err)
+		}
+	}
+}
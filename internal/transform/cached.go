@@ -0,0 +1,69 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"image/color"
+
+	"github.com/abbit/m4k/internal/cache"
+)
+
+// cacheVersion is increased when transformation changes, so pages cached before aren't used
+const cacheVersion = 2
+
+// cachedFrame is encoded frame stored in cache
+type cachedFrame struct {
+	Data     []byte
+	Encoding string
+	Quality  int
+	Color    bool
+}
+
+// cacheKey returns key of page transformed with options.
+// Options not affecting result, like callback, aren't a part of key.
+func (opts *Options) cacheKey(data []byte) string {
+	padColor := "none"
+	if opts.PadColor != nil {
+		padColor = fmt.Sprint(color.RGBAModel.Convert(opts.PadColor))
+	}
+	// book budget is shared into page budget already
+	budget := opts.Budget
+	budget.BookBytes = 0
+
+	canonical := fmt.Sprintf("v%d|size=%dx%d|encoding=%s|quality=%d|budget=%+v|spread=%s|keep-spread=%t|rtl=%t"+
+		"|fit=%s|pad=%t|pad-color=%s|max-upscale=%g|crop=%+v|tone=%+v|levels=%d|dither=%s|color=%+v|pipeline=%s",
+		cacheVersion, opts.Width, opts.Height, opts.Encoding, opts.jpegQuality(), budget, opts.spreadMode(), opts.KeepSpread, opts.RightToLeft,
+		opts.fitMode(), opts.Pad, padColor, opts.MaxUpscale, opts.Crop, opts.Tone, opts.Levels, opts.dither(), opts.Color, opts.pipeline())
+	return cache.Key(data, []byte(canonical))
+}
+
+func loadCachedFrames(c *cache.Cache, key string) ([]*Frame, bool) {
+	data, ok := c.Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	var cached []cachedFrame
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&cached); err != nil {
+		return nil, false
+	}
+	frames := make([]*Frame, 0, len(cached))
+	for _, f := range cached {
+		frames = append(frames, &Frame{Data: f.Data, Encoding: f.Encoding, Quality: f.Quality, Color: f.Color})
+	}
+	return frames, true
+}
+
+func storeCachedFrames(c *cache.Cache, key string, frames []*Frame) error {
+	cached := make([]cachedFrame, 0, len(frames))
+	for _, f := range frames {
+		cached = append(cached, cachedFrame{Data: f.Data, Encoding: f.Encoding, Quality: f.Quality, Color: f.Color})
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(cached); err != nil {
+		return err
+	}
+	return c.Put(key, buf.Bytes())
+}
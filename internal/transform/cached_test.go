@@ -0,0 +1,67 @@
+package transform
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/abbit/m4k/internal/cache"
+)
+
+func TestTransformComicBookCache(t *testing.T) {
+	c, err := cache.Open(t.TempDir(), cache.DefaultMaxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// transform returns pages of book and how many of them are taken from cache
+	transform := func(opts *Options) ([][]byte, int) {
+		cb := testBook(t, grayImage(100, 200, 128), spreadImage())
+		var mu sync.Mutex
+		cached := 0
+		opts.Cache = c
+		opts.Callback = func(e Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			if e.Kind == EventPageFinished && e.Cached {
+				cached++
+			}
+		}
+		if err := TransformComicBook(context.Background(), cb, opts); err != nil {
+			t.Fatal(err)
+		}
+		var pages [][]byte
+		for _, p := range cb.Pages {
+			pages = append(pages, p.Data)
+		}
+		return pages, cached
+	}
+
+	opts := func() *Options {
+		return &Options{Width: 100, Height: 200, Encoding: "png", Spread: SpreadSplit}
+	}
+	first, cached := transform(opts())
+	if cached != 0 {
+		t.Errorf("%d pages are taken from empty cache", cached)
+	}
+	second, cached := transform(opts())
+	if cached != 2 {
+		t.Errorf("%d pages are taken from cache, want 2", cached)
+	}
+	if len(first) != 3 || len(second) != len(first) {
+		t.Fatalf("got %d and %d pages, want 3", len(first), len(second))
+	}
+	for i := range first {
+		if !bytes.Equal(first[i], second[i]) {
+			t.Errorf("cached page %d differs", i)
+		}
+	}
+
+	// pages transformed with other options aren't taken from cache
+	other := opts()
+	other.Spread = SpreadRotate
+	if _, cached := transform(other); cached != 0 {
+		t.Errorf("%d pages transformed with other options are taken from cache", cached)
+	}
+}
@@ -0,0 +1,111 @@
+package transform
+
+import (
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// minimum chroma difference from page tint for pixel to be colored
+	DefaultColorThreshold = 24
+	// minimum share of colored pixels for page to be a color page
+	DefaultMinColorShare = 0.01
+	// number of pixels sampled to detect color
+	colorSamples = 100_000
+	// maximum chroma difference of paper tint from gray, more tinted pages are mostly in color
+	maxPaperTint = 48
+)
+
+// ColorOptions configures keeping color pages for color screens.
+// Tone mapping and quantization are only done for grayscale pages.
+type ColorOptions struct {
+	// Keep pages having significant color in color, the rest are grayscaled
+	Enabled bool `json:"-"`
+	// Saturation change of color pages in percents, from -100 to 100.
+	// Color e-ink is pale, so saturation is usually increased.
+	Saturation float64 `json:"saturation,omitempty"`
+	// Gamma correction of color pages, values below 1 darken midtones. 0 or 1 disables it
+	Gamma float64 `json:"gamma,omitempty"`
+	// Minimum chroma difference from page tint for pixel to be colored. Default: DefaultColorThreshold
+	Threshold int `json:"threshold,omitempty"`
+	// Minimum share of colored pixels for page to be a color page. Default: DefaultMinColorShare
+	MinShare float64 `json:"minShare,omitempty"`
+}
+
+// isColorImage reports if image has significant color.
+// Tint of whole page, like yellowed paper of scans, isn't color, neither is neutral ink on it.
+func isColorImage(img image.Image, o *ColorOptions) bool {
+	threshold := o.Threshold
+	if threshold <= 0 {
+		threshold = DefaultColorThreshold
+	}
+	minShare := o.MinShare
+	if minShare <= 0 {
+		minShare = DefaultMinColorShare
+	}
+
+	bounds := img.Bounds()
+	step := max(1, int(math.Sqrt(float64(bounds.Dx()*bounds.Dy())/colorSamples)))
+
+	type chroma struct{ cb, cr int }
+	var samples []chroma
+	var cbHistogram, crHistogram [256]int
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			r, g, b, _ := img.At(x, y).RGBA()
+			// chroma components of YCbCr, centered at 128
+			cb := int(128 + (-11056*int(r>>8)-21712*int(g>>8)+32768*int(b>>8))/65536)
+			cr := int(128 + (32768*int(r>>8)-27440*int(g>>8)-5328*int(b>>8))/65536)
+			samples = append(samples, chroma{cb, cr})
+			cbHistogram[cb]++
+			crHistogram[cr]++
+		}
+	}
+	if len(samples) == 0 {
+		return false
+	}
+
+	tintCb, tintCr := histogramMedian(&cbHistogram, len(samples)), histogramMedian(&crHistogram, len(samples))
+	if abs(tintCb-128)+abs(tintCr-128) > maxPaperTint {
+		tintCb, tintCr = 128, 128
+	}
+	colored := 0
+	for _, s := range samples {
+		if abs(s.cb-tintCb)+abs(s.cr-tintCr) > threshold && abs(s.cb-128)+abs(s.cr-128) > threshold {
+			colored++
+		}
+	}
+	return float64(colored) >= minShare*float64(len(samples))
+}
+
+func histogramMedian(histogram *[256]int, total int) int {
+	count := 0
+	for v, n := range histogram {
+		count += n
+		if 2*count >= total {
+			return v
+		}
+	}
+	return 255
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// adjustColor tunes saturation and gamma of color page
+func adjustColor(img image.Image, o *ColorOptions) *image.NRGBA {
+	result := imaging.Clone(img)
+	if o.Saturation != 0 {
+		result = imaging.AdjustSaturation(result, o.Saturation)
+	}
+	if o.Gamma > 0 && o.Gamma != 1 {
+		result = imaging.AdjustGamma(result, o.Gamma)
+	}
+	return result
+}
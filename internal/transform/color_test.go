@@ -0,0 +1,136 @@
+package transform
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"sync"
+	"testing"
+
+	"github.com/abbit/m4k/internal/comicbook"
+)
+
+// tintedImage returns 100x100 page of color with rectangles of another color
+func tintedImage(background, content color.Color, rects ...image.Rectangle) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			img.Set(x, y, background)
+			for _, r := range rects {
+				if image.Pt(x, y).In(r) {
+					img.Set(x, y, content)
+				}
+			}
+		}
+	}
+	return img
+}
+
+func TestIsColorImage(t *testing.T) {
+	var (
+		white = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+		black = color.NRGBA{A: 255}
+		sepia = color.NRGBA{R: 240, G: 225, B: 190, A: 255}
+		red   = color.NRGBA{R: 220, G: 30, B: 30, A: 255}
+		// barely tinted gray
+		pinkish = color.NRGBA{R: 135, G: 125, B: 125, A: 255}
+	)
+	tests := []struct {
+		name string
+		img  image.Image
+		opts ColorOptions
+		want bool
+	}{
+		{"grayscale", pageImage(255, 0, image.Rect(20, 30, 180, 270)), ColorOptions{}, false},
+		{"black and white", tintedImage(white, black, image.Rect(10, 10, 90, 90)), ColorOptions{}, false},
+		{"tinted paper", tintedImage(sepia, black, image.Rect(10, 10, 40, 40)), ColorOptions{}, false},
+		{"color", tintedImage(white, red, image.Rect(10, 10, 90, 90)), ColorOptions{}, true},
+		{"color on tinted paper", tintedImage(sepia, red, image.Rect(10, 10, 30, 30)), ColorOptions{}, true},
+		{"speck of color", tintedImage(white, red, image.Rect(0, 0, 5, 1)), ColorOptions{}, false},
+		{"speck of color with lower share", tintedImage(white, red, image.Rect(0, 0, 5, 1)), ColorOptions{MinShare: 0.0001}, true},
+		{"slight tint", tintedImage(white, pinkish, image.Rect(10, 10, 40, 40)), ColorOptions{}, false},
+		{"slight tint with lower threshold", tintedImage(white, pinkish, image.Rect(10, 10, 40, 40)), ColorOptions{Threshold: 5}, true},
+		{"empty", image.NewNRGBA(image.Rect(0, 0, 0, 0)), ColorOptions{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isColorImage(tt.img, &tt.opts); got != tt.want {
+				t.Errorf("isColorImage = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestColorStage(t *testing.T) {
+	red := color.NRGBA{R: 200, G: 60, B: 60, A: 255}
+	colorPage := tintedImage(color.White, red, image.Rect(10, 10, 90, 90))
+	tests := []struct {
+		name      string
+		img       image.Image
+		opts      ColorOptions
+		wantColor bool
+	}{
+		{"disabled", colorPage, ColorOptions{}, false},
+		{"color page", colorPage, ColorOptions{Enabled: true}, true},
+		{"grayscale page", grayImage(100, 100, 128), ColorOptions{Enabled: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := &Frame{Image: tt.img}
+			if err := colorStage(frame, &Options{Color: tt.opts}); err != nil {
+				t.Fatal(err)
+			}
+			if frame.Color != tt.wantColor {
+				t.Errorf("page is kept in color: %v, want %v", frame.Color, tt.wantColor)
+			}
+		})
+	}
+
+	// saturation is increased
+	frame := &Frame{Image: colorPage}
+	if err := colorStage(frame, &Options{Color: ColorOptions{Enabled: true, Saturation: 50}}); err != nil {
+		t.Fatal(err)
+	}
+	r, g, _, _ := frame.Image.At(50, 50).RGBA()
+	if r>>8 <= 200 || g>>8 >= 60 {
+		t.Errorf("saturated color is %v, want more saturated than %v", frame.Image.At(50, 50), red)
+	}
+}
+
+func TestTransformComicBookColorPages(t *testing.T) {
+	red := color.NRGBA{R: 200, G: 60, B: 60, A: 255}
+	cb := testBook(t,
+		grayImage(100, 100, 128),
+		tintedImage(color.White, red, image.Rect(10, 10, 90, 90)),
+		grayImage(100, 100, 64),
+	)
+
+	var mu sync.Mutex
+	colorPages := 0
+	opts := &Options{Width: 100, Height: 100, Encoding: "png", Color: ColorOptions{Enabled: true}}
+	opts.Callback = func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, r := range e.Results {
+			if r.Color {
+				colorPages++
+			}
+		}
+	}
+	if err := TransformComicBook(context.Background(), cb, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if colorPages != 1 {
+		t.Errorf("%d color pages are reported, want 1", colorPages)
+	}
+	for i, p := range cb.Pages {
+		_, gray := decodePage(t, p).(*image.Gray)
+		if gray == (i == 1) {
+			t.Errorf("page %d is grayscale: %v", i, gray)
+		}
+	}
+	if cb.Pages[1].Kind != comicbook.PageKindStory {
+		t.Errorf("color page has kind %v", cb.Pages[1].Kind)
+	}
+}
@@ -0,0 +1,213 @@
+package transform
+
+import (
+	"image"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	DefaultCropTolerance      = 24
+	DefaultCropMinContentArea = 0.002
+	DefaultCropMaxTrim        = 0.15
+	// share of edge pixels which should match margin color for edge to be a margin
+	cropUniformEdge = 0.98
+	// part of page size content should span to never be treated as page number or speck
+	cropMaxNoiseExtent = 4
+)
+
+// CropOptions configures automatic cropping of uniform margins
+type CropOptions struct {
+	Enabled bool
+	// Maximum difference in brightness from margin color for pixel to be a part of margin, 0-255.
+	// Default: DefaultCropTolerance
+	Tolerance int
+	// Content at the edges smaller than this part of page area, like page numbers
+	// and specks, is cropped with margins. Default: DefaultCropMinContentArea
+	MinContentArea float64
+	// Maximum part of width or height trimmed from each side. Default: DefaultCropMaxTrim
+	MaxTrim float64
+}
+
+func (o *CropOptions) withDefaults() CropOptions {
+	opts := *o
+	if opts.Tolerance <= 0 {
+		opts.Tolerance = DefaultCropTolerance
+	}
+	if opts.MinContentArea <= 0 {
+		opts.MinContentArea = DefaultCropMinContentArea
+	}
+	if opts.MaxTrim <= 0 {
+		opts.MaxTrim = DefaultCropMaxTrim
+	}
+	return opts
+}
+
+// cropMargins crops uniform white or black margins of image.
+// Image is left as is if margins can't be found.
+func cropMargins(img image.Image, o *CropOptions) image.Image {
+	if !o.Enabled {
+		return img
+	}
+	opts := o.withDefaults()
+
+	// grayscale images are read as is, others are converted
+	var bounds image.Rectangle
+	var lum func(x, y int) int
+	gray, isGray := img.(*image.Gray)
+	if isGray {
+		bounds = gray.Bounds()
+		lum = func(x, y int) int {
+			return int(gray.Pix[gray.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)])
+		}
+	} else {
+		nrgba := imaging.Grayscale(img)
+		bounds = nrgba.Bounds()
+		lum = func(x, y int) int {
+			return int(nrgba.Pix[nrgba.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)])
+		}
+	}
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 3 || height < 3 {
+		return img
+	}
+
+	background := edgeMedian(width, height, lum)
+	isContent := func(x, y int) bool {
+		diff := lum(x, y) - background
+		return diff > opts.Tolerance || -diff > opts.Tolerance
+	}
+
+	// sides with content at the edge can't be cropped
+	uniform := func(n int, content func(i int) bool) bool {
+		count := 0
+		for i := 0; i < n; i++ {
+			if content(i) {
+				count++
+			}
+		}
+		return float64(count) <= float64(n)*(1-cropUniformEdge)
+	}
+	cropTop := uniform(width, func(x int) bool { return isContent(x, 0) })
+	cropBottom := uniform(width, func(x int) bool { return isContent(x, height-1) })
+	cropLeft := uniform(height, func(y int) bool { return isContent(0, y) })
+	cropRight := uniform(height, func(y int) bool { return isContent(width-1, y) })
+	if !cropTop && !cropBottom && !cropLeft && !cropRight {
+		return img
+	}
+
+	minArea := opts.MinContentArea * float64(width*height)
+
+	rows := contentBounds(height, width, minArea, func(y, x int) bool { return isContent(x, y) })
+	if rows.empty() {
+		return img
+	}
+	cols := contentBounds(width, rows.end-rows.start, minArea, func(x, y int) bool { return isContent(x, rows.start+y) })
+	if cols.empty() {
+		return img
+	}
+
+	maxTrimX := int(opts.MaxTrim * float64(width))
+	maxTrimY := int(opts.MaxTrim * float64(height))
+	crop := image.Rect(0, 0, width, height)
+	if cropTop {
+		crop.Min.Y = min(rows.start, maxTrimY)
+	}
+	if cropBottom {
+		crop.Max.Y = max(rows.end, height-maxTrimY)
+	}
+	if cropLeft {
+		crop.Min.X = min(cols.start, maxTrimX)
+	}
+	if cropRight {
+		crop.Max.X = max(cols.end, width-maxTrimX)
+	}
+	if crop.Eq(image.Rect(0, 0, width, height)) {
+		return img
+	}
+
+	if isGray {
+		return gray.SubImage(crop.Add(bounds.Min))
+	}
+	return imaging.Crop(img, crop.Add(bounds.Min))
+}
+
+// edgeMedian returns median brightness of edge pixels
+func edgeMedian(width, height int, lum func(x, y int) int) int {
+	values := make([]int, 0, 2*(width+height))
+	for x := 0; x < width; x++ {
+		values = append(values, lum(x, 0), lum(x, height-1))
+	}
+	for y := 0; y < height; y++ {
+		values = append(values, lum(0, y), lum(width-1, y))
+	}
+	sort.Ints(values)
+	return values[len(values)/2]
+}
+
+type span struct {
+	start, end int
+}
+
+func (s span) empty() bool {
+	return s.end <= s.start
+}
+
+// band is a run of lines having content
+type band struct {
+	span
+	// number of content pixels
+	pixels int
+	// first and last content pixel across lines
+	extent span
+}
+
+// contentBounds returns span of lines having content.
+// Small bands at the edges, like page numbers and specks, are skipped.
+func contentBounds(lines, length int, minArea float64, isContent func(line, i int) bool) span {
+	// single pixels in line are noise
+	minLinePixels := max(1, length/500)
+
+	var bands []band
+	var current *band
+	for line := 0; line < lines; line++ {
+		pixels := 0
+		extent := span{start: length}
+		for i := 0; i < length; i++ {
+			if isContent(line, i) {
+				pixels++
+				extent.start = min(extent.start, i)
+				extent.end = i + 1
+			}
+		}
+
+		if pixels < minLinePixels {
+			current = nil
+			continue
+		}
+		if current == nil {
+			bands = append(bands, band{span: span{start: line}, extent: extent})
+			current = &bands[len(bands)-1]
+		}
+		current.end = line + 1
+		current.pixels += pixels
+		current.extent.start = min(current.extent.start, extent.start)
+		current.extent.end = max(current.extent.end, extent.end)
+	}
+
+	isNoise := func(b band) bool {
+		return float64(b.pixels) < minArea && (b.extent.end-b.extent.start)*cropMaxNoiseExtent < length
+	}
+	for len(bands) > 1 && isNoise(bands[0]) {
+		bands = bands[1:]
+	}
+	for len(bands) > 1 && isNoise(bands[len(bands)-1]) {
+		bands = bands[:len(bands)-1]
+	}
+	if len(bands) == 0 || (len(bands) == 1 && isNoise(bands[0])) {
+		return span{}
+	}
+
+	return span{start: bands[0].start, end: bands[len(bands)-1].end}
+}
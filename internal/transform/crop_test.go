@@ -0,0 +1,206 @@
+package transform
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/abbit/m4k/internal/comicbook"
+)
+
+// fillGray fills rectangle of image with level
+func fillGray(img *image.Gray, r image.Rectangle, level uint8) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.Pix[img.PixOffset(x, y)] = level
+		}
+	}
+}
+
+// pageImage returns 200x300 page of background level with content rectangles of content level
+func pageImage(background, content uint8, rects ...image.Rectangle) *image.Gray {
+	img := grayImage(200, 300, background)
+	for _, r := range rects {
+		fillGray(img, r, content)
+	}
+	return img
+}
+
+func TestCropMargins(t *testing.T) {
+	content := image.Rect(20, 30, 180, 270)
+	tests := []struct {
+		name string
+		img  *image.Gray
+		opts CropOptions
+		want image.Rectangle
+	}{
+		{
+			name: "white margins",
+			img:  pageImage(255, 0, content),
+			want: content,
+		},
+		{
+			name: "black margins",
+			img:  pageImage(0, 255, content),
+			want: content,
+		},
+		{
+			name: "margins within tolerance",
+			img: func() *image.Gray {
+				img := pageImage(255, 0, content)
+				fillGray(img, image.Rect(20, 10, 180, 20), 240)
+				return img
+			}(),
+			want: content,
+		},
+		{
+			name: "margins out of tolerance",
+			img: func() *image.Gray {
+				img := pageImage(255, 0, content)
+				fillGray(img, image.Rect(20, 10, 180, 20), 240)
+				return img
+			}(),
+			opts: CropOptions{Tolerance: 10},
+			want: image.Rect(20, 10, 180, 270),
+		},
+		{
+			name: "trim is limited",
+			img:  pageImage(255, 0, image.Rect(60, 80, 140, 220)),
+			// 15% of width and height
+			want: image.Rect(30, 45, 170, 255),
+		},
+		{
+			name: "trim limit is configurable",
+			img:  pageImage(255, 0, image.Rect(60, 80, 140, 220)),
+			opts: CropOptions{MaxTrim: 0.5},
+			want: image.Rect(60, 80, 140, 220),
+		},
+		{
+			name: "page number is cropped",
+			img:  pageImage(255, 0, content, image.Rect(95, 282, 105, 290)),
+			want: content,
+		},
+		{
+			name: "speck is cropped",
+			img:  pageImage(255, 0, content, image.Rect(5, 5, 8, 8)),
+			want: content,
+		},
+		{
+			name: "content larger than minimum area is kept",
+			img:  pageImage(255, 0, content, image.Rect(95, 282, 105, 290)),
+			opts: CropOptions{MinContentArea: 0.001},
+			want: image.Rect(20, 30, 180, 290),
+		},
+		{
+			name: "content at the edge",
+			img:  pageImage(255, 0, image.Rect(0, 30, 180, 270)),
+			want: image.Rect(0, 30, 180, 270),
+		},
+		{
+			name: "content at all edges",
+			img:  pageImage(255, 0, image.Rect(0, 0, 200, 300)),
+			want: image.Rect(0, 0, 200, 300),
+		},
+		{
+			name: "blank page",
+			img:  grayImage(200, 300, 255),
+			want: image.Rect(0, 0, 200, 300),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.Enabled = true
+			if got := cropMargins(tt.img, &opts).Bounds(); got != tt.want {
+				t.Errorf("cropped to %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCropMarginsColor(t *testing.T) {
+	gray := pageImage(255, 0, image.Rect(20, 30, 180, 270))
+	img := image.NewNRGBA(gray.Bounds())
+	for i, v := range gray.Pix {
+		copy(img.Pix[4*i:], []uint8{v, v, v, 0xff})
+	}
+	if got := cropMargins(img, &CropOptions{Enabled: true}).Bounds().Size(); got != image.Pt(160, 240) {
+		t.Errorf("cropped to %v, want (160,240)", got)
+	}
+}
+
+func TestCropStage(t *testing.T) {
+	opts := &Options{Crop: CropOptions{Enabled: true}}
+	tests := []struct {
+		name  string
+		frame Frame
+		want  image.Point
+	}{
+		{"page", Frame{}, image.Pt(160, 240)},
+		{"generated page", Frame{Generated: true}, image.Pt(200, 300)},
+		{"strip slice", Frame{Strip: true}, image.Pt(200, 300)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := tt.frame
+			frame.Image = pageImage(255, 0, image.Rect(20, 30, 180, 270))
+			if err := cropStage(&frame, opts); err != nil {
+				t.Fatal(err)
+			}
+			if got := frame.Image.Bounds().Size(); got != tt.want {
+				t.Errorf("cropped to %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	frame := &Frame{Image: pageImage(255, 0, image.Rect(20, 30, 180, 270))}
+	if err := cropStage(frame, &Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := frame.Image.Bounds().Size(); got != image.Pt(200, 300) {
+		t.Errorf("disabled crop cropped page to %v", got)
+	}
+}
+
+// testPage returns page of the first chapter with given image data
+func testPage(data []byte, kind comicbook.PageKind) *comicbook.Page {
+	return &comicbook.Page{
+		Data:        data,
+		Extension:   ".png",
+		Kind:        kind,
+		ChapterInfo: &comicbook.ChapterInfo{Name: "Test", Number: 1, Volume: 1},
+	}
+}
+
+func TestTransformPageCrop(t *testing.T) {
+	data, err := encodeImage(pageImage(255, 0, image.Rect(20, 30, 180, 270)), "png", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &Options{Width: 200, Height: 300, Encoding: "png", MaxUpscale: 1, Crop: CropOptions{Enabled: true}}
+	tests := []struct {
+		name string
+		kind comicbook.PageKind
+		want image.Point
+	}{
+		{"story", comicbook.PageKindStory, image.Pt(160, 240)},
+		// generated pages have no margins, even if they look like they have
+		{"cover", comicbook.PageKindCover, image.Pt(200, 300)},
+		{"divider", comicbook.PageKindDivider, image.Pt(200, 300)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages, err := TransformPage(testPage(data, tt.kind), opts, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config, _, err := image.DecodeConfig(bytes.NewReader(pages[0].Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := image.Pt(config.Width, config.Height); got != tt.want {
+				t.Errorf("page is %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package transform
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/abbit/m4k/internal/comicbook"
+	"github.com/abbit/m4k/internal/render"
+)
+
+// EventKind is a kind of event emitted while pages are transformed
+type EventKind string
+
+const (
+	EventPageStarted  EventKind = "page-started"
+	EventPageFinished EventKind = "page-finished"
+	// problem with page was repaired, e.g. broken image was recovered
+	EventWarning EventKind = "warning"
+	// page failed, it's handled according to error policy
+	EventError EventKind = "error"
+)
+
+// Event is passed to Options.Callback, which may be called from several goroutines at once
+type Event struct {
+	Kind EventKind
+	// Path of source page, or chapter for strips. Empty for TransformImage
+	Page string
+	// Size of source page in bytes
+	BytesIn int
+	// Total size of encoded pages in bytes, for finished pages
+	BytesOut int
+	// Time page took to transform, for finished pages
+	Duration time.Duration
+	// Pages produced from source page, several for split spreads and strips, none for the rest of a strip
+	Results []PageResult
+	// Pages are taken from cache
+	Cached bool
+	// Problem with page, for warnings and errors
+	Err error
+	// Estimated memory in bytes of pages being transformed, set with Options.Scheduler
+	MemoryBytes int64
+}
+
+// PageResult describes encoded page
+type PageResult struct {
+	// "jpg" or "png"
+	Encoding string
+	// JPEG quality, 0 for PNG
+	Quality int
+	// Size in bytes
+	Size int
+	// Page is kept in color
+	Color bool
+}
+
+func (opts *Options) emit(e Event) {
+	if opts.Callback == nil {
+		return
+	}
+	if opts.Scheduler != nil {
+		e.MemoryBytes = opts.Scheduler.InUse()
+	}
+	opts.Callback(e)
+}
+
+// emitFinished emits event of finished page with results of its frames
+func (opts *Options) emitFinished(page string, bytesIn int, started time.Time, frames []*Frame, cached bool) {
+	if opts.Callback == nil {
+		return
+	}
+	e := Event{
+		Kind:     EventPageFinished,
+		Page:     page,
+		BytesIn:  bytesIn,
+		Duration: time.Since(started),
+		Cached:   cached,
+	}
+	for _, f := range frames {
+		e.Results = append(e.Results, f.result())
+		e.BytesOut += len(f.Data)
+	}
+	opts.emit(e)
+}
+
+// ErrorPolicy is a way failed pages are handled
+type ErrorPolicy string
+
+const (
+	// stop transforming and return error
+	ErrorFail ErrorPolicy = "fail"
+	// drop failed page
+	ErrorSkip ErrorPolicy = "skip"
+	// replace failed page with placeholder showing its path
+	ErrorPlaceholder ErrorPolicy = "placeholder"
+)
+
+var ErrorPolicies = []ErrorPolicy{ErrorFail, ErrorSkip, ErrorPlaceholder}
+
+func ParseErrorPolicy(s string) (ErrorPolicy, error) {
+	for _, policy := range ErrorPolicies {
+		if strings.EqualFold(s, string(policy)) {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("unknown error policy %q, expected one of %v", s, ErrorPolicies)
+}
+
+// handlePageError handles failure of page, or of all pages of strip, according to error policy.
+// Returned pages replace failed ones.
+func handlePageError(name string, p *comicbook.Page, pageErr error, opts *Options, repairs *comicbook.RepairReport) ([]*comicbook.Page, error) {
+	opts.emit(Event{Kind: EventError, Page: name, Err: pageErr})
+
+	// options are validated, policies are matched case-insensitively
+	policy, _ := ParseErrorPolicy(string(opts.OnError))
+	switch policy {
+	case ErrorSkip:
+		repairs.Add(name, comicbook.RepairSkipped, pageErr)
+		return nil, nil
+	case ErrorPlaceholder:
+		img, err := render.Placeholder(name, opts.Width, opts.Height)
+		if err != nil {
+			return nil, pageErr
+		}
+		frames, err := opts.pipeline().run(&Frame{Image: img, Width: opts.Width, Generated: true}, opts)
+		if err != nil {
+			return nil, pageErr
+		}
+		repairs.Add(name, comicbook.RepairReplaced, pageErr)
+		return updatePage(&comicbook.Page{ChapterInfo: p.ChapterInfo, Kind: p.Kind}, frames), nil
+	default:
+		return nil, pageErr
+	}
+}
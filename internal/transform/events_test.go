@@ -0,0 +1,189 @@
+package transform
+
+import (
+	"context"
+	"errors"
+	"image"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/abbit/m4k/internal/comicbook"
+)
+
+// brokenBook returns book of page, broken page and spread
+func brokenBook(t *testing.T) *comicbook.ComicBook {
+	cb := testBook(t, grayImage(100, 200, 128), grayImage(100, 200, 0), spreadImage())
+	cb.Pages[1].Data = []byte("broken")
+	return cb
+}
+
+func TestTransformComicBookErrorPolicy(t *testing.T) {
+	const placeholder = 1
+	tests := []struct {
+		policy ErrorPolicy
+		// brightness of pages in the middle, or placeholder
+		levels []int
+		repair comicbook.RepairAction
+	}{
+		{ErrorSkip, []int{128, 0, 255}, comicbook.RepairSkipped},
+		{"SKIP", []int{128, 0, 255}, comicbook.RepairSkipped},
+		{ErrorPlaceholder, []int{128, placeholder, 0, 255}, comicbook.RepairReplaced},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			cb := brokenBook(t)
+			info := cb.Pages[0].ChapterInfo
+			var errorEvents atomic.Int32
+			opts := &Options{Width: 100, Height: 200, Encoding: "png", Spread: SpreadSplit, MaxUpscale: 1, OnError: tt.policy}
+			opts.Callback = func(e Event) {
+				if e.Kind == EventError {
+					errorEvents.Add(1)
+				}
+			}
+			if err := TransformComicBook(context.Background(), cb, opts); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(cb.Pages) != len(tt.levels) {
+				t.Fatalf("got %d pages, want %d", len(cb.Pages), len(tt.levels))
+			}
+			for i, p := range cb.Pages {
+				if p.Number != uint64(i+1) {
+					t.Errorf("page %d has number %d", i, p.Number)
+				}
+				if p.ChapterInfo != info || p.Kind != comicbook.PageKindStory {
+					t.Errorf("page %d is %v page of chapter %v", i, p.Kind, p.ChapterInfo)
+				}
+				img := decodePage(t, p)
+				if tt.levels[i] == placeholder {
+					if size := img.Bounds().Size(); size != image.Pt(100, 200) {
+						t.Errorf("placeholder is %v, want screen size", size)
+					}
+					continue
+				}
+				if got := grayAt(img, img.Bounds().Dx()/2, img.Bounds().Dy()/2); int(got) != tt.levels[i] {
+					t.Errorf("page %d has level %d, want %d", i, got, tt.levels[i])
+				}
+			}
+
+			issues := cb.Repairs.Issues()
+			if len(issues) != 1 || issues[0].Action != tt.repair || issues[0].File != brokenBook(t).Pages[1].Filepath() {
+				t.Errorf("repairs are %v, want page %s", cb.Repairs, tt.repair)
+			}
+			if errorEvents.Load() != 1 {
+				t.Errorf("got %d error events, want 1", errorEvents.Load())
+			}
+		})
+	}
+}
+
+func TestTransformComicBookFails(t *testing.T) {
+	for _, policy := range []ErrorPolicy{"", ErrorFail} {
+		cb := brokenBook(t)
+		opts := &Options{Width: 100, Height: 200, Encoding: "png", OnError: policy}
+		err := TransformComicBook(context.Background(), cb, opts)
+		if err == nil || !strings.Contains(err.Error(), cb.Pages[1].Filepath()) {
+			t.Errorf("policy %q: error is %v, want error of broken page", policy, err)
+		}
+	}
+}
+
+func TestTransformComicBookStripErrorPolicy(t *testing.T) {
+	tests := []struct {
+		policy  ErrorPolicy
+		heights []int
+	}{
+		// the rest of strip is stitched together
+		{ErrorSkip, []int{300, 300}},
+		// placeholder is put between strip pages before and after broken page
+		{ErrorPlaceholder, []int{300, 300, 300}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			cb := testBook(t,
+				panelsImage(100, 300, [2]int{290, 300}),
+				panelsImage(100, 300),
+				panelsImage(100, 300, [2]int{290, 300}),
+			)
+			cb.Pages[1].Data = []byte("broken")
+			opts := &Options{Width: 100, Height: 300, Encoding: "png", Strip: StripOn, OnError: tt.policy}
+			if err := TransformComicBook(context.Background(), cb, opts); err != nil {
+				t.Fatal(err)
+			}
+			var heights []int
+			for _, p := range cb.Pages {
+				heights = append(heights, decodePage(t, p).Bounds().Dy())
+			}
+			if !reflect.DeepEqual(heights, tt.heights) {
+				t.Errorf("strip is sliced into pages of heights %v, want %v", heights, tt.heights)
+			}
+		})
+	}
+}
+
+func TestTransformComicBookCanceled(t *testing.T) {
+	images := make([]image.Image, 20)
+	for i := range images {
+		images[i] = grayImage(100, 200, 128)
+	}
+
+	for _, policy := range ErrorPolicies {
+		t.Run(string(policy), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var mu sync.Mutex
+			finished := 0
+			cb := testBook(t, images...)
+			opts := &Options{Width: 100, Height: 200, Encoding: "png", OnError: policy}
+			// canceled after the first page
+			opts.Callback = func(e Event) {
+				mu.Lock()
+				defer mu.Unlock()
+				if e.Kind == EventPageFinished {
+					finished++
+					cancel()
+				}
+			}
+			err := TransformComicBook(ctx, cb, opts)
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("error is %v, want canceled", err)
+			}
+			if finished == len(images) {
+				t.Error("all pages are transformed")
+			}
+			if cb.Repairs.HasIssues() {
+				t.Errorf("pages of canceled book are repaired: %v", cb.Repairs)
+			}
+		})
+	}
+
+	// book isn't transformed with canceled context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cb := testBook(t, images...)
+	pages := cb.Pages
+	if err := TransformComicBook(ctx, cb, &Options{Width: 100, Height: 200, Encoding: "png"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("error is %v, want canceled", err)
+	}
+	if len(cb.Pages) != len(pages) || cb.Pages[0] != pages[0] {
+		t.Error("pages of canceled book are replaced")
+	}
+}
+
+func TestParseErrorPolicy(t *testing.T) {
+	for _, policy := range ErrorPolicies {
+		if got, err := ParseErrorPolicy(string(policy)); err != nil || got != policy {
+			t.Errorf("ParseErrorPolicy(%q) = %q, %v", policy, got, err)
+		}
+	}
+	if got, err := ParseErrorPolicy("Placeholder"); err != nil || got != ErrorPlaceholder {
+		t.Errorf("ParseErrorPolicy(%q) = %q, %v", "Placeholder", got, err)
+	}
+	if _, err := ParseErrorPolicy("retry"); err == nil {
+		t.Error("unknown error policy is parsed")
+	}
+}
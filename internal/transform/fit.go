@@ -0,0 +1,121 @@
+package transform
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// FitMode is a way page is fitted into screen size
+type FitMode string
+
+const (
+	// scale to fit inside of screen, keeping aspect ratio
+	FitInside FitMode = "inside"
+	// scale to screen width, keeping aspect ratio, for scrolling
+	FitWidth FitMode = "width"
+	// scale to cover whole screen, keeping aspect ratio, and crop overflow
+	FitFill FitMode = "fill"
+	// scale to exact screen size, ignoring aspect ratio
+	FitStretch FitMode = "stretch"
+)
+
+const DefaultMaxUpscale = 2.0
+
+var FitModes = []FitMode{FitInside, FitWidth, FitFill, FitStretch}
+
+func ParseFitMode(s string) (FitMode, error) {
+	for _, mode := range FitModes {
+		if strings.EqualFold(s, string(mode)) {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("unknown fit mode %q, expected one of %v", s, FitModes)
+}
+
+// fitMode returns canonical fit mode, modes are matched case-insensitively
+func (opts *Options) fitMode() FitMode {
+	// options are validated, so only empty mode isn't parsed
+	mode, err := ParseFitMode(string(opts.Fit))
+	if err != nil {
+		return FitInside
+	}
+	return mode
+}
+
+// ParseColor parses color in "#rrggbb" or "#rgb" format, or one of "white", "black"
+func ParseColor(s string) (color.Color, error) {
+	switch strings.ToLower(s) {
+	case "white":
+		return color.White, nil
+	case "black":
+		return color.Black, nil
+	}
+
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return nil, fmt.Errorf("invalid color %q", s)
+	}
+	return color.RGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 0xff}, nil
+}
+
+// fitImage scales image into width and height according to fit mode
+func fitImage(img image.Image, width, height int, opts *Options) image.Image {
+	fit := opts.fitMode()
+	if fit == FitStretch {
+		return resizeImage(img, width, height)
+	}
+
+	size := img.Bounds().Size()
+	scaleX := float64(width) / float64(size.X)
+	scaleY := float64(height) / float64(size.Y)
+
+	var scale float64
+	switch fit {
+	case FitWidth:
+		scale = scaleX
+	case FitFill:
+		scale = max(scaleX, scaleY)
+	default:
+		scale = min(scaleX, scaleY)
+	}
+	maxUpscale := opts.MaxUpscale
+	if maxUpscale <= 0 {
+		maxUpscale = DefaultMaxUpscale
+	}
+	scale = min(scale, maxUpscale)
+
+	scaledWidth := max(1, int(math.Round(float64(size.X)*scale)))
+	scaledHeight := max(1, int(math.Round(float64(size.Y)*scale)))
+	if scaledWidth != size.X || scaledHeight != size.Y {
+		img = resizeImage(img, scaledWidth, scaledHeight)
+	}
+
+	if fit == FitFill {
+		img = imaging.CropCenter(img, min(scaledWidth, width), min(scaledHeight, height))
+	}
+
+	if opts.Pad {
+		padHeight := height
+		if fit == FitWidth {
+			// page is scrolled vertically, only width is padded
+			padHeight = img.Bounds().Dy()
+		}
+		padColor := opts.PadColor
+		if padColor == nil {
+			padColor = color.White
+		}
+		img = imaging.PasteCenter(imaging.New(width, padHeight, padColor), img)
+	}
+
+	return img
+}
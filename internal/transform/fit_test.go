@@ -0,0 +1,120 @@
+package transform
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// grayImage returns grayscale image of given size filled with level
+func grayImage(width, height int, level uint8) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = level
+	}
+	return img
+}
+
+// grayAt returns brightness of image pixel relative to image bounds
+func grayAt(img image.Image, x, y int) uint8 {
+	bounds := img.Bounds()
+	return color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y
+}
+
+func TestFitImage(t *testing.T) {
+	tests := []struct {
+		name string
+		src  image.Point
+		opts Options
+		want image.Point
+	}{
+		{"inside by height", image.Pt(100, 200), Options{}, image.Pt(150, 300)},
+		{"inside by width", image.Pt(200, 100), Options{}, image.Pt(300, 150)},
+		{"inside downscaled", image.Pt(600, 1200), Options{}, image.Pt(150, 300)},
+		{"inside mode is case-insensitive", image.Pt(100, 200), Options{Fit: "INSIDE"}, image.Pt(150, 300)},
+		{"max upscale", image.Pt(50, 100), Options{}, image.Pt(100, 200)},
+		{"no upscale", image.Pt(100, 200), Options{MaxUpscale: 1}, image.Pt(100, 200)},
+		{"width", image.Pt(200, 400), Options{Fit: FitWidth}, image.Pt(300, 600)},
+		{"width limited by max upscale", image.Pt(100, 400), Options{Fit: FitWidth}, image.Pt(200, 800)},
+		{"fill", image.Pt(200, 150), Options{Fit: FitFill}, image.Pt(300, 300)},
+		{"fill limited by max upscale", image.Pt(100, 50), Options{Fit: FitFill}, image.Pt(200, 100)},
+		{"stretch", image.Pt(100, 200), Options{Fit: FitStretch}, image.Pt(300, 300)},
+		{"stretch ignores max upscale", image.Pt(10, 10), Options{Fit: FitStretch, MaxUpscale: 1}, image.Pt(300, 300)},
+		{"inside padded", image.Pt(100, 200), Options{Pad: true}, image.Pt(300, 300)},
+		{"padded without upscale", image.Pt(100, 200), Options{Pad: true, MaxUpscale: 1}, image.Pt(300, 300)},
+		{"width padded only horizontally", image.Pt(100, 400), Options{Fit: FitWidth, Pad: true}, image.Pt(300, 800)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := fitImage(grayImage(tt.src.X, tt.src.Y, 0), 300, 300, &tt.opts)
+			if got := img.Bounds().Size(); got != tt.want {
+				t.Errorf("fitted into %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFitImagePadColor(t *testing.T) {
+	tests := []struct {
+		name  string
+		color color.Color
+		want  uint8
+	}{
+		{"default", nil, 255},
+		{"black", color.Black, 0},
+		{"gray", color.RGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xff}, 0x80},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &Options{Pad: true, PadColor: tt.color, MaxUpscale: 1}
+			img := fitImage(grayImage(100, 200, 50), 300, 300, opts)
+			// page is centered between padding
+			if got := grayAt(img, 0, 150); got != tt.want {
+				t.Errorf("padding is %d, want %d", got, tt.want)
+			}
+			if got := grayAt(img, 150, 150); got != 50 {
+				t.Errorf("page is %d, want 50", got)
+			}
+			if got := grayAt(img, 150, 10); got != tt.want {
+				t.Errorf("padding above page is %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    color.Color
+		wantErr bool
+	}{
+		{s: "white", want: color.White},
+		{s: "Black", want: color.Black},
+		{s: "#ff8000", want: color.RGBA{R: 0xff, G: 0x80, A: 0xff}},
+		{s: "#f80", want: color.RGBA{R: 0xff, G: 0x88, A: 0xff}},
+		{s: "ff8000", want: color.RGBA{R: 0xff, G: 0x80, A: 0xff}},
+		{s: "#ff80", wantErr: true},
+		{s: "#gg0000", wantErr: true},
+		{s: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseColor(tt.s)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseColor(%q) = %v, %v", tt.s, got, err)
+		}
+	}
+}
+
+func TestParseFitMode(t *testing.T) {
+	for _, mode := range FitModes {
+		if got, err := ParseFitMode(string(mode)); err != nil || got != mode {
+			t.Errorf("ParseFitMode(%q) = %q, %v", mode, got, err)
+		}
+	}
+	if got, err := ParseFitMode("Fill"); err != nil || got != FitFill {
+		t.Errorf("ParseFitMode(%q) = %q, %v", "Fill", got, err)
+	}
+	if _, err := ParseFitMode("zoom"); err == nil {
+		t.Error("unknown fit mode is parsed")
+	}
+}
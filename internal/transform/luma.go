@@ -0,0 +1,195 @@
+package transform
+
+import (
+	"image"
+	"math"
+	"sync"
+
+	"github.com/disintegration/imaging"
+)
+
+// luminance returns brightness channel of grayscale and JPEG images without conversion.
+// It's the same as produced by imaging.Grayscale, up to rounding.
+func luminance(img image.Image) (*image.Gray, bool) {
+	switch img := img.(type) {
+	case *image.Gray:
+		return img, true
+	case *image.YCbCr:
+		// Y plane of full range YCbCr, as used by JPEG
+		return &image.Gray{Pix: img.Y, Stride: img.YStride, Rect: img.Rect}, true
+	}
+	return nil, false
+}
+
+// toGray returns grayscale version of image, same as imaging.Grayscale.
+// Grayscale images and luminance of JPEG images are returned without copying.
+func toGray(img image.Image) *image.Gray {
+	if gray, ok := luminance(img); ok {
+		return gray
+	}
+	nrgba := imaging.Grayscale(img)
+	gray := image.NewGray(nrgba.Rect)
+	for i := range gray.Pix {
+		gray.Pix[i] = nrgba.Pix[4*i]
+	}
+	return gray
+}
+
+// resizeImage resizes image with Lanczos filter, using fast path for grayscale images
+func resizeImage(img image.Image, width, height int) image.Image {
+	if gray, ok := img.(*image.Gray); ok {
+		return resizeGray(gray, width, height)
+	}
+	return imaging.Resize(img, width, height, imaging.Lanczos)
+}
+
+// resizeGray resizes grayscale image with Lanczos filter. Images much larger than
+// the result are halved with box filter first, which is much cheaper than Lanczos
+// with wide support and looks the same.
+func resizeGray(src *image.Gray, width, height int) *image.Gray {
+	img := src
+	for img.Bounds().Dx()/2 >= 2*width && img.Bounds().Dy()/2 >= 2*height {
+		half := halveGray(img)
+		if img != src {
+			putGray(img)
+		}
+		img = half
+	}
+
+	result := lanczosGray(img, width, height)
+	if img != src {
+		putGray(img)
+	}
+	return result
+}
+
+// halveGray downscales image by 2 averaging 2x2 blocks, odd row and column are dropped
+func halveGray(src *image.Gray) *image.Gray {
+	bounds := src.Bounds()
+	width, height := bounds.Dx()/2, bounds.Dy()/2
+	dst := getGray(width, height)
+	for y := 0; y < height; y++ {
+		top := src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y+2*y):]
+		bottom := src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y+2*y+1):]
+		out := dst.Pix[y*dst.Stride : y*dst.Stride+width]
+		for x := range out {
+			sum := int(top[2*x]) + int(top[2*x+1]) + int(bottom[2*x]) + int(bottom[2*x+1])
+			out[x] = uint8((sum + 2) / 4)
+		}
+	}
+	return dst
+}
+
+// lanczosGray resamples image the same way as imaging.Resize, but with a single channel
+func lanczosGray(src *image.Gray, width, height int) *image.Gray {
+	bounds := src.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	// horizontal pass
+	tmp := src
+	if width != srcWidth {
+		tmp = getGray(width, srcHeight)
+		weights := lanczosWeights(width, srcWidth)
+		for y := 0; y < srcHeight; y++ {
+			row := src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+			out := tmp.Pix[y*tmp.Stride : y*tmp.Stride+width]
+			for x := range out {
+				var v float64
+				for _, w := range weights[x] {
+					v += float64(row[w.index]) * w.weight
+				}
+				out[x] = clampGray(v)
+			}
+		}
+	}
+	tmpBounds := tmp.Bounds()
+
+	// vertical pass, rows are accumulated to go through memory sequentially
+	dst := image.NewGray(image.Rect(0, 0, width, height))
+	if height == srcHeight {
+		for y := 0; y < height; y++ {
+			copy(dst.Pix[y*dst.Stride:y*dst.Stride+width], tmp.Pix[tmp.PixOffset(tmpBounds.Min.X, tmpBounds.Min.Y+y):])
+		}
+	} else {
+		weights := lanczosWeights(height, srcHeight)
+		acc := make([]float64, width)
+		for y := 0; y < height; y++ {
+			clear(acc)
+			for _, w := range weights[y] {
+				row := tmp.Pix[tmp.PixOffset(tmpBounds.Min.X, tmpBounds.Min.Y+w.index):]
+				for x := range acc {
+					acc[x] += float64(row[x]) * w.weight
+				}
+			}
+			out := dst.Pix[y*dst.Stride : y*dst.Stride+width]
+			for x := range out {
+				out[x] = clampGray(acc[x])
+			}
+		}
+	}
+
+	if tmp != src {
+		putGray(tmp)
+	}
+	return dst
+}
+
+type indexWeight struct {
+	index  int
+	weight float64
+}
+
+// lanczosWeights returns normalized weights of source pixels for each destination pixel,
+// computed the same way as by imaging
+func lanczosWeights(dstSize, srcSize int) [][]indexWeight {
+	du := float64(srcSize) / float64(dstSize)
+	scale := max(du, 1)
+	ru := math.Ceil(scale * imaging.Lanczos.Support)
+
+	weights := make([][]indexWeight, dstSize)
+	// weights of all pixels share one buffer
+	buf := make([]indexWeight, 0, dstSize*(2*int(ru)+1))
+	for v := range weights {
+		fu := (float64(v)+0.5)*du - 0.5
+		begin := max(0, int(math.Ceil(fu-ru)))
+		end := min(srcSize-1, int(math.Floor(fu+ru)))
+
+		start := len(buf)
+		var sum float64
+		for u := begin; u <= end; u++ {
+			if w := imaging.Lanczos.Kernel((float64(u) - fu) / scale); w != 0 {
+				sum += w
+				buf = append(buf, indexWeight{index: u, weight: w})
+			}
+		}
+		weights[v] = buf[start:len(buf):len(buf)]
+		if sum != 0 {
+			for i := range weights[v] {
+				weights[v][i].weight /= sum
+			}
+		}
+	}
+	return weights
+}
+
+func clampGray(v float64) uint8 {
+	return uint8(math.Min(math.Max(v, 0), 255) + 0.5)
+}
+
+// grayPool keeps pixel buffers of intermediate images, which are as large as source pages
+var grayPool sync.Pool
+
+// getGray returns image with undefined pixels, reusing pooled buffer if it's large enough
+func getGray(width, height int) *image.Gray {
+	size := width * height
+	if buf, ok := grayPool.Get().(*[]byte); ok && cap(*buf) >= size {
+		return &image.Gray{Pix: (*buf)[:size], Stride: width, Rect: image.Rect(0, 0, width, height)}
+	}
+	return image.NewGray(image.Rect(0, 0, width, height))
+}
+
+// putGray returns buffer of image from getGray to pool, image must not be used after it
+func putGray(img *image.Gray) {
+	buf := img.Pix[:0]
+	grayPool.Put(&buf)
+}
@@ -0,0 +1,243 @@
+package transform
+
+import (
+	"fmt"
+	"image"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Frame is a page image passing through pipeline
+type Frame struct {
+	Image image.Image
+	// Width of screen area image is fitted into, twice the screen width for double spreads
+	Width int
+	// Image is a slice of long strip, it's cropped and laid out already
+	Strip bool
+	// Image is generated, like cover, divider or placeholder pages, and has no margins to crop
+	Generated bool
+	// Image is kept in color
+	Color bool
+
+	// set by encode stage
+
+	Data     []byte
+	Encoding string
+	// JPEG quality, 0 for PNG
+	Quality int
+}
+
+// result describes encoded frame
+func (f *Frame) result() PageResult {
+	return PageResult{
+		Encoding: f.Encoding,
+		Quality:  f.Quality,
+		Size:     len(f.Data),
+		Color:    f.Color,
+	}
+}
+
+// Stage processes frame, returning resulting frames: usually the same frame,
+// several frames if it's split, or none if it's dropped
+type Stage interface {
+	Apply(f *Frame, opts *Options) ([]*Frame, error)
+}
+
+// StageFunc is a function used as Stage
+type StageFunc func(f *Frame, opts *Options) ([]*Frame, error)
+
+func (fn StageFunc) Apply(f *Frame, opts *Options) ([]*Frame, error) {
+	return fn(f, opts)
+}
+
+// frameStage returns stage which changes frame in place
+func frameStage(fn func(f *Frame, opts *Options) error) Stage {
+	return StageFunc(func(f *Frame, opts *Options) ([]*Frame, error) {
+		if err := fn(f, opts); err != nil {
+			return nil, err
+		}
+		return []*Frame{f}, nil
+	})
+}
+
+var (
+	stagesMu sync.RWMutex
+	stages   = map[string]Stage{}
+)
+
+// RegisterStage registers stage under name, so it can be used in pipeline strings
+func RegisterStage(name string, stage Stage) error {
+	stagesMu.Lock()
+	defer stagesMu.Unlock()
+
+	if strings.ContainsAny(name, ", ") || len(name) == 0 {
+		return fmt.Errorf("invalid stage name %q", name)
+	}
+	if _, ok := stages[name]; ok {
+		return fmt.Errorf("stage %q is registered already", name)
+	}
+	if _, ok := Presets[name]; ok {
+		return fmt.Errorf("stage name %q is used by preset", name)
+	}
+	stages[name] = stage
+	return nil
+}
+
+func mustRegisterStage(name string, stage Stage) {
+	if err := RegisterStage(name, stage); err != nil {
+		panic(err)
+	}
+}
+
+func lookupStage(name string) (Stage, bool) {
+	stagesMu.RLock()
+	defer stagesMu.RUnlock()
+	stage, ok := stages[name]
+	return stage, ok
+}
+
+// StageNames returns sorted names of registered stages
+func StageNames() []string {
+	stagesMu.RLock()
+	defer stagesMu.RUnlock()
+
+	names := make([]string, 0, len(stages))
+	for name := range stages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Presets are named pipelines, which can be used in pipeline strings in place of stages
+var Presets = map[string]string{
+	"default": "luma,crop,spread,fit,color,grayscale,tone,quantize,encode",
+	// only resizing, for fast conversion
+	"fast": "luma,spread,fit,grayscale,encode",
+}
+
+const DefaultPreset = "default"
+
+// PresetNames returns sorted names of presets
+func PresetNames() []string {
+	names := make([]string, 0, len(Presets))
+	for name := range Presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Pipeline is an ordered list of stages transforming decoded page into encoded pages
+type Pipeline struct {
+	names  []string
+	stages []Stage
+}
+
+// ParsePipeline builds pipeline from comma-separated names of stages and presets,
+// e.g. "default" or "crop,fit,grayscale,encode"
+func ParsePipeline(s string) (*Pipeline, error) {
+	p := &Pipeline{}
+	if err := p.add(s, 0); err != nil {
+		return nil, err
+	}
+	if len(p.stages) == 0 {
+		return nil, fmt.Errorf("pipeline %q has no stages", s)
+	}
+	if !p.has("encode") {
+		return nil, fmt.Errorf("pipeline %q doesn't encode pages", s)
+	}
+	// stages after encode would change images which are never encoded
+	if i := p.index("encode"); i != len(p.names)-1 {
+		return nil, fmt.Errorf("pipeline %q must end with encode, got %q after it", s, p.names[i+1])
+	}
+	return p, nil
+}
+
+// MustParsePipeline is like ParsePipeline but panics on error
+func MustParsePipeline(s string) *Pipeline {
+	p, err := ParsePipeline(s)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func (p *Pipeline) add(s string, depth int) error {
+	if depth > len(Presets) {
+		return fmt.Errorf("presets refer to each other in %q", s)
+	}
+
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if len(name) == 0 {
+			continue
+		}
+		if preset, ok := Presets[name]; ok {
+			if err := p.add(preset, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		stage, ok := lookupStage(name)
+		if !ok {
+			return fmt.Errorf("unknown stage %q, expected one of %v or preset", name, StageNames())
+		}
+		p.names = append(p.names, name)
+		p.stages = append(p.stages, stage)
+	}
+	return nil
+}
+
+func (p *Pipeline) has(name string) bool {
+	return p.index(name) >= 0
+}
+
+// index returns position of the first stage with name, or -1
+func (p *Pipeline) index(name string) int {
+	for i, n := range p.names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *Pipeline) String() string {
+	return strings.Join(p.names, ",")
+}
+
+// Run passes decoded page image through pipeline, returning encoded frames
+func (p *Pipeline) Run(img image.Image, opts *Options) ([]*Frame, error) {
+	return p.run(&Frame{Image: img, Width: opts.Width}, opts)
+}
+
+func (p *Pipeline) run(frame *Frame, opts *Options) ([]*Frame, error) {
+	frames := []*Frame{frame}
+	for i, stage := range p.stages {
+		var next []*Frame
+		for _, f := range frames {
+			result, err := stage.Apply(f, opts)
+			if err != nil {
+				return nil, fmt.Errorf("in stage %s: %w", p.names[i], err)
+			}
+			next = append(next, result...)
+		}
+		frames = next
+	}
+
+	for _, f := range frames {
+		if f.Data == nil {
+			return nil, fmt.Errorf("pipeline %s didn't encode page", p)
+		}
+	}
+	return frames, nil
+}
+
+func (opts *Options) pipeline() *Pipeline {
+	if opts.Pipeline != nil {
+		return opts.Pipeline
+	}
+	return defaultPipeline
+}
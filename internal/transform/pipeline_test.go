@@ -0,0 +1,142 @@
+package transform
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParsePipeline(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    string
+		wantErr string
+	}{
+		{s: "default", want: Presets["default"]},
+		{s: "fast", want: Presets["fast"]},
+		{s: " Crop, fit ,,ENCODE ", want: "crop,fit,encode"},
+		{s: "crop,fast", want: "crop," + Presets["fast"]},
+		{s: "", wantErr: "has no stages"},
+		{s: "crop,fit", wantErr: "doesn't encode pages"},
+		{s: "fit,zoom,encode", wantErr: `unknown stage "zoom"`},
+		{s: "encode,grayscale", wantErr: "must end with encode"},
+		{s: "default,grayscale", wantErr: "must end with encode"},
+		{s: "encode,encode", wantErr: "must end with encode"},
+	}
+	for _, tt := range tests {
+		p, err := ParsePipeline(tt.s)
+		if len(tt.wantErr) > 0 {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParsePipeline(%q) error is %v, want %q", tt.s, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParsePipeline(%q): %v", tt.s, err)
+			continue
+		}
+		if p.String() != tt.want {
+			t.Errorf("ParsePipeline(%q) = %q, want %q", tt.s, p, tt.want)
+		}
+	}
+}
+
+func TestParsePipelinePresetLoop(t *testing.T) {
+	Presets["loop"] = "fit,loop"
+	defer delete(Presets, "loop")
+	if _, err := ParsePipeline("loop,encode"); err == nil {
+		t.Error("presets referring to each other are parsed")
+	}
+}
+
+// registerTestStage registers stage until the end of test
+func registerTestStage(t *testing.T, name string, stage Stage) {
+	t.Helper()
+	if err := RegisterStage(name, stage); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		stagesMu.Lock()
+		defer stagesMu.Unlock()
+		delete(stages, name)
+	})
+}
+
+func TestRegisterStage(t *testing.T) {
+	stage := frameStage(func(*Frame, *Options) error { return nil })
+	for _, name := range []string{"", "a,b", "a b", "fit", "default"} {
+		if err := RegisterStage(name, stage); err == nil {
+			t.Errorf("stage %q is registered", name)
+		}
+	}
+
+	registerTestStage(t, "test-nop", stage)
+	if !slices.Contains(StageNames(), "test-nop") {
+		t.Errorf("stage isn't listed in %v", StageNames())
+	}
+	if _, err := ParsePipeline("test-nop,encode"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCustomStages(t *testing.T) {
+	registerTestStage(t, "test-invert", frameStage(func(f *Frame, opts *Options) error {
+		gray := toGray(f.Image)
+		for i, v := range gray.Pix {
+			gray.Pix[i] = 255 - v
+		}
+		f.Image = gray
+		return nil
+	}))
+	registerTestStage(t, "test-drop", StageFunc(func(*Frame, *Options) ([]*Frame, error) {
+		return nil, nil
+	}))
+	registerTestStage(t, "test-fail", StageFunc(func(*Frame, *Options) ([]*Frame, error) {
+		return nil, errors.New("failed")
+	}))
+
+	data, err := encodeImage(grayImage(100, 100, 200), "png", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transform := func(pipeline string) ([][]byte, error) {
+		opts := &Options{Width: 100, Height: 100, Encoding: "png", Pipeline: MustParsePipeline(pipeline)}
+		return TransformImage(data, opts)
+	}
+
+	pages, err := transform("fit,test-invert,encode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 {
+		t.Fatalf("got %d pages, want 1", len(pages))
+	}
+	img, _, err := image.Decode(bytes.NewReader(pages[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := grayAt(img, 50, 50); got != 55 {
+		t.Errorf("page is %d, want inverted 55", got)
+	}
+
+	if pages, err := transform("test-drop,encode"); err != nil || len(pages) != 0 {
+		t.Errorf("dropped page is transformed into %d pages, %v", len(pages), err)
+	}
+	if _, err := transform("test-fail,encode"); err == nil || !strings.Contains(err.Error(), "in stage test-fail") {
+		t.Errorf("error of stage is %v", err)
+	}
+}
+
+func TestPresets(t *testing.T) {
+	for _, name := range PresetNames() {
+		if _, err := ParsePipeline(name); err != nil {
+			t.Errorf("preset %s: %v", name, err)
+		}
+	}
+	if !slices.Equal(StageNames(), []string{"color", "crop", "encode", "fit", "grayscale", "luma", "quantize", "spread", "tone"}) {
+		t.Errorf("built-in stages are %v", StageNames())
+	}
+}
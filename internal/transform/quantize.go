@@ -0,0 +1,114 @@
+package transform
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+)
+
+// Dither is a way of spreading quantization error, which hides banding of smooth gradients
+type Dither string
+
+const (
+	DitherNone Dither = "none"
+	// error diffusion, best for gradients and screentones
+	DitherFloydSteinberg Dither = "floyd-steinberg"
+	// Bayer matrix, regular pattern without artifacts moving between pages
+	DitherOrdered Dither = "ordered"
+)
+
+var Dithers = []Dither{DitherNone, DitherFloydSteinberg, DitherOrdered}
+
+func ParseDither(s string) (Dither, error) {
+	for _, dither := range Dithers {
+		if strings.EqualFold(s, string(dither)) {
+			return dither, nil
+		}
+	}
+	return "", fmt.Errorf("unknown dither %q, expected one of %v", s, Dithers)
+}
+
+// dither returns canonical dither, dithers are matched case-insensitively
+func (opts *Options) dither() Dither {
+	// options are validated, so only empty dither isn't parsed
+	dither, err := ParseDither(string(opts.Dither))
+	if err != nil {
+		return DitherNone
+	}
+	return dither
+}
+
+// 8x8 Bayer matrix for ordered dithering
+var bayerMatrix = [8][8]int{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+// grayPalette returns evenly spaced gray levels from black to white
+func grayPalette(levels int) color.Palette {
+	palette := make(color.Palette, levels)
+	for i := range palette {
+		palette[i] = color.Gray{Y: uint8(i * 255 / (levels - 1))}
+	}
+	return palette
+}
+
+// quantize maps grayscale image to given number of gray levels.
+// Result is paletted, so PNG encoder writes it with 4 or less bits per pixel for up to 16 levels.
+func quantize(img *image.Gray, levels int, dither Dither) *image.Paletted {
+	levels = max(2, min(levels, 256))
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	result := image.NewPaletted(image.Rect(0, 0, width, bounds.Dy()), grayPalette(levels))
+
+	// value of the nearest level and its index
+	step := 255.0 / float64(levels-1)
+	nearest := func(v int) (int, uint8) {
+		v = max(0, min(v, 255))
+		i := int(float64(v)/step + 0.5)
+		return int(float64(i)*step + 0.5), uint8(i)
+	}
+
+	// errors diffused to the current and the next rows
+	var current, next []int
+	if dither == DitherFloydSteinberg {
+		current, next = make([]int, width+2), make([]int, width+2)
+	}
+
+	for y := 0; y < bounds.Dy(); y++ {
+		row := img.Pix[img.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+		out := result.Pix[result.PixOffset(0, y):]
+		for x := 0; x < width; x++ {
+			v := int(row[x])
+			switch dither {
+			case DitherFloydSteinberg:
+				v = max(0, min(v+current[x+1]/16, 255))
+				level, i := nearest(v)
+				out[x] = i
+				e := v - level
+				current[x+2] += 7 * e
+				next[x] += 3 * e
+				next[x+1] += 5 * e
+				next[x+2] += e
+			case DitherOrdered:
+				threshold := (float64(bayerMatrix[y%8][x%8])+0.5)/64 - 0.5
+				_, out[x] = nearest(v + int(threshold*step))
+			default:
+				_, out[x] = nearest(v)
+			}
+		}
+		if dither == DitherFloydSteinberg {
+			current, next = next, current
+			clear(next)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,166 @@
+package transform
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// usedLevels returns number of palette entries used by image
+func usedLevels(img *image.Paletted) int {
+	used := make(map[uint8]bool)
+	for _, i := range img.Pix {
+		used[i] = true
+	}
+	return len(used)
+}
+
+// meanGray returns average brightness of paletted grayscale image
+func meanGray(img *image.Paletted) float64 {
+	var sum float64
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		for x := 0; x < img.Bounds().Dx(); x++ {
+			sum += float64(grayAt(img, x, y))
+		}
+	}
+	return sum / float64(img.Bounds().Dx()*img.Bounds().Dy())
+}
+
+func TestQuantizeLevels(t *testing.T) {
+	for _, levels := range []int{2, 4, 16} {
+		for _, dither := range Dithers {
+			img := quantize(rampImage(0, 255), levels, dither)
+			if len(img.Palette) != levels {
+				t.Errorf("%d levels, %s: palette has %d colors", levels, dither, len(img.Palette))
+			}
+			if got := usedLevels(img); got != levels {
+				t.Errorf("%d levels, %s: ramp is quantized to %d levels", levels, dither, got)
+			}
+			// black and white are kept
+			if grayAt(img, 0, 0) != 0 || grayAt(img, 255, 0) != 255 {
+				t.Errorf("%d levels, %s: black and white are quantized to %d and %d",
+					levels, dither, grayAt(img, 0, 0), grayAt(img, 255, 0))
+			}
+		}
+	}
+}
+
+func TestQuantizeNearest(t *testing.T) {
+	img := quantize(rampImage(0, 255), 16, DitherNone)
+	for v, want := range map[int]uint8{0: 0, 8: 0, 9: 17, 128: 136, 247: 255, 255: 255} {
+		if got := grayAt(img, v, 0); got != want {
+			t.Errorf("%d is quantized to %d, want %d", v, got, want)
+		}
+	}
+}
+
+func TestQuantizeDither(t *testing.T) {
+	// level between black and white of 2 levels
+	const level = 100
+	img := grayImage(64, 64, level)
+	tests := []struct {
+		dither Dither
+		levels int
+		mean   float64
+	}{
+		{DitherNone, 1, 0},
+		{DitherFloydSteinberg, 2, level},
+		{DitherOrdered, 2, level},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.dither), func(t *testing.T) {
+			result := quantize(img, 2, tt.dither)
+			if got := usedLevels(result); got != tt.levels {
+				t.Errorf("flat page is quantized to %d levels, want %d", got, tt.levels)
+			}
+			// dithering keeps average brightness
+			if got := meanGray(result); got < tt.mean-5 || got > tt.mean+5 {
+				t.Errorf("average brightness is %.1f, want %v", got, tt.mean)
+			}
+		})
+	}
+
+	// ordered dithering repeats Bayer matrix
+	result := quantize(img, 2, DitherOrdered)
+	for y := 0; y < 56; y++ {
+		for x := 0; x < 56; x++ {
+			if result.ColorIndexAt(x, y) != result.ColorIndexAt(x+8, y) || result.ColorIndexAt(x, y) != result.ColorIndexAt(x, y+8) {
+				t.Fatalf("ordered dithering isn't regular at (%d, %d)", x, y)
+			}
+		}
+	}
+}
+
+func TestQuantizeSubImage(t *testing.T) {
+	img := rampImage(0, 255).SubImage(image.Rect(128, 0, 256, 1)).(*image.Gray)
+	result := quantize(img, 2, DitherNone)
+	if result.Bounds() != image.Rect(0, 0, 128, 1) {
+		t.Fatalf("result bounds are %v", result.Bounds())
+	}
+	if got := usedLevels(result); got != 1 || grayAt(result, 0, 0) != 255 {
+		t.Errorf("bright half is quantized to %d levels starting with %d", got, grayAt(result, 0, 0))
+	}
+}
+
+func TestTransformImageLevels(t *testing.T) {
+	data, err := encodeImage(rampImage(0, 255), "png", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := TransformImage(data, &Options{Width: 256, Height: 256, Encoding: "png", Levels: 16, MaxUpscale: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(pages[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	paletted, ok := img.(*image.Paletted)
+	if !ok {
+		t.Fatalf("page is %T, want paletted", img)
+	}
+	if len(paletted.Palette) != 16 {
+		t.Errorf("palette has %d colors, want 16", len(paletted.Palette))
+	}
+	// bit depth in IHDR chunk after 8 bytes of signature, length, type, width and height
+	if depth := pages[0][24]; depth != 4 {
+		t.Errorf("bit depth is %d, want 4", depth)
+	}
+}
+
+func TestLevelsValidate(t *testing.T) {
+	tests := []struct {
+		opts    Options
+		wantErr bool
+	}{
+		{Options{Encoding: "png", Levels: 16}, false},
+		{Options{Encoding: "png", Levels: 256}, false},
+		{Options{Encoding: "png", Levels: 1}, true},
+		{Options{Encoding: "png", Levels: 257}, true},
+		{Options{Encoding: "png", Levels: -1}, true},
+		{Options{Encoding: "jpg", Levels: 16}, true},
+		{Options{Encoding: "png", Levels: 16, Dither: "noise"}, true},
+	}
+	for _, tt := range tests {
+		tt.opts.Width, tt.opts.Height = 100, 100
+		if err := tt.opts.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%s, %d levels, %q) = %v", tt.opts.Encoding, tt.opts.Levels, tt.opts.Dither, err)
+		}
+	}
+}
+
+func TestParseDither(t *testing.T) {
+	for _, dither := range Dithers {
+		if got, err := ParseDither(string(dither)); err != nil || got != dither {
+			t.Errorf("ParseDither(%q) = %q, %v", dither, got, err)
+		}
+	}
+	if got, err := ParseDither("Ordered"); err != nil || got != DitherOrdered {
+		t.Errorf("ParseDither(%q) = %q, %v", "Ordered", got, err)
+	}
+	if _, err := ParseDither("noise"); err == nil {
+		t.Error("unknown dither is parsed")
+	}
+}
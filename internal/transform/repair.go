@@ -0,0 +1,44 @@
+package transform
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+)
+
+const (
+	// zeros padding truncated scan data are limited to this many times the size of data, plus minimum.
+	// It's enough for images with at least a tenth of data left, others are replaced with placeholders.
+	jpegPaddingFactor = 8
+	jpegMinPadding    = 64 << 10
+)
+
+var (
+	jpegSOI = []byte{0xff, 0xd8}
+	jpegEOI = []byte{0xff, 0xd9}
+)
+
+// decodeTruncatedJPEG decodes JPEG with truncated scan data.
+// Missing data is padded with zeros, which decode as flat continuation of the last decoded blocks.
+func decodeTruncatedJPEG(data []byte) (image.Image, error) {
+	if !bytes.HasPrefix(data, jpegSOI) {
+		return nil, fmt.Errorf("not a jpeg")
+	}
+
+	config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	data = bytes.TrimSuffix(data, jpegEOI)
+	// one byte per pixel is more than enough to encode the rest of blocks with zero codes,
+	// but size in header can't be trusted, so padding is limited by size of data too
+	padding := min(config.Width*config.Height, jpegPaddingFactor*len(data)+jpegMinPadding)
+	repaired := make([]byte, 0, len(data)+padding+len(jpegEOI))
+	repaired = append(repaired, data...)
+	repaired = append(repaired, make([]byte, padding)...)
+	repaired = append(repaired, jpegEOI...)
+
+	return jpeg.Decode(bytes.NewReader(repaired))
+}
@@ -0,0 +1,85 @@
+package transform
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"sync/atomic"
+
+	"github.com/abbit/m4k/internal/comicbook"
+	"golang.org/x/sync/semaphore"
+)
+
+const (
+	DefaultMemoryBytes = 1 << 30
+	// decoded images are converted to NRGBA by most stages
+	bytesPerPixel = 4
+	// copies of source image alive at once, e.g. decoded and cropped or grayscaled
+	sourceCopies = 2
+	// copies of screen sized image, twice the width for double spreads
+	screenCopies = 2
+)
+
+// Scheduler admits pages to be transformed while estimated memory of their decoded images
+// fits memory limit. It can be shared between books transformed at once.
+// Pages are admitted in order, a page larger than the limit is transformed alone.
+type Scheduler struct {
+	limit int64
+	sem   *semaphore.Weighted
+	inUse atomic.Int64
+}
+
+func NewScheduler(memoryBytes int64) *Scheduler {
+	if memoryBytes <= 0 {
+		memoryBytes = DefaultMemoryBytes
+	}
+	return &Scheduler{
+		limit: memoryBytes,
+		sem:   semaphore.NewWeighted(memoryBytes),
+	}
+}
+
+// Limit returns memory limit in bytes
+func (s *Scheduler) Limit() int64 {
+	return s.limit
+}
+
+// InUse returns estimated memory in bytes of pages being transformed
+func (s *Scheduler) InUse() int64 {
+	return s.inUse.Load()
+}
+
+// admit waits until memory of page is available, returned function releases it
+func (s *Scheduler) admit(ctx context.Context, memoryBytes int64) (func(), error) {
+	weight := min(max(1, memoryBytes), s.limit)
+	if err := s.sem.Acquire(ctx, weight); err != nil {
+		return nil, err
+	}
+	s.inUse.Add(memoryBytes)
+	return func() {
+		s.inUse.Add(-memoryBytes)
+		s.sem.Release(weight)
+	}, nil
+}
+
+// estimateMemory returns estimated memory used by transforming page.
+// Size of images which can't be decoded is unknown, placeholders of screen size are used for them.
+func estimateMemory(p *comicbook.Page, opts *Options) int64 {
+	screen := int64(opts.Width) * int64(opts.Height) * bytesPerPixel * screenCopies
+	config, _, err := image.DecodeConfig(bytes.NewReader(p.Data))
+	if err != nil {
+		return 2 * screen
+	}
+	return int64(config.Width)*int64(config.Height)*bytesPerPixel*sourceCopies + screen
+}
+
+// estimateStripMemory returns estimated memory used by transforming strip.
+// Its pages are transformed one by one, so the largest page is used.
+func estimateStripMemory(pages []*comicbook.Page, opts *Options) int64 {
+	var largest int64
+	for _, p := range pages {
+		largest = max(largest, estimateMemory(p, opts))
+	}
+	// rows of previous pages which aren't sliced yet
+	return largest + int64(opts.Width)*int64(opts.Height)*bytesPerPixel
+}
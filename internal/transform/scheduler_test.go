@@ -0,0 +1,182 @@
+package transform
+
+import (
+	"context"
+	"errors"
+	"image"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abbit/m4k/internal/comicbook"
+)
+
+// admitted reports if admission finished within a short time
+func admitted(done <-chan error) bool {
+	select {
+	case <-done:
+		return true
+	case <-time.After(50 * time.Millisecond):
+		return false
+	}
+}
+
+func TestSchedulerAdmit(t *testing.T) {
+	s := NewScheduler(100)
+	ctx := context.Background()
+
+	release, err := s.admit(ctx, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.InUse() != 60 {
+		t.Errorf("%d bytes are in use, want 60", s.InUse())
+	}
+
+	// the second page doesn't fit until the first is released
+	done := make(chan error, 1)
+	var releaseSecond func()
+	go func() {
+		var err error
+		releaseSecond, err = s.admit(ctx, 60)
+		done <- err
+	}()
+	if admitted(done) {
+		t.Fatal("page over memory limit is admitted")
+	}
+	release()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if s.InUse() != 60 {
+		t.Errorf("%d bytes are in use, want 60", s.InUse())
+	}
+	releaseSecond()
+	if s.InUse() != 0 {
+		t.Errorf("%d bytes are in use after release, want 0", s.InUse())
+	}
+}
+
+func TestSchedulerAdmitLargePage(t *testing.T) {
+	s := NewScheduler(100)
+	ctx := context.Background()
+
+	// page larger than limit is transformed alone
+	release, err := s.admit(ctx, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.InUse() != 500 {
+		t.Errorf("%d bytes are in use, want 500", s.InUse())
+	}
+	done := make(chan error, 1)
+	go func() {
+		release, err := s.admit(ctx, 1)
+		if err == nil {
+			release()
+		}
+		done <- err
+	}()
+	if admitted(done) {
+		t.Fatal("page is admitted with page larger than limit")
+	}
+	release()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSchedulerAdmitCanceled(t *testing.T) {
+	s := NewScheduler(100)
+	release, err := s.admit(context.Background(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.admit(ctx, 50)
+		done <- err
+	}()
+	if admitted(done) {
+		t.Fatal("page over memory limit is admitted")
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("error is %v, want canceled", err)
+	}
+
+	// canceled page holds no memory
+	release()
+	if s.InUse() != 0 {
+		t.Errorf("%d bytes are in use, want 0", s.InUse())
+	}
+	release, err = s.admit(context.Background(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestNewScheduler(t *testing.T) {
+	if got := NewScheduler(0).Limit(); got != DefaultMemoryBytes {
+		t.Errorf("default limit is %d, want %d", got, DefaultMemoryBytes)
+	}
+	if got := NewScheduler(100).Limit(); got != 100 {
+		t.Errorf("limit is %d, want 100", got)
+	}
+}
+
+func TestEstimateMemory(t *testing.T) {
+	data, err := encodeImage(grayImage(100, 200, 0), "png", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &Options{Width: 50, Height: 50}
+	page := &comicbook.Page{Data: data}
+	broken := &comicbook.Page{Data: []byte("broken")}
+
+	const screen = 50 * 50 * bytesPerPixel * screenCopies
+	if got, want := estimateMemory(page, opts), int64(100*200*bytesPerPixel*sourceCopies+screen); got != want {
+		t.Errorf("page memory is %d, want %d", got, want)
+	}
+	if got, want := estimateMemory(broken, opts), int64(2*screen); got != want {
+		t.Errorf("broken page memory is %d, want %d", got, want)
+	}
+	if got, want := estimateStripMemory([]*comicbook.Page{broken, page}, opts), estimateMemory(page, opts)+50*50*bytesPerPixel; got != want {
+		t.Errorf("strip memory is %d, want %d", got, want)
+	}
+}
+
+func TestTransformComicBookScheduler(t *testing.T) {
+	images := make([]image.Image, 8)
+	for i := range images {
+		images[i] = grayImage(100, 200, 128)
+	}
+	cb := testBook(t, images...)
+	opts := &Options{Width: 100, Height: 200, Encoding: "png"}
+	pageMemory := estimateMemory(cb.Pages[0], opts)
+
+	// limit fits a single page at once
+	opts.Scheduler = NewScheduler(pageMemory)
+	var mu sync.Mutex
+	var maxMemory int64
+	opts.Callback = func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		maxMemory = max(maxMemory, e.MemoryBytes)
+	}
+	if err := TransformComicBook(context.Background(), cb, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(cb.Pages) != len(images) {
+		t.Errorf("got %d pages, want %d", len(cb.Pages), len(images))
+	}
+	if maxMemory != pageMemory {
+		t.Errorf("memory of pages transformed at once is %d, want %d", maxMemory, pageMemory)
+	}
+	if opts.Scheduler.InUse() != 0 {
+		t.Errorf("%d bytes are in use after transformation, want 0", opts.Scheduler.InUse())
+	}
+}
@@ -0,0 +1,89 @@
+package transform
+
+import (
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// SpreadMode is a way landscape pages, usually double-page spreads, are laid out
+type SpreadMode string
+
+const (
+	// fit into double screen width
+	SpreadDouble SpreadMode = "double"
+	// rotate to fit portrait screen
+	SpreadRotate SpreadMode = "rotate"
+	// split into left and right halves, ordered in reading direction
+	SpreadSplit SpreadMode = "split"
+)
+
+var SpreadModes = []SpreadMode{SpreadDouble, SpreadRotate, SpreadSplit}
+
+func ParseSpreadMode(s string) (SpreadMode, error) {
+	for _, mode := range SpreadModes {
+		if strings.EqualFold(s, string(mode)) {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("unknown spread mode %q, expected one of %v", s, SpreadModes)
+}
+
+func (opts *Options) spreadMode() SpreadMode {
+	switch {
+	case len(opts.Spread) > 0:
+		// options are validated, modes are matched case-insensitively
+		mode, _ := ParseSpreadMode(string(opts.Spread))
+		return mode
+	case opts.Rotate:
+		return SpreadRotate
+	default:
+		return SpreadDouble
+	}
+}
+
+// layoutPart is an image to be fitted into width and screen height
+type layoutPart struct {
+	img   image.Image
+	width int
+}
+
+// layoutSpread lays out landscape image according to spread mode
+func layoutSpread(img image.Image, opts *Options) []layoutPart {
+	size := img.Bounds().Size()
+	if size.X <= size.Y {
+		return []layoutPart{{img, opts.Width}}
+	}
+
+	switch opts.spreadMode() {
+	case SpreadRotate:
+		return []layoutPart{{imaging.Rotate90(img), opts.Width}}
+	case SpreadSplit:
+		var parts []layoutPart
+		if opts.KeepSpread {
+			parts = append(parts, layoutPart{imaging.Rotate90(img), opts.Width})
+		}
+
+		bounds := img.Bounds()
+		middle := bounds.Min.X + size.X/2
+		left := cropImage(img, image.Rect(bounds.Min.X, bounds.Min.Y, middle, bounds.Max.Y))
+		right := cropImage(img, image.Rect(middle, bounds.Min.Y, bounds.Max.X, bounds.Max.Y))
+		first, second := left, right
+		if opts.RightToLeft {
+			first, second = right, left
+		}
+		return append(parts, layoutPart{first, opts.Width}, layoutPart{second, opts.Width})
+	default:
+		return []layoutPart{{img, 2 * opts.Width}}
+	}
+}
+
+// cropImage crops image, grayscale images are cropped without copying
+func cropImage(img image.Image, r image.Rectangle) image.Image {
+	if gray, ok := img.(*image.Gray); ok {
+		return gray.SubImage(r)
+	}
+	return imaging.Crop(img, r)
+}
@@ -0,0 +1,192 @@
+package transform
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"testing"
+
+	"github.com/abbit/m4k/internal/comicbook"
+	"github.com/disintegration/imaging"
+)
+
+// spreadImage returns landscape spread with black left and white right page
+func spreadImage() *image.Gray {
+	img := grayImage(200, 100, 255)
+	fillGray(img, image.Rect(0, 0, 100, 100), 0)
+	return img
+}
+
+// testBook returns book of a single chapter with pages of given images, encoded as PNG
+func testBook(t *testing.T, images ...image.Image) *comicbook.ComicBook {
+	t.Helper()
+	info := &comicbook.ChapterInfo{Name: "Test", Number: 1, Volume: 1}
+	cb := &comicbook.ComicBook{Name: "Test"}
+	for _, img := range images {
+		data, err := encodeImage(img, "png", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cb.Pages = append(cb.Pages, &comicbook.Page{Data: data, Extension: ".png", ChapterInfo: info})
+	}
+	cb.Renumber()
+	return cb
+}
+
+// decodePage returns decoded page image
+func decodePage(t *testing.T, p *comicbook.Page) image.Image {
+	t.Helper()
+	img, err := imaging.Decode(bytes.NewReader(p.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestLayoutSpread(t *testing.T) {
+	const black, white = 0, 255
+	tests := []struct {
+		name string
+		img  image.Image
+		opts Options
+		// size, screen width and brightness of the middle of parts
+		sizes  []image.Point
+		widths []int
+		levels []uint8
+	}{
+		{
+			name:   "portrait page",
+			img:    grayImage(100, 200, black),
+			opts:   Options{Spread: SpreadSplit},
+			sizes:  []image.Point{{100, 200}},
+			widths: []int{300},
+			levels: []uint8{black},
+		},
+		{
+			name:   "double",
+			img:    spreadImage(),
+			sizes:  []image.Point{{200, 100}},
+			widths: []int{600},
+			levels: []uint8{white},
+		},
+		{
+			name:   "rotate",
+			img:    spreadImage(),
+			opts:   Options{Rotate: true},
+			sizes:  []image.Point{{100, 200}},
+			widths: []int{300},
+		},
+		{
+			name:   "split left to right",
+			img:    spreadImage(),
+			opts:   Options{Spread: SpreadSplit},
+			sizes:  []image.Point{{100, 100}, {100, 100}},
+			widths: []int{300, 300},
+			levels: []uint8{black, white},
+		},
+		{
+			name:   "split right to left",
+			img:    spreadImage(),
+			opts:   Options{Spread: SpreadSplit, RightToLeft: true},
+			sizes:  []image.Point{{100, 100}, {100, 100}},
+			widths: []int{300, 300},
+			levels: []uint8{white, black},
+		},
+		{
+			name:   "split keeping spread",
+			img:    spreadImage(),
+			opts:   Options{Spread: "Split", KeepSpread: true, RightToLeft: true},
+			sizes:  []image.Point{{100, 200}, {100, 100}, {100, 100}},
+			widths: []int{300, 300, 300},
+		},
+		{
+			name:   "split of cropped spread",
+			img:    spreadImage().SubImage(image.Rect(50, 0, 150, 60)),
+			opts:   Options{Spread: SpreadSplit},
+			sizes:  []image.Point{{50, 60}, {50, 60}},
+			widths: []int{300, 300},
+			levels: []uint8{black, white},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.Width = 300
+			parts := layoutSpread(tt.img, &opts)
+			if len(parts) != len(tt.sizes) {
+				t.Fatalf("got %d parts, want %d", len(parts), len(tt.sizes))
+			}
+			for i, part := range parts {
+				size := part.img.Bounds().Size()
+				if size != tt.sizes[i] {
+					t.Errorf("part %d is %v, want %v", i, size, tt.sizes[i])
+				}
+				if part.width != tt.widths[i] {
+					t.Errorf("part %d is fitted into width %d, want %d", i, part.width, tt.widths[i])
+				}
+				if i < len(tt.levels) {
+					if got := grayAt(part.img, size.X*3/4, size.Y/2); got != tt.levels[i] {
+						t.Errorf("part %d has level %d, want %d", i, got, tt.levels[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTransformComicBookSplitsSpreads(t *testing.T) {
+	tests := []struct {
+		name        string
+		rightToLeft bool
+		// brightness of pages in the middle
+		levels []uint8
+	}{
+		{"left to right", false, []uint8{128, 0, 255, 64}},
+		{"right to left", true, []uint8{128, 255, 0, 64}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := testBook(t, grayImage(100, 200, 128), spreadImage(), grayImage(100, 200, 64))
+			cb.Metadata.RightToLeft = tt.rightToLeft
+			info := cb.Pages[0].ChapterInfo
+
+			opts := &Options{Width: 100, Height: 200, Encoding: "png", Spread: SpreadSplit, MaxUpscale: 1}
+			if err := TransformComicBook(context.Background(), cb, opts); err != nil {
+				t.Fatal(err)
+			}
+			if len(cb.Pages) != len(tt.levels) {
+				t.Fatalf("got %d pages, want %d", len(cb.Pages), len(tt.levels))
+			}
+			for i, p := range cb.Pages {
+				if p.Number != uint64(i+1) {
+					t.Errorf("page %d has number %d", i, p.Number)
+				}
+				if p.ChapterInfo != info {
+					t.Errorf("page %d has chapter %v, want %v", i, p.ChapterInfo, info)
+				}
+				img := decodePage(t, p)
+				if got := grayAt(img, img.Bounds().Dx()/2, img.Bounds().Dy()/2); got != tt.levels[i] {
+					t.Errorf("page %d has level %d, want %d", i, got, tt.levels[i])
+				}
+			}
+			// chapter starts at the first page after split
+			if toc := cb.TableOfContents(); len(toc) != 1 || toc[0].Page != 0 {
+				t.Errorf("table of contents is %+v", toc)
+			}
+		})
+	}
+}
+
+func TestParseSpreadMode(t *testing.T) {
+	for _, mode := range SpreadModes {
+		if got, err := ParseSpreadMode(string(mode)); err != nil || got != mode {
+			t.Errorf("ParseSpreadMode(%q) = %q, %v", mode, got, err)
+		}
+	}
+	if got, err := ParseSpreadMode("ROTATE"); err != nil || got != SpreadRotate {
+		t.Errorf("ParseSpreadMode(%q) = %q, %v", "ROTATE", got, err)
+	}
+	if _, err := ParseSpreadMode("fold"); err == nil {
+		t.Error("unknown spread mode is parsed")
+	}
+}
@@ -0,0 +1,111 @@
+package transform
+
+import (
+	"image"
+)
+
+// built-in stages, in order of default pipeline
+func init() {
+	mustRegisterStage("luma", frameStage(lumaStage))
+	mustRegisterStage("crop", frameStage(cropStage))
+	mustRegisterStage("spread", StageFunc(spreadStage))
+	mustRegisterStage("fit", frameStage(fitStage))
+	mustRegisterStage("color", frameStage(colorStage))
+	mustRegisterStage("grayscale", frameStage(grayscaleStage))
+	mustRegisterStage("tone", frameStage(toneStage))
+	mustRegisterStage("quantize", frameStage(quantizeStage))
+	mustRegisterStage("encode", frameStage(encodeStage))
+
+	defaultPipeline = MustParsePipeline(DefaultPreset)
+}
+
+var defaultPipeline *Pipeline
+
+// lumaStage keeps only luminance of grayscale and JPEG pages, unless color pages are kept,
+// so that the following stages process a single channel
+func lumaStage(f *Frame, opts *Options) error {
+	if opts.Color.Enabled {
+		return nil
+	}
+	if gray, ok := luminance(f.Image); ok {
+		f.Image = gray
+	}
+	return nil
+}
+
+// cropStage crops margins of page, strips are cropped before slicing
+// and generated pages are kept as they are
+func cropStage(f *Frame, opts *Options) error {
+	if !f.Strip && !f.Generated {
+		f.Image = cropMargins(f.Image, &opts.Crop)
+	}
+	return nil
+}
+
+// spreadStage lays out landscape page according to spread mode
+func spreadStage(f *Frame, opts *Options) ([]*Frame, error) {
+	if f.Strip {
+		return []*Frame{f}, nil
+	}
+
+	var frames []*Frame
+	for _, part := range layoutSpread(f.Image, opts) {
+		frame := *f
+		frame.Image, frame.Width = part.img, part.width
+		frames = append(frames, &frame)
+	}
+	return frames, nil
+}
+
+// fitStage scales page into its screen area
+func fitStage(f *Frame, opts *Options) error {
+	f.Image = fitImage(f.Image, f.Width, opts.Height, opts)
+	return nil
+}
+
+// colorStage keeps color pages in color if enabled and tunes their colors
+func colorStage(f *Frame, opts *Options) error {
+	if opts.Color.Enabled && isColorImage(f.Image, &opts.Color) {
+		f.Color = true
+		f.Image = adjustColor(f.Image, &opts.Color)
+	}
+	return nil
+}
+
+// grayscaleStage converts pages which aren't kept in color to grayscale
+func grayscaleStage(f *Frame, opts *Options) error {
+	if !f.Color {
+		f.Image = toGray(f.Image)
+	}
+	return nil
+}
+
+// toneStage maps tone of pages which aren't kept in color, converting them to grayscale
+func toneStage(f *Frame, opts *Options) error {
+	if !f.Color && opts.Tone.enabled() {
+		f.Image = adjustTone(toGray(f.Image), &opts.Tone)
+	}
+	return nil
+}
+
+// quantizeStage quantizes pages which aren't kept in color to gray levels
+func quantizeStage(f *Frame, opts *Options) error {
+	if !f.Color && opts.Levels > 0 {
+		f.Image = quantize(toGray(f.Image), opts.Levels, opts.dither())
+	}
+	return nil
+}
+
+// encodeStage encodes page, fitting it into size budget
+func encodeStage(f *Frame, opts *Options) error {
+	var gray *image.Gray
+	if !f.Color {
+		gray = toGray(f.Image)
+	}
+	page, err := encodeBudgeted(f.Image, gray, opts)
+	if err != nil {
+		return err
+	}
+	f.Data, f.Encoding, f.Quality = page.data, page.encoding, page.quality
+	return nil
+}
@@ -0,0 +1,290 @@
+package transform
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"strings"
+	"time"
+
+	"github.com/abbit/m4k/internal/comicbook"
+	"github.com/disintegration/imaging"
+)
+
+// StripMode tells if chapters are long vertical strips, as usual for webtoons and manhwa.
+// Strips of a chapter are stitched together and sliced into pages of screen height.
+type StripMode string
+
+const (
+	// detect strips by aspect ratio of pages
+	StripAuto StripMode = "auto"
+	StripOn   StripMode = "on"
+	StripOff  StripMode = "off"
+)
+
+const (
+	// pages with height to width ratio starting from this are strips
+	DefaultStripAspect = 3.0
+	// maximum brightness range of a row to be a gutter between panels
+	stripGutterTolerance = 16
+	// page can be shortened by up to 1/stripMaxShortening of screen height to cut at gutter
+	stripMaxShortening = 2
+)
+
+var StripModes = []StripMode{StripAuto, StripOn, StripOff}
+
+func ParseStripMode(s string) (StripMode, error) {
+	for _, mode := range StripModes {
+		if strings.EqualFold(s, string(mode)) {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("unknown strip mode %q, expected one of %v", s, StripModes)
+}
+
+// isStripChapter reports if story pages of chapter should be transformed as strips.
+// In auto mode, chapter is a strip if at least half of its pages are tall enough.
+func isStripChapter(pages []*comicbook.Page, opts *Options) bool {
+	// options are validated, modes are matched case-insensitively
+	mode, _ := ParseStripMode(string(opts.Strip))
+	switch mode {
+	case StripOn:
+		return true
+	case StripOff:
+		return false
+	}
+
+	aspect := opts.StripAspect
+	if aspect <= 0 {
+		aspect = DefaultStripAspect
+	}
+
+	strips, total := 0, 0
+	for _, p := range pages {
+		total++
+		config, _, err := image.DecodeConfig(bytes.NewReader(p.Data))
+		if err != nil || config.Width == 0 {
+			continue
+		}
+		if float64(config.Height)/float64(config.Width) >= aspect {
+			strips++
+		}
+	}
+	return total > 0 && 2*strips >= total
+}
+
+// stripImage is stitched strip, grayscale or, for color screens, color
+type stripImage interface {
+	draw.Image
+	SubImage(r image.Rectangle) image.Image
+}
+
+func newStripImage(r image.Rectangle, color bool) stripImage {
+	if color {
+		return image.NewNRGBA(r)
+	}
+	return image.NewGray(r)
+}
+
+// transformStrip stitches pages vertically and slices them into screen sized pages,
+// cutting at gutters where possible. Strips are processed one by one,
+// so only rows which aren't sliced yet are kept in memory.
+// Failed pages are handled according to Options.OnError one by one, the rest of the strip is kept.
+func transformStrip(ctx context.Context, pages []*comicbook.Page, opts *Options, repairs *comicbook.RepairReport) ([]*comicbook.Page, error) {
+	var sliced []*comicbook.Page
+	// pages sliced since the last finished event
+	var emitted []*Frame
+
+	// failed page is handled by policy, unless transformation is canceled
+	handleError := func(p *comicbook.Page, pageErr error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		replaced, err := handlePageError(p.Filepath(), p, pageErr, opts, repairs)
+		if err != nil {
+			return fmt.Errorf("while transforming page %s: %w", p.Filepath(), err)
+		}
+		sliced = append(sliced, replaced...)
+		return nil
+	}
+
+	// emit transforms page sliced from strip, its failure is blamed on source page p
+	emit := func(p *comicbook.Page, img image.Image) error {
+		frames, err := opts.pipeline().run(&Frame{Image: img, Width: opts.Width, Strip: true}, opts)
+		if err != nil {
+			return handleError(p, fmt.Errorf("while transforming image: %w", err))
+		}
+		emitted = append(emitted, frames...)
+		for _, frame := range frames {
+			sliced = append(sliced, &comicbook.Page{
+				Data:        frame.Data,
+				Extension:   "." + frame.Encoding,
+				ChapterInfo: pages[0].ChapterInfo,
+				Kind:        comicbook.PageKindStory,
+				Color:       frame.Color,
+			})
+		}
+		return nil
+	}
+
+	var pending stripImage
+	// flush emits rest of the strip, unless it's blank
+	flush := func(p *comicbook.Page) error {
+		rest := pending
+		pending = nil
+		if rest == nil || isBlank(rest) {
+			return nil
+		}
+		return emit(p, rest)
+	}
+
+	for i, p := range pages {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		started := time.Now()
+		opts.emit(Event{Kind: EventPageStarted, Page: p.Filepath(), BytesIn: len(p.Data)})
+
+		img, err := imaging.Decode(bytes.NewReader(p.Data))
+		if err != nil && opts.Tolerant {
+			img, err = recoverImage(p, opts, err, repairs), nil
+		}
+		if err != nil {
+			// strip is broken at failed page, so pages sliced before it keep their place
+			if i > 0 {
+				if err := flush(pages[i-1]); err != nil {
+					return nil, err
+				}
+			}
+			if err := handleError(p, fmt.Errorf("while decoding image: %w", err)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		pending = appendRows(pending, scaleStrip(img, opts))
+		for pending.Bounds().Dy() > opts.Height {
+			bounds := pending.Bounds()
+			cut := bounds.Min.Y + findCut(pending, opts.Height)
+			if err := emit(p, pending.SubImage(image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Max.X, cut))); err != nil {
+				return nil, err
+			}
+			pending = pending.SubImage(image.Rect(bounds.Min.X, cut, bounds.Max.X, bounds.Max.Y)).(stripImage)
+		}
+
+		if i == len(pages)-1 {
+			if err := flush(p); err != nil {
+				return nil, err
+			}
+		}
+
+		opts.emitFinished(p.Filepath(), len(p.Data), started, emitted, false)
+		emitted = nil
+	}
+
+	return sliced, nil
+}
+
+// scaleStrip scales strip to screen width, centering it if upscale limit is reached
+func scaleStrip(img image.Image, opts *Options) stripImage {
+	// strips are stitched in grayscale anyway
+	if !opts.Color.Enabled && opts.pipeline().has("luma") {
+		if gray, ok := luminance(img); ok {
+			img = gray
+		}
+	}
+
+	size := img.Bounds().Size()
+	maxUpscale := opts.MaxUpscale
+	if maxUpscale <= 0 {
+		maxUpscale = DefaultMaxUpscale
+	}
+	width := min(opts.Width, int(float64(size.X)*maxUpscale))
+	height := max(1, size.Y*width/size.X)
+	if width != size.X {
+		img = resizeImage(img, width, height)
+	}
+
+	strip := newStripImage(image.Rect(0, 0, opts.Width, height), opts.Color.Enabled)
+	offset := image.Pt((opts.Width-width)/2, 0)
+	r := image.Rectangle{offset, offset.Add(image.Pt(width, height))}
+
+	// draw doesn't have fast path for grayscale images, their rows are copied
+	dst, dstGray := strip.(*image.Gray)
+	src, srcGray := img.(*image.Gray)
+	if !dstGray || !srcGray {
+		draw.Draw(strip, strip.Bounds(), image.White, image.Point{}, draw.Src)
+		draw.Draw(strip, r, img, img.Bounds().Min, draw.Src)
+		return strip
+	}
+	for i := range dst.Pix {
+		dst.Pix[i] = 0xff
+	}
+	for y := 0; y < height; y++ {
+		copy(dst.Pix[dst.PixOffset(r.Min.X, y):dst.PixOffset(r.Max.X, y)], src.Pix[src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y+y):])
+	}
+	return strip
+}
+
+// appendRows returns image with rows of b below rows of a
+func appendRows(a, b stripImage) stripImage {
+	if a == nil || a.Bounds().Dy() == 0 {
+		return b
+	}
+
+	width := a.Bounds().Dx()
+	_, color := b.(*image.NRGBA)
+	result := newStripImage(image.Rect(0, 0, width, a.Bounds().Dy()+b.Bounds().Dy()), color)
+	draw.Draw(result, a.Bounds().Sub(a.Bounds().Min), a, a.Bounds().Min, draw.Src)
+	draw.Draw(result, image.Rect(0, a.Bounds().Dy(), width, result.Bounds().Dy()), b, b.Bounds().Min, draw.Src)
+	return result
+}
+
+// findCut returns height of the next page, preferring the lowest gutter row
+// or, if there are no gutters, the least busy row
+func findCut(img stripImage, height int) int {
+	minHeight := height - height/stripMaxShortening
+	best, bestRange := height, 256
+	for y := height; y >= minHeight; y-- {
+		r := rowRange(img, y)
+		if r <= stripGutterTolerance {
+			return y
+		}
+		if r < bestRange {
+			best, bestRange = y, r
+		}
+	}
+	return best
+}
+
+// rowRange returns difference between the brightest and the darkest pixel of a row
+func rowRange(img stripImage, y int) int {
+	bounds := img.Bounds()
+	lo, hi := 255, 0
+	switch img := img.(type) {
+	case *image.Gray:
+		row := img.Pix[img.PixOffset(bounds.Min.X, bounds.Min.Y+y):img.PixOffset(bounds.Max.X, bounds.Min.Y+y)]
+		for _, v := range row {
+			lo, hi = min(lo, int(v)), max(hi, int(v))
+		}
+	case *image.NRGBA:
+		row := img.Pix[img.PixOffset(bounds.Min.X, bounds.Min.Y+y):img.PixOffset(bounds.Max.X, bounds.Min.Y+y)]
+		for i := 0; i < len(row); i += 4 {
+			// luminance, same weights as image/color
+			v := (299*int(row[i]) + 587*int(row[i+1]) + 114*int(row[i+2])) / 1000
+			lo, hi = min(lo, v), max(hi, v)
+		}
+	}
+	return hi - lo
+}
+
+func isBlank(img stripImage) bool {
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		if rowRange(img, y) > stripGutterTolerance {
+			return false
+		}
+	}
+	return true
+}
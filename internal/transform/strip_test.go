@@ -0,0 +1,168 @@
+package transform
+
+import (
+	"context"
+	"image"
+	"reflect"
+	"testing"
+
+	"github.com/abbit/m4k/internal/comicbook"
+)
+
+// panelsImage returns strip of panels between white gutters given as row ranges
+func panelsImage(width, height int, gutters ...[2]int) *image.Gray {
+	img := grayImage(width, height, 0)
+	// panels are busy, every other column is white
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x += 2 {
+			img.Pix[img.PixOffset(x, y)] = 255
+		}
+	}
+	for _, g := range gutters {
+		fillGray(img, image.Rect(0, g[0], width, g[1]), 255)
+	}
+	return img
+}
+
+func TestIsStripChapter(t *testing.T) {
+	page := func(width, height int) *comicbook.Page {
+		data, err := encodeImage(grayImage(width, height, 255), "png", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &comicbook.Page{Data: data}
+	}
+	tall, regular := page(10, 40), page(10, 15)
+
+	tests := []struct {
+		name  string
+		pages []*comicbook.Page
+		opts  Options
+		want  bool
+	}{
+		{"strips", []*comicbook.Page{tall, tall}, Options{}, true},
+		{"half of pages are strips", []*comicbook.Page{tall, regular}, Options{}, true},
+		{"less than half of pages are strips", []*comicbook.Page{tall, regular, regular}, Options{}, false},
+		{"regular pages", []*comicbook.Page{regular, regular}, Options{}, false},
+		{"custom aspect", []*comicbook.Page{regular}, Options{StripAspect: 1.5}, true},
+		{"on", []*comicbook.Page{regular}, Options{Strip: StripOn}, true},
+		{"off", []*comicbook.Page{tall}, Options{Strip: "OFF"}, false},
+		{"broken pages", []*comicbook.Page{{Data: []byte("broken")}}, Options{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isStripChapter(tt.pages, &tt.opts); got != tt.want {
+				t.Errorf("isStripChapter = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindCut(t *testing.T) {
+	tests := []struct {
+		name string
+		img  *image.Gray
+		want int
+	}{
+		{"gutter at screen height", panelsImage(20, 500, [2]int{290, 310}), 300},
+		{"the lowest gutter", panelsImage(20, 500, [2]int{100, 120}, [2]int{200, 210}), 209},
+		{"gutter above shortening limit", panelsImage(20, 500, [2]int{100, 120}), 300},
+		{
+			name: "the least busy row",
+			img: func() *image.Gray {
+				img := panelsImage(20, 500)
+				fillGray(img, image.Rect(0, 250, 20, 251), 100)
+				fillGray(img, image.Rect(0, 250, 10, 251), 200)
+				return img
+			}(),
+			want: 250,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findCut(tt.img, 300); got != tt.want {
+				t.Errorf("cut at %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformComicBookStrips(t *testing.T) {
+	tests := []struct {
+		name   string
+		images []image.Image
+		// heights of pages strips are sliced into
+		heights []int
+	}{
+		{
+			// strips are stitched, cut at the first gutter and then through panel
+			name: "stitched",
+			images: []image.Image{
+				panelsImage(100, 500, [2]int{240, 260}),
+				panelsImage(100, 300, [2]int{280, 300}),
+			},
+			heights: []int{259, 300, 241},
+		},
+		{
+			name:    "blank end is dropped",
+			images:  []image.Image{panelsImage(100, 350, [2]int{280, 350})},
+			heights: []int{300},
+		},
+		{
+			name:    "scaled to screen width",
+			images:  []image.Image{panelsImage(200, 1000, [2]int{560, 640})},
+			heights: []int{300, 200},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cover := grayImage(100, 300, 128)
+			cb := testBook(t, append([]image.Image{cover}, tt.images...)...)
+			cb.Pages[0].Kind = comicbook.PageKindCover
+			info := cb.Pages[0].ChapterInfo
+
+			opts := &Options{Width: 100, Height: 300, Encoding: "png", Strip: StripOn, Pipeline: MustParsePipeline("fit,encode")}
+			if err := TransformComicBook(context.Background(), cb, opts); err != nil {
+				t.Fatal(err)
+			}
+
+			// cover isn't stitched into strip
+			if cb.Pages[0].Kind != comicbook.PageKindCover {
+				t.Fatal("cover is not the first page")
+			}
+			var heights []int
+			for i, p := range cb.Pages {
+				if p.Number != uint64(i+1) {
+					t.Errorf("page %d has number %d", i, p.Number)
+				}
+				if p.ChapterInfo != info {
+					t.Errorf("page %d has chapter %v, want %v", i, p.ChapterInfo, info)
+				}
+				size := decodePage(t, p).Bounds().Size()
+				if size.X != 100 {
+					t.Errorf("page %d has width %d, want 100", i, size.X)
+				}
+				if i > 0 {
+					heights = append(heights, size.Y)
+				}
+			}
+			if !reflect.DeepEqual(heights, tt.heights) {
+				t.Errorf("strips are sliced into pages of heights %v, want %v", heights, tt.heights)
+			}
+		})
+	}
+}
+
+func TestParseStripMode(t *testing.T) {
+	for _, mode := range StripModes {
+		if got, err := ParseStripMode(string(mode)); err != nil || got != mode {
+			t.Errorf("ParseStripMode(%q) = %q, %v", mode, got, err)
+		}
+	}
+	if got, err := ParseStripMode("On"); err != nil || got != StripOn {
+		t.Errorf("ParseStripMode(%q) = %q, %v", "On", got, err)
+	}
+	if _, err := ParseStripMode("scroll"); err == nil {
+		t.Error("unknown strip mode is parsed")
+	}
+}
@@ -0,0 +1,118 @@
+package transform
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+const (
+	// share of the darkest and the brightest pixels clipped by auto levels
+	DefaultLevelsClip = 0.005
+	// levels aren't stretched for pages with smaller brightness range, like blank pages
+	minLevelsRange = 32
+)
+
+// ToneOptions configures tone mapping of grayscale pages, which makes faint scans readable on e-ink.
+// Adjustments are applied in order: levels, gamma, contrast.
+type ToneOptions struct {
+	// Stretch levels so that the darkest pixels become black and the brightest become white
+	AutoLevels bool `json:"autoLevels,omitempty"`
+	// Share of pixels clipped at each end of histogram by AutoLevels. Default: DefaultLevelsClip
+	LevelsClip float64 `json:"levelsClip,omitempty"`
+	// Gamma correction, values below 1 darken midtones and above 1 lighten them. 0 or 1 disables it
+	Gamma float64 `json:"gamma,omitempty"`
+	// Strength of sigmoidal contrast stretch around middle gray, e.g. 3 to 10. 0 disables it
+	Contrast float64 `json:"contrast,omitempty"`
+}
+
+func (o *ToneOptions) enabled() bool {
+	return o.AutoLevels || (o.Gamma > 0 && o.Gamma != 1) || o.Contrast > 0
+}
+
+func (o *ToneOptions) validate() error {
+	if o.Gamma < 0 {
+		return fmt.Errorf("gamma must not be negative, got %v", o.Gamma)
+	}
+	if o.Contrast < 0 {
+		return fmt.Errorf("contrast must not be negative, got %v", o.Contrast)
+	}
+	if o.LevelsClip < 0 || o.LevelsClip >= 0.5 {
+		return fmt.Errorf("levels clip must be in range [0, 0.5), got %v", o.LevelsClip)
+	}
+	return nil
+}
+
+// adjustTone returns grayscale image with mapped brightness
+func adjustTone(img *image.Gray, o *ToneOptions) *image.Gray {
+	lut := toneCurve(img, o)
+	bounds := img.Bounds()
+	result := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		row := img.Pix[img.PixOffset(bounds.Min.X, bounds.Min.Y+y):img.PixOffset(bounds.Max.X, bounds.Min.Y+y)]
+		out := result.Pix[result.PixOffset(0, y):]
+		for x, v := range row {
+			out[x] = lut[v]
+		}
+	}
+	return result
+}
+
+// toneCurve returns lookup table combining all adjustments
+func toneCurve(img *image.Gray, o *ToneOptions) [256]uint8 {
+	black, white := 0, 255
+	if o.AutoLevels {
+		black, white = levels(img, o.LevelsClip)
+	}
+
+	var lut [256]uint8
+	for i := range lut {
+		v := float64(i-black) / float64(white-black)
+		v = math.Max(0, math.Min(1, v))
+		if o.Gamma > 0 && o.Gamma != 1 {
+			v = math.Pow(v, 1/o.Gamma)
+		}
+		if o.Contrast > 0 {
+			v = sigmoidContrast(v, o.Contrast)
+		}
+		lut[i] = uint8(math.Round(v * 255))
+	}
+	return lut
+}
+
+// levels returns black and white points of image, clipping share of pixels at each end
+func levels(img *image.Gray, clip float64) (black, white int) {
+	if clip == 0 {
+		clip = DefaultLevelsClip
+	}
+
+	var histogram [256]int
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(bounds.Min.X, y):img.PixOffset(bounds.Max.X, y)]
+		for _, v := range row {
+			histogram[v]++
+		}
+	}
+
+	clipped := int(clip * float64(bounds.Dx()*bounds.Dy()))
+	black, white = 0, 255
+	for count := 0; black < 255 && count+histogram[black] <= clipped; black++ {
+		count += histogram[black]
+	}
+	for count := 0; white > 0 && count+histogram[white] <= clipped; white-- {
+		count += histogram[white]
+	}
+
+	if white-black < minLevelsRange {
+		return 0, 255
+	}
+	return black, white
+}
+
+// sigmoidContrast maps v in [0, 1] with S-curve keeping 0, 0.5 and 1 in place
+func sigmoidContrast(v, strength float64) float64 {
+	sigmoid := func(x float64) float64 { return 1 / (1 + math.Exp(strength*(0.5-x))) }
+	lo, hi := sigmoid(0), sigmoid(1)
+	return (sigmoid(v) - lo) / (hi - lo)
+}
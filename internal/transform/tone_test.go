@@ -0,0 +1,117 @@
+package transform
+
+import (
+	"image"
+	"testing"
+)
+
+// rampImage returns 256x1 image with brightness from black to white, limited to [black, white]
+func rampImage(black, white uint8) *image.Gray {
+	img := grayImage(256, 1, 0)
+	for i := range img.Pix {
+		img.Pix[i] = uint8(min(max(i, int(black)), int(white)))
+	}
+	return img
+}
+
+func TestLevels(t *testing.T) {
+	tests := []struct {
+		name         string
+		img          *image.Gray
+		clip         float64
+		black, white int
+	}{
+		{"default clip", rampImage(0, 255), 0, 1, 254},
+		{"faint scan", rampImage(60, 200), 0, 60, 200},
+		{"outliers are clipped", rampImage(0, 255), 0.05, 12, 243},
+		{"narrow range is kept", rampImage(100, 120), 0, 0, 255},
+		{"blank page", grayImage(10, 10, 255), 0, 0, 255},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			black, white := levels(tt.img, tt.clip)
+			if black != tt.black || white != tt.white {
+				t.Errorf("levels are [%d, %d], want [%d, %d]", black, white, tt.black, tt.white)
+			}
+		})
+	}
+}
+
+func TestToneCurve(t *testing.T) {
+	faint := rampImage(60, 200)
+	tests := []struct {
+		name string
+		img  *image.Gray
+		opts ToneOptions
+		// brightness mapped from 0, 60, 128, 200 and 255
+		want [5]uint8
+	}{
+		{"disabled", faint, ToneOptions{}, [5]uint8{0, 60, 128, 200, 255}},
+		{"neutral gamma", faint, ToneOptions{Gamma: 1}, [5]uint8{0, 60, 128, 200, 255}},
+		{"auto levels", faint, ToneOptions{AutoLevels: true}, [5]uint8{0, 0, 124, 255, 255}},
+		{"gamma darkens midtones", faint, ToneOptions{Gamma: 0.5}, [5]uint8{0, 14, 64, 157, 255}},
+		{"gamma lightens midtones", faint, ToneOptions{Gamma: 2}, [5]uint8{0, 124, 181, 226, 255}},
+		{"contrast", faint, ToneOptions{Contrast: 5}, [5]uint8{0, 40, 128, 219, 255}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lut := toneCurve(tt.img, &tt.opts)
+			got := [5]uint8{lut[0], lut[60], lut[128], lut[200], lut[255]}
+			if got != tt.want {
+				t.Errorf("tone curve maps to %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdjustTone(t *testing.T) {
+	img := rampImage(60, 200)
+	sub := img.SubImage(image.Rect(100, 0, 200, 1)).(*image.Gray)
+	result := adjustTone(sub, &ToneOptions{Gamma: 0.5})
+	if result.Bounds() != image.Rect(0, 0, 100, 1) {
+		t.Fatalf("result bounds are %v", result.Bounds())
+	}
+	// 128 is darkened to 64
+	if got := grayAt(result, 28, 0); got != 64 {
+		t.Errorf("midtone is mapped to %d, want 64", got)
+	}
+}
+
+func TestToneStage(t *testing.T) {
+	opts := &Options{Tone: ToneOptions{Gamma: 0.5}}
+	frame := &Frame{Image: grayImage(10, 10, 128)}
+	if err := toneStage(frame, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := grayAt(frame.Image, 5, 5); got != 64 {
+		t.Errorf("page is mapped to %d, want 64", got)
+	}
+
+	// color pages are tuned by color stage instead
+	frame = &Frame{Image: grayImage(10, 10, 128), Color: true}
+	if err := toneStage(frame, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := grayAt(frame.Image, 5, 5); got != 128 {
+		t.Errorf("color page is mapped to %d", got)
+	}
+}
+
+func TestToneOptionsValidate(t *testing.T) {
+	tests := []struct {
+		opts    ToneOptions
+		wantErr bool
+	}{
+		{ToneOptions{}, false},
+		{ToneOptions{AutoLevels: true, LevelsClip: 0.1, Gamma: 0.8, Contrast: 4}, false},
+		{ToneOptions{Gamma: -1}, true},
+		{ToneOptions{Contrast: -1}, true},
+		{ToneOptions{LevelsClip: -0.1}, true},
+		{ToneOptions{LevelsClip: 0.5}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.opts.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("validate(%+v) = %v", tt.opts, err)
+		}
+	}
+}
@@ -2,10 +2,17 @@ package transform
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"image"
+	"image/color"
+	"image/png"
 	"runtime"
+	"time"
 
+	"github.com/abbit/m4k/internal/cache"
 	"github.com/abbit/m4k/internal/comicbook"
+	"github.com/abbit/m4k/internal/render"
 	"github.com/disintegration/imaging"
 	"golang.org/x/sync/errgroup"
 )
@@ -27,101 +34,416 @@ type Options struct {
 
 	// optional
 
-	Rotate      bool
+	// Rotate landscape pages, same as SpreadRotate
+	Rotate bool
+	// JPEG quality, 1-100. Default: 75, or the highest quality fitting Budget
 	JpegQuality int
-	// Callback to be called after page transformation
-	Callback func()
+	// Limit of encoded page size, JPEG quality is lowered to fit it
+	Budget BudgetOptions
+	// How landscape pages are laid out. Default: SpreadDouble, or SpreadRotate with Rotate
+	Spread SpreadMode
+	// Keep rotated spread before pages split from it in SpreadSplit mode
+	KeepSpread bool
+	// Spreads are read from right to left, set for books with right-to-left metadata
+	RightToLeft bool
+	// Long strips handling. Default: StripAuto
+	Strip StripMode
+	// Minimum height to width ratio of strips for StripAuto. Default: DefaultStripAspect
+	StripAspect float64
+	// How page is fitted into screen size. Default: FitInside
+	Fit FitMode
+	// Pad fitted page to exact screen size with PadColor. Default color: white
+	Pad      bool
+	PadColor color.Color
+	// Maximum factor page can be enlarged by. Default: DefaultMaxUpscale
+	MaxUpscale float64
+	// Automatic cropping of margins, done before resizing
+	Crop CropOptions
+	// Tone mapping of grayscale pages
+	Tone ToneOptions
+	// Quantize pages to this many gray levels, usually 16 for e-ink. 0 disables quantization.
+	// Requires PNG encoding, which keeps levels exactly and writes up to 16 levels with 4 bits per pixel.
+	Levels int
+	// Dithering used by quantization. Default: DitherNone
+	Dither Dither
+	// Keeping color pages for color screens
+	Color ColorOptions
+	// Stages pages are passed through after decoding. Default: DefaultPreset
+	Pipeline *Pipeline
+	// Cache of transformed pages, keyed by page data and options. Strips aren't cached
+	Cache *cache.Cache
+	// Limits memory of pages transformed at once by TransformComicBook, besides number of CPUs.
+	// Default: no memory limit
+	Scheduler *Scheduler
+	// Repair or replace with placeholder images that can't be decoded instead of failing
+	Tolerant bool
+	// How pages failed to transform are handled by TransformComicBook. Default: ErrorFail
+	OnError ErrorPolicy
+	// Callback to be called with events of source pages, concurrently for different pages
+	Callback func(Event)
 }
 
-func TransformImage(data []byte, opts *Options) ([]byte, error) {
-	width, height := opts.Width, opts.Height
-
-	if width <= 0 || height <= 0 {
-		return nil, ErrZeroWidthHeight
+// Validate reports if options are invalid, transform functions validate them too
+func (opts *Options) Validate() error {
+	if opts.Width <= 0 || opts.Height <= 0 {
+		return ErrZeroWidthHeight
 	}
 
 	if len(opts.Encoding) == 0 {
-		return nil, ErrNoEncoding
+		return ErrNoEncoding
+	}
+	if opts.Encoding != "png" && !isJPEG(opts.Encoding) {
+		return fmt.Errorf("unsupported encoding %q, expected jpg or png", opts.Encoding)
+	}
+
+	if len(opts.Spread) > 0 {
+		if _, err := ParseSpreadMode(string(opts.Spread)); err != nil {
+			return err
+		}
+	}
+
+	if len(opts.Strip) > 0 {
+		if _, err := ParseStripMode(string(opts.Strip)); err != nil {
+			return err
+		}
+	}
+
+	if len(opts.Fit) > 0 {
+		if _, err := ParseFitMode(string(opts.Fit)); err != nil {
+			return err
+		}
+	}
+
+	if err := opts.Tone.validate(); err != nil {
+		return err
+	}
+
+	if opts.Levels < 0 || opts.Levels == 1 || opts.Levels > 256 {
+		return fmt.Errorf("levels must be 0 or in range [2, 256], got %d", opts.Levels)
+	}
+	// JPEG can't keep quantized levels and doesn't encode paletted images
+	if opts.Levels > 0 && opts.Encoding != "png" {
+		return fmt.Errorf("levels can only be used with png encoding, got %q", opts.Encoding)
+	}
+
+	if len(opts.Dither) > 0 {
+		if _, err := ParseDither(string(opts.Dither)); err != nil {
+			return err
+		}
+	}
+
+	if opts.JpegQuality < 0 || opts.JpegQuality > 100 {
+		return fmt.Errorf("jpeg quality must be in range [1, 100], got %d", opts.JpegQuality)
+	}
+
+	if err := opts.Budget.validate(opts.Encoding); err != nil {
+		return err
 	}
 
+	if len(opts.OnError) > 0 {
+		if _, err := ParseErrorPolicy(string(opts.OnError)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// TransformImage decodes image and passes it through pipeline into one or more pages,
+// spreads are split into several pages with SpreadSplit mode
+func TransformImage(data []byte, opts *Options) ([][]byte, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+	opts.emit(Event{Kind: EventPageStarted, BytesIn: len(data)})
+
 	// decode image
-	buf := bytes.NewBuffer(data)
-	img, err := imaging.Decode(buf)
+	img, err := imaging.Decode(bytes.NewReader(data))
 	if err != nil {
 		return nil, fmt.Errorf("while decoding image: %w", err)
 	}
 
-	// transform image
-	twopages := false
-	// check if image is in landscape mode
-	if imgsize := img.Bounds().Size(); imgsize.X > imgsize.Y {
-		if opts.Rotate {
-			img = imaging.Rotate90(img)
-		} else {
-			twopages = true
-		}
+	transformed, err := opts.pipeline().Run(img, opts)
+	if err != nil {
+		return nil, err
 	}
-	if twopages {
-		width *= 2
+	opts.emitFinished("", len(data), started, transformed, false)
+
+	result := make([][]byte, 0, len(transformed))
+	for _, frame := range transformed {
+		result = append(result, frame.Data)
 	}
-	img = imaging.Resize(img, width, height, imaging.Lanczos)
-	img = imaging.Grayscale(img)
+	return result, nil
+}
 
-	// encode image
-	buf.Reset()
-	switch opts.Encoding {
-	case "png":
-		err = imaging.Encode(buf, img, imaging.PNG)
-	case "jpeg", "jpg":
-		jpegQuality := opts.JpegQuality
-		if jpegQuality == 0 {
-			jpegQuality = defaultJpegQuality
+func (opts *Options) jpegQuality() int {
+	if opts.JpegQuality == 0 {
+		return defaultJpegQuality
+	}
+	return opts.JpegQuality
+}
+
+func isJPEG(encoding string) bool {
+	return encoding == "jpg" || encoding == "jpeg"
+}
+
+func encodeImage(img image.Image, encoding string, jpegQuality int) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch {
+	case encoding == "png":
+		if paletted, ok := img.(*image.Paletted); ok {
+			// bit depth is chosen by palette size, 4 bits for 16 levels
+			encoder := png.Encoder{CompressionLevel: png.BestCompression}
+			err = encoder.Encode(&buf, paletted)
+		} else {
+			err = imaging.Encode(&buf, img, imaging.PNG)
 		}
-		err = imaging.Encode(buf, img, imaging.JPEG, imaging.JPEGQuality(jpegQuality))
+	case isJPEG(encoding):
+		err = imaging.Encode(&buf, img, imaging.JPEG, imaging.JPEGQuality(jpegQuality))
 	default:
-		err = fmt.Errorf("unsupported encoding: %s", opts.Encoding)
+		err = fmt.Errorf("unsupported encoding: %s", encoding)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("while encoding image: %w", err)
 	}
 
-	// call callback
-	if opts.Callback != nil {
-		opts.Callback()
-	}
-
 	return buf.Bytes(), nil
 }
 
-func TransformPage(p *comicbook.Page, opts *Options) error {
+// TransformPage transforms page image, returning the page followed by pages
+// split from it. With tolerant option, broken images are repaired or replaced
+// with placeholder, which is reported to repairs.
+func TransformPage(p *comicbook.Page, opts *Options, repairs *comicbook.RepairReport) ([]*comicbook.Page, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+	bytesIn := len(p.Data)
+	opts.emit(Event{Kind: EventPageStarted, Page: p.Filepath(), BytesIn: bytesIn})
+
+	// pages transformed with the same options before are taken from cache,
+	// generated pages are unique to book and aren't cached
+	generated := p.Kind != comicbook.PageKindStory
+	var cacheKey string
+	if opts.Cache != nil && !generated {
+		cacheKey = opts.cacheKey(p.Data)
+		if transformed, ok := loadCachedFrames(opts.Cache, cacheKey); ok {
+			opts.emitFinished(p.Filepath(), bytesIn, started, transformed, true)
+			return updatePage(p, transformed), nil
+		}
+	}
+
+	img, err := imaging.Decode(bytes.NewReader(p.Data))
+	if err != nil {
+		if !opts.Tolerant {
+			return nil, fmt.Errorf("while decoding image: %w", err)
+		}
+		img = recoverImage(p, opts, err, repairs)
+		// repairs are reported only when page is transformed
+		cacheKey = ""
+	}
+
 	// transform page image
-	transformed, err := TransformImage(p.Data, opts)
+	transformed, err := opts.pipeline().run(&Frame{Image: img, Width: opts.Width, Generated: generated}, opts)
 	if err != nil {
-		return fmt.Errorf("while transforming image: %w", err)
+		return nil, fmt.Errorf("while transforming image: %w", err)
+	}
+	if len(cacheKey) > 0 {
+		// cache is best effort, page is transformed anyway
+		_ = storeCachedFrames(opts.Cache, cacheKey, transformed)
 	}
 
-	// update page
-	p.Data = transformed
-	p.Extension = "." + opts.Encoding
+	opts.emitFinished(p.Filepath(), bytesIn, started, transformed, false)
 
-	return nil
+	return updatePage(p, transformed), nil
 }
 
-func TransformComicBook(cb *comicbook.ComicBook, opts *Options) error {
-	var eg errgroup.Group
+// updatePage sets transformed image to page, extra pages are placed right after it.
+// Page is dropped if pipeline returned no images.
+func updatePage(p *comicbook.Page, transformed []*Frame) []*comicbook.Page {
+	var pages []*comicbook.Page
+	for i, frame := range transformed {
+		page := p
+		if i > 0 {
+			page = &comicbook.Page{ChapterInfo: p.ChapterInfo, Kind: p.Kind}
+		}
+		page.Data = frame.Data
+		page.Extension = "." + frame.Encoding
+		page.Color = frame.Color
+		pages = append(pages, page)
+	}
+	return pages
+}
+
+// recoverImage tries to decode repaired image data,
+// falling back to placeholder with page path
+func recoverImage(p *comicbook.Page, opts *Options, decodeErr error, repairs *comicbook.RepairReport) image.Image {
+	opts.emit(Event{Kind: EventWarning, Page: p.Filepath(), Err: decodeErr})
+
+	if img, err := decodeTruncatedJPEG(p.Data); err == nil {
+		repairs.Add(p.Filepath(), comicbook.RepairRecovered, decodeErr)
+		return img
+	}
+
+	img, err := render.Placeholder(p.Filepath(), opts.Width, opts.Height)
+	if err != nil {
+		// can't happen with validated options, but keep the page blank anyway
+		img = image.NewGray(image.Rect(0, 0, opts.Width, opts.Height))
+	}
+	repairs.Add(p.Filepath(), comicbook.RepairReplaced, decodeErr)
+	return img
+}
+
+// TransformComicBook transforms all pages of the book, stopping when ctx is done.
+// Pages failed to transform are handled according to Options.OnError.
+func TransformComicBook(ctx context.Context, cb *comicbook.ComicBook, opts *Options) error {
+	// invalid options would fail every page, which isn't a page error
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	eg, ctx := errgroup.WithContext(ctx)
 	// limit number of goroutines for image processing to cpu cores - 1
 	// to leave some space for other tasks
 	eg.SetLimit(max(1, runtime.NumCPU()-1))
 
+	if cb.Repairs == nil {
+		cb.Repairs = &comicbook.RepairReport{}
+	}
+
+	// spreads are read in book's direction
+	pageOpts := *opts
+	pageOpts.RightToLeft = opts.RightToLeft || cb.Metadata.RightToLeft
+	if opts.Budget.BookBytes > 0 {
+		// book budget is shared by pages the book is transformed into, not by source pages
+		pageOpts.Budget.PageBytes = opts.Budget.bookPageBytes(estimateOutputPages(cb, &pageOpts))
+	}
+
+	// failed pages are handled by policy, unless transformation is canceled
+	handleError := func(name string, p *comicbook.Page, err error) ([]*comicbook.Page, error) {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return handlePageError(name, p, err, &pageOpts, cb.Repairs)
+	}
+
+	// pages are admitted by scheduler when their memory is available
+	admit := func(memoryBytes func() int64) (func(), error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if opts.Scheduler == nil {
+			return func() {}, nil
+		}
+		return opts.Scheduler.admit(ctx, memoryBytes())
+	}
+
+	// pages transformed from each page of the book, strips are all put at the first page of chapter
+	transformed := make([][]*comicbook.Page, len(cb.Pages))
+	transformPages := func(i int, p *comicbook.Page) {
+		eg.Go(func() error {
+			release, err := admit(func() int64 { return estimateMemory(p, &pageOpts) })
+			if err != nil {
+				return err
+			}
+			defer release()
+
+			pages, err := TransformPage(p, &pageOpts, cb.Repairs)
+			if err != nil {
+				if pages, err = handleError(p.Filepath(), p, err); err != nil {
+					return fmt.Errorf("while transforming page %s: %w", p.Filepath(), err)
+				}
+			}
+			transformed[i] = pages
+			return nil
+		})
+	}
+
+	index := 0
+	for _, chapter := range cb.ChapterPages() {
+		start := index
+		index += len(chapter)
+
+		var story []*comicbook.Page
+		for i, p := range chapter {
+			if p.Kind == comicbook.PageKindStory {
+				story = append(story, p)
+			} else {
+				// generated pages are never strips
+				transformPages(start+i, p)
+			}
+		}
+		if len(story) == 0 {
+			continue
+		}
+		if !isStripChapter(story, &pageOpts) {
+			for i, p := range chapter {
+				if p.Kind == comicbook.PageKindStory {
+					transformPages(start+i, p)
+				}
+			}
+			continue
+		}
+
+		storyIndex := start
+		for chapter[storyIndex-start].Kind != comicbook.PageKindStory {
+			storyIndex++
+		}
+		eg.Go(func() error {
+			release, err := admit(func() int64 { return estimateStripMemory(story, &pageOpts) })
+			if err != nil {
+				return err
+			}
+			defer release()
+
+			// failed pages are handled inside of strip
+			pages, err := transformStrip(ctx, story, &pageOpts, cb.Repairs)
+			if err != nil {
+				return err
+			}
+			transformed[storyIndex] = pages
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	// pages may be split, sliced, replaced or dropped,
+	// even if their number stays the same
+	pages := make([]*comicbook.Page, 0, len(cb.Pages))
+	for _, split := range transformed {
+		pages = append(pages, split...)
+	}
+	cb.Pages = pages
+	cb.Renumber()
+
+	return nil
+}
+
+// VerifyComicBook decodes all pages without transforming them,
+// pages that can't be decoded are reported to book repairs
+func VerifyComicBook(cb *comicbook.ComicBook) {
+	var eg errgroup.Group
+	eg.SetLimit(max(1, runtime.NumCPU()-1))
+
+	if cb.Repairs == nil {
+		cb.Repairs = &comicbook.RepairReport{}
+	}
+
 	for _, p := range cb.Pages {
 		p := p
 		eg.Go(func() error {
-			if err := TransformPage(p, opts); err != nil {
-				return fmt.Errorf("while transforming page %s: %w", p.Filepath(), err)
+			if _, err := imaging.Decode(bytes.NewReader(p.Data)); err != nil {
+				cb.Repairs.Add(p.Filepath(), comicbook.RepairBroken, err)
 			}
 			return nil
 		})
 	}
 
-	return eg.Wait()
+	eg.Wait()
 }
@@ -0,0 +1,292 @@
+package transform
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// fullPrecisionPipeline is the default pipeline without luma stage,
+// so pages are processed in full color precision instead of fast grayscale path
+const fullPrecisionPipeline = "crop,spread,fit,color,grayscale,tone,quantize,encode"
+
+// benchmarkPage is a scanned A4 page at 300 dpi: margins, panels, screentone and text
+var benchmarkPage = sync.OnceValue(func() []byte {
+	const width, height = 2480, 3508
+	rnd := rand.New(rand.NewSource(1))
+	img := image.NewYCbCr(image.Rect(0, 0, width, height), image.YCbCrSubsampleRatio420)
+	for i := range img.Y {
+		// paper isn't perfectly white
+		img.Y[i] = uint8(235 + rnd.Intn(12))
+	}
+	for i := range img.Cb {
+		img.Cb[i], img.Cr[i] = 126, 130
+	}
+
+	fill := func(r image.Rectangle, value func(x, y int) uint8) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				img.Y[img.YOffset(x, y)] = value(x, y)
+			}
+		}
+	}
+	black := func(x, y int) uint8 { return 20 }
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 2; col++ {
+			panel := image.Rect(150+col*1110, 200+row*1050, 150+(col+1)*1110-40, 200+(row+1)*1050-40)
+			fill(panel, black)
+			inner := panel.Inset(8)
+			// screentone gradient
+			fill(inner, func(x, y int) uint8 {
+				shade := float64(x-inner.Min.X) / float64(inner.Dx())
+				dot := math.Sin(float64(x)*0.5)*math.Sin(float64(y)*0.5) > 1-2*shade
+				if dot {
+					return 30
+				}
+				return 240
+			})
+			// speech bubble with lines of text
+			bubble := image.Rect(inner.Min.X+60, inner.Min.Y+60, inner.Min.X+460, inner.Min.Y+360)
+			fill(bubble, func(x, y int) uint8 { return 250 })
+			for line := bubble.Min.Y + 30; line+30 < bubble.Max.Y; line += 50 {
+				for x := bubble.Min.X + 30; x+20 < bubble.Max.X-30; x += 28 {
+					fill(image.Rect(x, line, x+20, line+30), func(x, y int) uint8 {
+						return uint8(20 + rnd.Intn(60))
+					})
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+})
+
+func benchmarkOptions(pipeline, encoding string) *Options {
+	return &Options{
+		Width:    1236,
+		Height:   1648,
+		Encoding: encoding,
+		Crop:     CropOptions{Enabled: true},
+		Tone:     ToneOptions{AutoLevels: true, Gamma: 0.8},
+		Pipeline: MustParsePipeline(pipeline),
+	}
+}
+
+// psnr returns peak signal-to-noise ratio of grayscale versions of encoded images in dB
+func psnr(tb testing.TB, x, y []byte) float64 {
+	tb.Helper()
+	a, err := imaging.Decode(bytes.NewReader(x))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	c, err := imaging.Decode(bytes.NewReader(y))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return imagePSNR(tb, a, c)
+}
+
+// imagePSNR returns peak signal-to-noise ratio of grayscale versions of images in dB
+func imagePSNR(tb testing.TB, a, c image.Image) float64 {
+	tb.Helper()
+	if a.Bounds().Size() != c.Bounds().Size() {
+		tb.Fatalf("sizes differ: %v and %v", a.Bounds().Size(), c.Bounds().Size())
+	}
+
+	ga, gc := imaging.Grayscale(a), imaging.Grayscale(c)
+	var sum float64
+	for i := 0; i < len(ga.Pix); i += 4 {
+		d := float64(ga.Pix[i]) - float64(gc.Pix[i])
+		sum += d * d
+	}
+	mse := sum / float64(len(ga.Pix)/4)
+	if mse == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/mse)
+}
+
+// BenchmarkTransformImage compares transformation of page by baseline TransformImage,
+// which only resized and grayscaled it, with pipelines doing much more. PSNR of pages
+// against full precision ones is reported for PNG encoding, as JPEG amplifies differences.
+func BenchmarkTransformImage(b *testing.B) {
+	data := benchmarkPage()
+	fullPrecision, err := TransformImage(data, benchmarkOptions(fullPrecisionPipeline, "png"))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("baseline", func(b *testing.B) {
+		opts := benchmarkOptions(DefaultPreset, "jpg")
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := baselineTransformImage(data, opts.Width, opts.Height, false, opts.Encoding, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	for _, bc := range []struct {
+		name     string
+		pipeline string
+	}{
+		{"full-precision", fullPrecisionPipeline},
+		{"default", DefaultPreset},
+		{"fast", "fast"},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			opts := benchmarkOptions(bc.pipeline, "jpg")
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := TransformImage(data, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+
+			// fast preset doesn't crop, so it can't be compared
+			if bc.pipeline != "fast" {
+				pages, err := TransformImage(data, benchmarkOptions(bc.pipeline, "png"))
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.ReportMetric(psnr(b, fullPrecision[0], pages[0]), "dB")
+			}
+		})
+	}
+}
+
+// TestLumaPipelineQuality checks that fast grayscale path of default pipeline
+// makes pages close to the ones processed in full precision
+func TestLumaPipelineQuality(t *testing.T) {
+	if testing.Short() {
+		t.Skip("transforms large page")
+	}
+	const minPSNR = 45
+
+	data := benchmarkPage()
+	fullPrecision, err := TransformImage(data, benchmarkOptions(fullPrecisionPipeline, "png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := TransformImage(data, benchmarkOptions(DefaultPreset, "png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := psnr(t, fullPrecision[0], pages[0]); got < minPSNR {
+		t.Errorf("PSNR of luma pipeline is %.1f dB, want at least %d dB", got, minPSNR)
+	}
+}
+
+// randomGray returns grayscale image of random brightness with smooth areas
+func randomGray(width, height int) *image.Gray {
+	rnd := rand.New(rand.NewSource(1))
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := 128 + 100*math.Sin(float64(x)/7)*math.Cos(float64(y)/5)
+			img.Pix[img.PixOffset(x, y)] = uint8(v) + uint8(rnd.Intn(20))
+		}
+	}
+	return img
+}
+
+func TestHalveGray(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 5, 3))
+	copy(img.Pix, []uint8{
+		0, 10, 100, 200, 255,
+		20, 30, 100, 101, 255,
+		255, 255, 255, 255, 255,
+	})
+	half := halveGray(img)
+	// odd row and column are dropped
+	if half.Bounds() != image.Rect(0, 0, 2, 1) {
+		t.Fatalf("halved to %v", half.Bounds())
+	}
+	// rounded average of blocks
+	if got := half.Pix[:2]; got[0] != 15 || got[1] != 125 {
+		t.Errorf("halved to %v, want [15 125]", got)
+	}
+
+	// sub image is halved from its bounds
+	sub := img.SubImage(image.Rect(1, 0, 5, 2)).(*image.Gray)
+	half = halveGray(sub)
+	if half.Bounds() != image.Rect(0, 0, 2, 1) {
+		t.Fatalf("sub image is halved to %v", half.Bounds())
+	}
+	if got := half.Pix[:2]; got[0] != 60 || got[1] != 203 {
+		t.Errorf("sub image is halved to %v, want [60 203]", got)
+	}
+}
+
+func TestLanczosGray(t *testing.T) {
+	src := randomGray(120, 90)
+	for _, size := range []image.Point{{60, 45}, {37, 71}, {240, 180}, {120, 50}, {50, 90}, {120, 90}} {
+		got := lanczosGray(src, size.X, size.Y)
+		want := imaging.Grayscale(imaging.Resize(src, size.X, size.Y, imaging.Lanczos))
+		if got.Bounds().Size() != size {
+			t.Errorf("%v: resized to %v", size, got.Bounds().Size())
+			continue
+		}
+		// same as imaging up to rounding
+		for y := 0; y < size.Y; y++ {
+			for x := 0; x < size.X; x++ {
+				if d := int(got.GrayAt(x, y).Y) - int(want.Pix[want.PixOffset(x, y)]); d < -1 || d > 1 {
+					t.Fatalf("%v: pixel (%d, %d) is %d, want %d", size, x, y, got.GrayAt(x, y).Y, want.Pix[want.PixOffset(x, y)])
+				}
+			}
+		}
+	}
+
+	// sub image is resized from its bounds
+	sub := src.SubImage(image.Rect(30, 20, 90, 80)).(*image.Gray)
+	got := lanczosGray(sub, 30, 30)
+	want := imaging.Grayscale(imaging.Resize(sub, 30, 30, imaging.Lanczos))
+	if p := imagePSNR(t, got, want); p < 45 {
+		t.Errorf("PSNR of resized sub image is %.1f dB", p)
+	}
+}
+
+func TestResizeGray(t *testing.T) {
+	// large image is halved before resampling
+	src := randomGray(800, 600)
+	got := resizeGray(src, 100, 75)
+	want := imaging.Grayscale(imaging.Resize(src, 100, 75, imaging.Lanczos))
+	if p := imagePSNR(t, got, want); p < 40 {
+		t.Errorf("PSNR of resized image is %.1f dB", p)
+	}
+}
+
+// BenchmarkResize compares resizing of decoded page to screen size
+func BenchmarkResize(b *testing.B) {
+	img, err := imaging.Decode(bytes.NewReader(benchmarkPage()))
+	if err != nil {
+		b.Fatal(err)
+	}
+	const width, height = 1165, 1648
+
+	b.Run("imaging", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			imaging.Grayscale(imaging.Resize(img, width, height, imaging.Lanczos))
+		}
+	})
+	b.Run("luma", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			gray, _ := luminance(img)
+			resizeGray(gray, width, height)
+		}
+	})
+}